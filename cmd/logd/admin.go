@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+	"github.com/ernestrc/logd/lua"
+)
+
+// adminPath is the path prefix of the admin API endpoints
+const adminPath = "/admin/"
+
+// redacted replaces the values of the config keys that hold credentials
+const redacted = "<redacted>"
+
+// control performs the runtime operations requested via signals or via the admin API
+type control struct {
+	// sandbox is replaced when the script is reloaded. It must be accessed with current
+	// or while holding sandboxLock.
+	sandbox     *lua.Sandbox
+	sandboxLock sync.RWMutex
+	// closed is set once the sandbox is shut down. It must be accessed while holding sandboxLock.
+	closed   bool
+	script   string
+	pipeline *pipelineConfig
+	// reader is nil when benchmarking
+	reader  LogReader
+	started time.Time
+	// done is closed once the pipeline has processed all the logs read by its inputs
+	done chan struct{}
+
+	lock        sync.Mutex
+	reloads     int
+	lastError   error
+	lastErrorAt time.Time
+	stopping    bool
+	finished    bool
+	// stopTimer sends a timeout error to exit if the pipeline does not finish after stop
+	stopTimer *time.Timer
+}
+
+func newControl(sandbox *lua.Sandbox, script string, pipeline *pipelineConfig, reader LogReader) *control {
+	return &control{
+		sandbox:  sandbox,
+		script:   script,
+		pipeline: pipeline,
+		reader:   reader,
+		started:  time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+// current returns the sandbox running the script
+func (c *control) current() *lua.Sandbox {
+	c.sandboxLock.RLock()
+	defer c.sandboxLock.RUnlock()
+	return c.sandbox
+}
+
+// process supplies the logs to the sandbox, which is not replaced until all of them are processed
+func (c *control) process(logs []logging.Log) (err error) {
+	c.sandboxLock.RLock()
+	defer c.sandboxLock.RUnlock()
+	if c.closed {
+		return io.EOF
+	}
+	call := c.sandbox.CallOnLog
+	if c.sandbox.ProtectedMode() {
+		call = c.sandbox.ProtectedCallOnLog
+	}
+	for i := range logs {
+		if err = call(&logs[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// stop stops the inputs so that the pipeline exits once the logs read have been processed.
+// If the inputs do not stop within timeout, an error is sent to exit. Calling stop again
+// exits immediately.
+func (c *control) stop(exit chan error, timeout time.Duration) {
+	c.lock.Lock()
+	stopping := c.stopping
+	c.stopping = true
+	c.lock.Unlock()
+
+	if stopping {
+		fmt.Fprintf(os.Stderr, "forced exit\n")
+		os.Exit(1)
+	}
+	if c.reader == nil {
+		exit <- nil
+		return
+	}
+	c.reader.Stop()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.finished {
+		c.stopTimer = time.AfterFunc(timeout, func() {
+			exit <- fmt.Errorf("timed out after %s waiting for the inputs to stop", timeout)
+		})
+	}
+}
+
+// finish signals that the pipeline has processed all the logs read by its inputs
+func (c *control) finish() {
+	c.lock.Lock()
+	c.finished = true
+	if c.stopTimer != nil {
+		c.stopTimer.Stop()
+	}
+	c.lock.Unlock()
+	close(c.done)
+}
+
+// stopPipelines stops the inputs of the pipelines that are still running and waits until they
+// have processed the logs read, or until timeout, so their sandboxes are not shut down while
+// processing logs
+func stopPipelines(controls []*control, timeout time.Duration) {
+	for _, c := range controls {
+		c.lock.Lock()
+		stopping := c.stopping
+		c.stopping = true
+		c.lock.Unlock()
+		if !stopping && c.reader != nil {
+			c.reader.Stop()
+		}
+	}
+	deadline := time.After(timeout)
+	for _, c := range controls {
+		if c.reader == nil {
+			continue
+		}
+		select {
+		case <-c.done:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// shutdown drains the outputs and closes the inputs, which persists their read positions.
+// The pipeline does not supply logs to the sandbox once it is shut down.
+func (c *control) shutdown(timeout time.Duration) (err error) {
+	c.sandboxLock.Lock()
+	err = c.sandbox.Shutdown(timeout)
+	c.closed = true
+	c.sandboxLock.Unlock()
+	if c.reader != nil {
+		if e := c.reader.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// shutdown shuts down the pipelines concurrently, so that all of them are given the timeout,
+// and returns their errors
+func shutdown(controls []*control, timeout time.Duration) (errs []error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, c := range controls {
+		wg.Add(1)
+		go func(c *control) {
+			defer wg.Done()
+			if err := c.shutdown(timeout); err != nil {
+				lock.Lock()
+				errs = append(errs, c.pipeline.pipelineError(err))
+				lock.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return
+}
+
+func writeHeapProfile(w io.Writer) error {
+	runtime.GC()
+	return pprof.WriteHeapProfile(w)
+}
+
+type adminStatus struct {
+	Pipeline      string     `json:"pipeline,omitempty"`
+	Script        string     `json:"script"`
+	Started       time.Time  `json:"started"`
+	UptimeSeconds float64    `json:"uptime_seconds"`
+	Reloads       int        `json:"reloads"`
+	Goroutines    int        `json:"goroutines"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_time,omitempty"`
+}
+
+// status returns the process status. The last error is either the last error of
+// an admin operation or the last Lua runtime error handled by logd.on_error.
+func (c *control) status() adminStatus {
+	c.lock.Lock()
+	s := adminStatus{
+		Pipeline:      c.pipeline.name,
+		Script:        c.script,
+		Started:       c.started,
+		UptimeSeconds: time.Since(c.started).Seconds(),
+		Reloads:       c.reloads,
+		Goroutines:    runtime.NumGoroutine(),
+	}
+	lastError, lastErrorAt := c.lastError, c.lastErrorAt
+	c.lock.Unlock()
+
+	if err, at := c.current().LastError(); err != nil && at.After(lastErrorAt) {
+		lastError, lastErrorAt = err, at
+	}
+	if lastError != nil {
+		s.LastError = lastError.Error()
+		s.LastErrorAt = &lastErrorAt
+	}
+	return s
+}
+
+// config returns the sandbox configuration values without credentials
+func (c *control) config() map[string]interface{} {
+	values := c.current().Config()
+	for key := range values {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "token") || strings.Contains(lower, "secret") {
+			values[key] = redacted
+		}
+	}
+	return values
+}
+
+// setConfig sets the given keys in key order
+func (c *control) setConfig(values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.current().SetConfig(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// controls are the pipelines controlled by the admin API. Endpoints take the name of the
+// pipeline in the pipeline query parameter, which can be omitted when logd runs a single
+// pipeline. Without it, status, reload and flush operate on all the pipelines.
+type controls []*control
+
+func (cs controls) names() []string {
+	names := make([]string, 0, len(cs))
+	for _, c := range cs {
+		names = append(names, c.pipeline.name)
+	}
+	return names
+}
+
+// selected returns the pipelines selected by the pipeline query parameter. If it is omitted,
+// all the pipelines are returned if all is true, or the only one that logd runs otherwise.
+func (cs controls) selected(w http.ResponseWriter, r *http.Request, all bool) (controls, bool) {
+	name := r.URL.Query().Get("pipeline")
+	if name == "" {
+		if all || len(cs) == 1 {
+			return cs, true
+		}
+		http.Error(w, fmt.Sprintf("pipeline query parameter must be one of %v", cs.names()), http.StatusBadRequest)
+		return nil, false
+	}
+	for _, c := range cs {
+		if c.pipeline.name == name {
+			return controls{c}, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("unknown pipeline '%s'", name), http.StatusNotFound)
+	return nil, false
+}
+
+// handleStatus returns the status of the selected pipeline, or an array with the status
+// of every pipeline if logd runs several and none is selected
+func (cs controls) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	selected, ok := cs.selected(w, r, true)
+	if !ok {
+		return
+	}
+	if len(selected) == 1 {
+		writeJSON(w, selected[0].status())
+		return
+	}
+	statuses := make([]adminStatus, 0, len(selected))
+	for _, c := range selected {
+		statuses = append(statuses, c.status())
+	}
+	writeJSON(w, statuses)
+}
+
+func (cs controls) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	selected, ok := cs.selected(w, r, true)
+	if !ok {
+		return
+	}
+	errs := make([]string, 0)
+	for _, c := range selected {
+		if err := c.reload(); err != nil {
+			errs = append(errs, c.pipeline.pipelineError(err).Error())
+		}
+	}
+	if len(errs) != 0 {
+		http.Error(w, strings.Join(errs, "\n"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs controls) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	selected, ok := cs.selected(w, r, true)
+	if !ok {
+		return
+	}
+	for _, c := range selected {
+		if err := c.current().Flush(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleConfig returns the values set with config_set on GET, and sets the keys
+// of the JSON object in the body on POST
+func (cs controls) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	selected, ok := cs.selected(w, r, false)
+	if !ok {
+		return
+	}
+	c := selected[0]
+	if r.Method == http.MethodGet {
+		writeJSON(w, c.config())
+		return
+	}
+	var values map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, fmt.Sprintf("body must be a JSON object: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := c.setConfig(values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs controls) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	selected, ok := cs.selected(w, r, false)
+	if !ok {
+		return
+	}
+	lister, ok := selected[0].reader.(fileLister)
+	if !ok {
+		http.Error(w, "input does not read files", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, lister.Files())
+}
+
+func handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func handleHeap(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="heap.pprof"`)
+	if err := writeHeapProfile(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// adminHandler returns the handler of the admin API endpoints
+func adminHandler(cs controls) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPath+"status", cs.handleStatus)
+	mux.HandleFunc(adminPath+"reload", cs.handleReload)
+	mux.HandleFunc(adminPath+"flush", cs.handleFlush)
+	mux.HandleFunc(adminPath+"config", cs.handleConfig)
+	mux.HandleFunc(adminPath+"files", cs.handleFiles)
+	mux.HandleFunc(adminPath+"goroutines", handleGoroutines)
+	mux.HandleFunc(adminPath+"heap", handleHeap)
+	return mux
+}
+
+// serveAdmin serves the admin API used to control logd at runtime
+func serveAdmin(address string, cs controls) error {
+	return http.ListenAndServe(address, adminHandler(cs))
+}
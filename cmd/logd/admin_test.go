@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ernestrc/logd/lua"
+)
+
+const testAdminScript = `
+local logd = require("logd")
+logd.config_set("tick", 60000)
+function logd.on_tick() end
+function logd.on_log(logptr) end
+`
+
+func newTestControl(t *testing.T, dir, name string, config map[string]interface{}) *control {
+	script := filepath.Join(dir, name+".lua")
+	if err := ioutil.WriteFile(script, []byte(testAdminScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sandbox, err := lua.NewSandboxConfig(name, script, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newControl(sandbox, script, &pipelineConfig{name: name, sandbox: config}, nil)
+}
+
+func adminRequest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return w
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := newTestControl(t, dir, "app", map[string]interface{}{"splunk.token": "secret"})
+	defer func() { c.current().Close() }()
+	h := adminHandler(controls{c})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodGet, adminPath + "status", "", http.StatusOK},
+		{http.MethodPost, adminPath + "status", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, adminPath + "flush", "", http.StatusNoContent},
+		{http.MethodGet, adminPath + "flush", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, adminPath + "config", `{"tick": 1000}`, http.StatusNoContent},
+		{http.MethodPost, adminPath + "config", `[1000]`, http.StatusBadRequest},
+		{http.MethodPost, adminPath + "config", `{"unknown": 1}`, http.StatusBadRequest},
+		{http.MethodGet, adminPath + "config?pipeline=unknown", "", http.StatusNotFound},
+		// the pipeline does not read files
+		{http.MethodGet, adminPath + "files", "", http.StatusNotFound},
+		{http.MethodGet, adminPath + "goroutines", "", http.StatusOK},
+		{http.MethodPost, adminPath + "reload", "", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		if w := adminRequest(h, test.method, test.path, test.body); w.Code != test.code {
+			t.Errorf("%s %s: expected %d found %d: %s", test.method, test.path, test.code, w.Code, w.Body)
+		}
+	}
+
+	var status adminStatus
+	w := adminRequest(h, http.MethodGet, adminPath+"status", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Pipeline != "app" || status.Reloads != 1 || status.LastError != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// credentials are not returned
+	var config map[string]interface{}
+	w = adminRequest(h, http.MethodGet, adminPath+"config", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["splunk.token"] != redacted || config["tick"] != float64(60000) {
+		t.Errorf("expected the token to be redacted and the tick to be reset by the reload: found %v", config)
+	}
+}
+
+func TestAdminReloadFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := newTestControl(t, dir, "app", nil)
+	defer func() { c.current().Close() }()
+	h := adminHandler(controls{c})
+	sandbox := c.current()
+
+	if err := ioutil.WriteFile(c.script, []byte("invalid script"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := adminRequest(h, http.MethodPost, adminPath+"reload", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 reloading an invalid script found %d", w.Code)
+	}
+	if c.current() != sandbox {
+		t.Errorf("expected the sandbox to keep running after a failed reload")
+	}
+	if status := c.status(); status.Reloads != 0 || status.LastError == "" {
+		t.Errorf("expected the reload error in the status: found %+v", status)
+	}
+}
+
+func TestAdminPipelines(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	first := newTestControl(t, dir, "first", nil)
+	defer first.current().Close()
+	second := newTestControl(t, dir, "second", nil)
+	defer func() { second.current().Close() }()
+	h := adminHandler(controls{first, second})
+
+	// status, reload and flush operate on all the pipelines unless one is selected
+	var statuses []adminStatus
+	w := adminRequest(h, http.MethodGet, adminPath+"status", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil || len(statuses) != 2 {
+		t.Errorf("expected the status of both pipelines: found %s", w.Body)
+	}
+	if w = adminRequest(h, http.MethodPost, adminPath+"reload?pipeline=second", ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 reloading the second pipeline found %d", w.Code)
+	}
+	if first.status().Reloads != 0 || second.status().Reloads != 1 {
+		t.Errorf("expected only the second pipeline to be reloaded")
+	}
+
+	// config must select a pipeline
+	if w = adminRequest(h, http.MethodGet, adminPath+"config", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a selected pipeline found %d", w.Code)
+	}
+	if w = adminRequest(h, http.MethodGet, adminPath+"config?pipeline=first", ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200 with a selected pipeline found %d", w.Code)
+	}
+}
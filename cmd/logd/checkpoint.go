@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fingerprintSize is the max number of bytes at the beginning of a file used to tell it
+// apart from a different file that reuses its inode or replaces its contents
+const fingerprintSize = 256
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	dev   uint64
+	inode uint64
+}
+
+func getFileID(info os.FileInfo) (id fileID, ok bool) {
+	var st *syscall.Stat_t
+	if st, ok = info.Sys().(*syscall.Stat_t); ok {
+		id = fileID{uint64(st.Dev), uint64(st.Ino)}
+	}
+	return
+}
+
+// fingerprint is the checksum of the first size bytes of a file
+type fingerprint struct {
+	size int64
+	sum  uint32
+}
+
+// readFingerprint returns the fingerprint of the first size bytes of the file, up to fingerprintSize
+func readFingerprint(f *os.File, size int64) (fp fingerprint, err error) {
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+	buf := make([]byte, size)
+	if _, err = f.ReadAt(buf, 0); err != nil {
+		return
+	}
+	return fingerprint{size, crc32.ChecksumIEEE(buf)}, nil
+}
+
+// matches returns whether the file starts with the bytes of the fingerprint.
+// The empty fingerprint matches any file.
+func (fp fingerprint) matches(f *os.File) bool {
+	if fp.size == 0 {
+		return true
+	}
+	current, err := readFingerprint(f, fp.size)
+	return err == nil && current == fp
+}
+
+// checkpointEntry is the read position of a file
+type checkpointEntry struct {
+	Path   string `json:"path"`
+	Dev    uint64 `json:"dev"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	// HeadSize and HeadSum are the fingerprint of the file
+	HeadSize int64  `json:"head_size,omitempty"`
+	HeadSum  uint32 `json:"head_sum,omitempty"`
+}
+
+// loadCheckpoint reads the read positions and the fingerprints of the files stored in the
+// checkpoint file. A missing checkpoint file is not an error.
+func loadCheckpoint(path string) (offsets map[fileID]int64, heads map[fileID]fingerprint, err error) {
+	offsets = make(map[fileID]int64)
+	heads = make(map[fileID]fingerprint)
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var entries []checkpointEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+	for _, e := range entries {
+		id := fileID{e.Dev, e.Inode}
+		offsets[id] = e.Offset
+		heads[id] = fingerprint{e.HeadSize, e.HeadSum}
+	}
+	return
+}
+
+// saveCheckpoint atomically replaces the checkpoint file with the given read positions
+func saveCheckpoint(path string, entries []checkpointEntry) (err error) {
+	var data []byte
+	if data, err = json.Marshal(entries); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), path)
+}
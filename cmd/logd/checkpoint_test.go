@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logs := filepath.Join(dir, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(logs, "app.log")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	cfg := DirReaderConfig{Checkpoint: checkpoint, FromBeginning: true}
+	writeFile(t, path, logLine("first")+logLine("second"))
+
+	r := newTestDirReader(t, logs, cfg)
+	expectMessages(t, r, "first", "second")
+	// logs read are committed by the next read
+	r.commit()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, f, "third")
+	f.Close()
+
+	r = newTestDirReader(t, logs, cfg)
+	defer r.Close()
+	expectMessages(t, r, "third")
+}
+
+func TestCheckpointInodeReuse(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logs := filepath.Join(dir, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(logs, "app.log")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	writeFile(t, path, logLine("old"))
+
+	r := newTestDirReader(t, logs, DirReaderConfig{Checkpoint: checkpoint, FromBeginning: true})
+	expectMessages(t, r, "old")
+	r.commit()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new file that reuses the inode is as large as the stored offset, so it is
+	// only told apart by its fingerprint
+	writeFile(t, path, logLine("new")+logLine("newer"))
+	r = newTestDirReader(t, logs, DirReaderConfig{Checkpoint: checkpoint})
+	defer r.Close()
+	expectMessages(t, r, "new", "newer")
+}
+
+func TestDirReaderTruncation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	writeFile(t, path, logLine("first"))
+
+	r := newTestDirReader(t, dir, DirReaderConfig{FromBeginning: true})
+	defer r.Close()
+	expectMessages(t, r, "first")
+
+	// truncated and written past the read offset before the truncation is noticed
+	writeFile(t, path, logLine("truncated")+logLine("again"))
+	expectMessages(t, r, "truncated", "again")
+}
+
+func TestCheckpointRotated(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logs := filepath.Join(dir, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(logs, "app.log")
+	rotated := filepath.Join(dir, "app.log.1")
+	cfg := DirReaderConfig{Checkpoint: filepath.Join(dir, "checkpoint.json"), FromBeginning: true}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	appendFile(t, f, "first")
+
+	r := newTestDirReader(t, logs, cfg)
+	expectMessages(t, r, "first")
+	// rotated out of the watched directory and restarted while it is still being read
+	if err = os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	// events are processed in order, so the rename has been processed once other.log is read
+	writeFile(t, filepath.Join(logs, "other.log"), logLine("other"))
+	expectMessages(t, r, "other")
+	appendFile(t, f, "late")
+	expectMessages(t, r, "late")
+	r.commit()
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotated file is read from where it was left once it is found again
+	appendFile(t, f, "after restart")
+	if err = os.Rename(rotated, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	r = newTestDirReader(t, logs, cfg)
+	defer r.Close()
+	expectMessages(t, r, "after restart")
+}
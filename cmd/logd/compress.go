@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionZstd  = "zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression returns the compression of a file by its extension or, if it has
+// no known extension, by the magic bytes of its header. Uncompressed files return "".
+func detectCompression(name string, header []byte) string {
+	switch filepath.Ext(name) {
+	case ".gz":
+		return compressionGzip
+	case ".bz2":
+		return compressionBzip2
+	case ".zst":
+		return compressionZstd
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(header, bzip2Magic):
+		return compressionBzip2
+	case bytes.HasPrefix(header, zstdMagic):
+		return compressionZstd
+	}
+	return ""
+}
+
+// newDecompressor returns a reader that decompresses data read from r
+func newDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case compressionGzip:
+		// concatenated gzip members are read as a single stream
+		return gzip.NewReader(r)
+	case compressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case compressionZstd:
+		// files are decompressed concurrently already, so every decoder uses a single goroutine
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(r), nil
+	}
+}
+
+// decompressReadCloser is a ReadCloser that transparently decompresses a file
+type decompressReadCloser struct {
+	io.ReadCloser
+	file io.Closer
+	// compression is empty if the file is not compressed
+	compression string
+}
+
+func (d *decompressReadCloser) Close() error {
+	d.ReadCloser.Close()
+	return d.file.Close()
+}
+
+// newDecompressReadCloser detects the compression of the file and returns a reader
+// that decompresses it. Uncompressed files are returned buffered.
+func newDecompressReadCloser(name string, file io.ReadCloser) (*decompressReadCloser, error) {
+	br := bufio.NewReader(file)
+	header, _ := br.Peek(len(zstdMagic))
+	compression := detectCompression(name, header)
+	r, err := newDecompressor(br, compression)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReadCloser{r, file, compression}, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipData(t *testing.T, data string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func zstdData(t *testing.T, data string) []byte {
+	var b bytes.Buffer
+	w, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		found  string
+	}{
+		{"app.log.gz", nil, compressionGzip},
+		{"app.log.bz2", nil, compressionBzip2},
+		{"app.log.zst", nil, compressionZstd},
+		{"app.log.1", gzipMagic, compressionGzip},
+		{"app.log.1", []byte("BZh91AY"), compressionBzip2},
+		{"app.log.1", zstdMagic, compressionZstd},
+		{"app.log", []byte("2017"), ""},
+		{"app.log", nil, ""},
+	}
+	for _, test := range tests {
+		if found := detectCompression(test.name, test.header); found != test.found {
+			t.Errorf("%s %v: expected '%s' found '%s'", test.name, test.header, test.found, found)
+		}
+	}
+}
+
+func TestFileReaderCompressed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// concatenated gzip members are read as a single stream
+	gz := append(gzipData(t, logLine("gzip one")), gzipData(t, logLine("gzip two"))...)
+	files := map[string][]byte{
+		"app.log.gz":  gz,
+		"app.log.zst": zstdData(t, logLine("zstd one")+logLine("zstd two")),
+		// compression is detected by the magic bytes without a known extension
+		"app.log.1": zstdData(t, logLine("zstd magic")),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewFileReader([]string{path}, &FileReaderConfig{Format: formatNative})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []string
+		switch name {
+		case "app.log.gz":
+			expected = []string{"gzip one", "gzip two"}
+		case "app.log.zst":
+			expected = []string{"zstd one", "zstd two"}
+		default:
+			expected = []string{"zstd magic"}
+		}
+		expectMessages(t, r, expected...)
+		r.Close()
+	}
+
+	// corrupted data is reported as a read error
+	path := filepath.Join(dir, "corrupted.zst")
+	if err := ioutil.WriteFile(path, append(zstdMagic, "corrupted"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFileReader([]string{path}, &FileReaderConfig{Format: formatNative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err = r.ReadLogs(nil); err == nil {
+		t.Errorf("expected error reading corrupted zstd data")
+	}
+}
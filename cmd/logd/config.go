@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ernestrc/logd/config"
+)
+
+// kinds of values of the config file keys that set flags
+const (
+	configString = iota
+	configBool
+	configInt
+	configList
+)
+
+// configOption is a config file key that sets the flag with the given name
+type configOption struct {
+	flag     string
+	kind     int
+	validate func(string) error
+}
+
+// configOptions are the config file keys that set flags. Keys of the sandbox and outputs
+// tables are supplied to the sandbox instead, see sandboxConfigKey.
+var configOptions = map[string]configOption{
+	"script":                    {flag: "R", kind: configString},
+	"daemon.metrics":            {flag: "metrics", kind: configString},
+	"daemon.admin":              {flag: "admin", kind: configString},
+	"daemon.log_file":           {flag: "o", kind: configString},
+	"daemon.debug":              {flag: "d", kind: configBool},
+	"daemon.pprof":              {flag: "s", kind: configBool},
+	"daemon.shutdown_timeout":   {flag: "shutdown-timeout", kind: configString},
+	"daemon.watch_script":       {flag: "watch-script", kind: configBool},
+	"input.files":               {flag: "f", kind: configList},
+	"input.follow":              {flag: "follow", kind: configBool},
+	"input.dirs":                {flag: "r", kind: configList},
+	"input.from_beginning":      {flag: "from-beginning", kind: configBool},
+	"input.source_host":         {flag: "source-host", kind: configBool},
+	"input.source_inode":        {flag: "source-inode", kind: configBool},
+	"input.checkpoint":          {flag: "checkpoint", kind: configString},
+	"input.checkpoint_interval": {flag: "checkpoint-interval", kind: configString},
+	"input.listen":              {flag: "l", kind: configList},
+	"input.framing":             {flag: "framing", kind: configString},
+	"input.tls_cert":            {flag: "tls-cert", kind: configString},
+	"input.tls_key":             {flag: "tls-key", kind: configString},
+	"input.tls_ca":              {flag: "tls-ca", kind: configString},
+	"input.http":                {flag: "http", kind: configString},
+	"input.http_headers":        {flag: "http-header", kind: configList},
+	"input.http_queue":          {flag: "http-queue", kind: configInt},
+	"parser.format":             {flag: "format", kind: configString, validate: validateFormat},
+	"parser.container":          {flag: "container", kind: configString, validate: validateContainer},
+}
+
+// sandboxConfigKey returns the config_set key of the keys of the sandbox and outputs tables:
+// sandbox.tick is set as tick, sandbox.kafka.acks as kafka.acks and outputs.loki.url as loki.url.
+func sandboxConfigKey(key string) (string, bool, error) {
+	if strings.HasPrefix(key, "sandbox.") {
+		key = strings.TrimPrefix(key, "sandbox.")
+		if key != "tick" && key != "protected" && !strings.HasPrefix(key, "http.") && !strings.HasPrefix(key, "kafka.") {
+			return "", true, fmt.Errorf("unknown key: the sandbox table accepts tick, protected, http.* and kafka.*")
+		}
+		return key, true, nil
+	}
+	if strings.HasPrefix(key, "outputs.") {
+		key = strings.TrimPrefix(key, "outputs.")
+		if !strings.Contains(key, ".") || strings.HasPrefix(key, "http.") || strings.HasPrefix(key, "kafka.") {
+			return "", true, fmt.Errorf("unknown key: the outputs table accepts <output>.<key>, i.e. outputs.loki.url")
+		}
+		return key, true, nil
+	}
+	return "", false, nil
+}
+
+// sandboxConfigValue converts a config file value to a config_set value.
+// Lists of strings are joined with commas.
+func sandboxConfigValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string, bool, float64:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return nil, fmt.Errorf("unexpected value %v", v)
+	}
+}
+
+// flagValues converts a config file value to the values set to a flag of the given kind
+func flagValues(v interface{}, kind int) ([]string, error) {
+	switch kind {
+	case configBool:
+		if b, ok := v.(bool); ok {
+			return []string{strconv.FormatBool(b)}, nil
+		}
+		return nil, fmt.Errorf("expected a boolean")
+	case configInt:
+		if i, ok := v.(int64); ok {
+			return []string{strconv.FormatInt(i, 10)}, nil
+		}
+		return nil, fmt.Errorf("expected an integer")
+	case configList:
+		if s, ok := v.(string); ok {
+			return []string{s}, nil
+		}
+		if list, ok := v.([]interface{}); ok {
+			values := make([]string, 0, len(list))
+			for _, item := range list {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings")
+				}
+				values = append(values, s)
+			}
+			return values, nil
+		}
+		return nil, fmt.Errorf("expected a list of strings")
+	default:
+		if s, ok := v.(string); ok {
+			return []string{s}, nil
+		}
+		return nil, fmt.Errorf("expected a string")
+	}
+}
+
+// setConfigValue sets the value of the config file key to the flag or the sandbox
+// configuration of the pipeline. given are the flags that were given in the command line.
+func setConfigValue(p *pipelineConfig, key string, v config.Value, given map[string]bool) error {
+	sandboxKey, ok, err := sandboxConfigKey(key)
+	if err != nil {
+		return config.ValueError(p.configPath, v, "%s", err)
+	}
+	if ok {
+		if p.sandbox[sandboxKey], err = sandboxConfigValue(v.Value); err != nil {
+			return config.ValueError(p.configPath, v, "%s", err)
+		}
+		p.keys[sandboxKey] = v
+		return nil
+	}
+
+	opt, ok := configOptions[key]
+	// daemon options can only be set in the top level daemon table
+	if !ok || (p.name != "" && strings.HasPrefix(key, "daemon.")) {
+		return config.ValueError(p.configPath, v, "unknown key")
+	}
+	values, err := flagValues(v.Value, opt.kind)
+	if err != nil {
+		return config.ValueError(p.configPath, v, "%s", err)
+	}
+	if given[opt.flag] {
+		return nil
+	}
+	for _, value := range values {
+		if opt.validate != nil {
+			if err = opt.validate(value); err != nil {
+				return config.ValueError(p.configPath, v, "%s", err)
+			}
+		}
+		if err = p.flags.Set(opt.flag, value); err != nil {
+			return config.ValueError(p.configPath, v, "invalid value '%s': %s", value, err)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads the config file and sets the flags configured by it, unless they were given
+// in the command line, which take precedence over the config file. The pipeline configured at the
+// top level of the file is the one of the command line, and the pipelines of the pipelines table
+// are returned. Both cannot be used together.
+func loadConfig(path string) (pipelines []*pipelineConfig, err error) {
+	var values []config.Value
+	if values, err = config.Load(path); err != nil {
+		return
+	}
+	pipelineFlags.configPath = path
+	pipelineFlags.sandbox = make(map[string]interface{})
+	pipelineFlags.keys = make(map[string]config.Value)
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	named := make(map[string]*pipelineConfig)
+	// toplevel is the first key of the pipeline at the top level of the file, if any
+	var toplevel *config.Value
+	for i, v := range values {
+		if len(v.Path) < 2 || v.Path[0] != "pipelines" {
+			if toplevel == nil && !strings.HasPrefix(v.Key, "daemon.") {
+				toplevel = &values[i]
+			}
+			if err = setConfigValue(pipelineFlags, v.Key, v, given); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// the name of the pipeline is a single segment of the path, even if it contains dots
+		name := v.Path[1]
+		if len(v.Path) == 2 {
+			return nil, config.ValueError(path, v, "pipelines must be tables, i.e. [pipelines.%s]", name)
+		}
+		p, ok := named[name]
+		if !ok {
+			p = newNamedPipelineConfig(name, path)
+			p.sandbox = make(map[string]interface{})
+			p.keys = make(map[string]config.Value)
+			named[name] = p
+			pipelines = append(pipelines, p)
+		}
+		if err = setConfigValue(p, strings.Join(v.Path[2:], "."), v, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(pipelines) != 0 && toplevel != nil {
+		return nil, config.ValueError(path, *toplevel, "cannot be used with the pipelines table: define it in a pipeline")
+	}
+	return
+}
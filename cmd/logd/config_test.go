@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigPipelineNames(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logd.toml")
+	writeFile(t, path, `[pipelines."app.logs"]
+script = "app.lua"
+
+[pipelines."app.logs".sandbox.kafka]
+"bootstrap.servers" = "localhost:9092"
+`)
+	pipelines, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 1 || pipelines[0].name != "app.logs" {
+		t.Fatalf("expected pipeline app.logs found %v", pipelines)
+	}
+	if p := pipelines[0]; p.script != "app.lua" || p.sandbox["kafka.bootstrap.servers"] != "localhost:9092" {
+		t.Errorf("unexpected pipeline configuration: script %s, sandbox %v", p.script, p.sandbox)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// containerDocker is the format of Docker's json-file logging driver:
+	// {"log": "...\n", "stream": "stdout", "time": "..."}
+	containerDocker = "docker"
+	// containerCRI is the format of containerd and CRI-O: "<timestamp> <stream> <P|F> <content>"
+	containerCRI = "cri"
+)
+
+// properties set on the logs read from container log files
+const (
+	keyStream      = "stream"
+	keyPod         = "pod"
+	keyNamespace   = "namespace"
+	keyContainer   = "container"
+	keyContainerID = "container_id"
+)
+
+// maxContainerLine is the max size of a line reassembled from partial lines
+const maxContainerLine = 1024 * 1024
+
+func validateContainer(container string) error {
+	switch container {
+	case "", containerDocker, containerCRI:
+		return nil
+	default:
+		return fmt.Errorf("container format must be one of '%s' or '%s': found '%s'", containerDocker, containerCRI, container)
+	}
+}
+
+type containerProp struct {
+	key, value string
+}
+
+// containerMetadata returns the pod, namespace, container name and container id of the
+// logs file of a Kubernetes container: /var/log/containers/<pod>_<namespace>_<container>-<id>.log
+func containerMetadata(name string) []containerProp {
+	base := strings.TrimSuffix(filepath.Base(name), ".log")
+	parts := strings.Split(base, "_")
+	if len(parts) != 3 {
+		return nil
+	}
+	i := strings.LastIndexByte(parts[2], '-')
+	if i <= 0 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+	return []containerProp{
+		{keyPod, parts[0]},
+		{keyNamespace, parts[1]},
+		{keyContainer, parts[2][:i]},
+		{keyContainerID, parts[2][i+1:]},
+	}
+}
+
+// containerEntry is a line of a container log file
+type containerEntry struct {
+	content string
+	stream  string
+	time    time.Time
+	// partial is set if the content continues in the next entry of the same stream
+	partial bool
+}
+
+func unwrapDocker(line string) (e containerEntry, err error) {
+	var entry struct {
+		Log    string    `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if err = json.Unmarshal([]byte(line), &entry); err != nil {
+		return
+	}
+	e.stream, e.time = entry.Stream, entry.Time
+	// lines longer than 16KB are split in entries without a trailing newline
+	e.content = strings.TrimSuffix(entry.Log, "\n")
+	e.partial = e.content == entry.Log
+	return
+}
+
+func unwrapCRI(line string) (e containerEntry, err error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		err = fmt.Errorf("invalid cri log line")
+		return
+	}
+	if e.time, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		return
+	}
+	e.stream = fields[1]
+	// tags are separated by ':' and the first one is P for partial or F for full lines
+	switch tag := strings.SplitN(fields[2], ":", 2)[0]; tag {
+	case "P":
+		e.partial = true
+	case "F":
+	default:
+		err = fmt.Errorf("invalid cri log tag '%s'", tag)
+		return
+	}
+	if len(fields) == 4 {
+		e.content = fields[3]
+	}
+	return
+}
+
+// containerParser is a chunkParser that unwraps the lines of a container log file,
+// reassembles partial lines and parses their content with the inner parser
+type containerParser struct {
+	lineParser
+	container string
+	unwrap    func(line string) (containerEntry, error)
+	inner     chunkParser
+	props     []containerProp
+	partial   map[string]string
+}
+
+func newContainerParser(container string, inner chunkParser, props []containerProp) *containerParser {
+	p := &containerParser{container: container, inner: inner, props: props, partial: make(map[string]string)}
+	p.parse = p.parseLine
+	p.unwrap = unwrapDocker
+	if container == containerCRI {
+		p.unwrap = unwrapCRI
+	}
+	return p
+}
+
+func (p *containerParser) parseLine(line string, logs []logging.Log) []logging.Log {
+	e, err := p.unwrap(line)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tag":   "ContainerLogParseFailure",
+			"error": err,
+		}).Debug()
+		metricParseErrors.WithLabelValues(p.container).Inc()
+		// keep unparseable lines so they are not lost
+		e = containerEntry{content: line}
+	}
+	content := p.partial[e.stream] + e.content
+	if e.partial && len(content) < maxContainerLine {
+		p.partial[e.stream] = content
+		return logs
+	}
+	delete(p.partial, e.stream)
+
+	start := len(logs)
+	logs = p.inner.Parse(content+"\n", logs)
+	for i := start; i < len(logs); i++ {
+		if e.stream != "" {
+			logs[i].Set(keyStream, e.stream)
+		}
+		for _, prop := range p.props {
+			logs[i].Set(prop.key, prop.value)
+		}
+		if _, err := logs[i].Time(); err != nil && !e.time.IsZero() {
+			// application log has no timestamp
+			logs[i].SetTime(e.time)
+		}
+	}
+	return logs
+}
+
+// newFileParser returns a parser for the file with the given name. If container is set,
+// lines are unwrapped from the container log envelope before being parsed.
+func newFileParser(format, container, name string) chunkParser {
+	if container == "" {
+		return newChunkParser(format)
+	}
+	return newContainerParser(container, newChunkParser(format), containerMetadata(name))
+}
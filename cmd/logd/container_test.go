@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func dockerLine(t *testing.T, stream, log string) string {
+	data, err := json.Marshal(map[string]string{"log": log, "stream": stream, "time": "2017-04-19T18:01:11.437Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+func criLine(stream, tag, content string) string {
+	return fmt.Sprintf("2017-04-19T18:01:11.437Z %s %s %s\n", stream, tag, content)
+}
+
+func TestContainerMetadata(t *testing.T) {
+	props := containerMetadata("/var/log/containers/web-1_default_nginx-0123abcd.log")
+	if fmt.Sprint(props) != "[{pod web-1} {namespace default} {container nginx} {container_id 0123abcd}]" {
+		t.Errorf("unexpected container metadata %v", props)
+	}
+	for _, name := range []string{"/var/log/app.log", "/var/log/containers/web-1_default.log", "/var/log/containers/_default_nginx-0123abcd.log"} {
+		if props = containerMetadata(name); props != nil {
+			t.Errorf("expected no container metadata for %s: found %v", name, props)
+		}
+	}
+}
+
+func TestContainerParserDocker(t *testing.T) {
+	p := newFileParser(formatNative, containerDocker, "/var/log/containers/web-1_default_nginx-0123abcd.log")
+	line := logLine("partial")
+	// partial lines are reassembled per stream, so lines of other streams can be interleaved
+	data := dockerLine(t, "stdout", line[:10]) +
+		dockerLine(t, "stderr", logLine("error")) +
+		dockerLine(t, "stdout", line[10:20]) +
+		dockerLine(t, "stdout", line[20:])
+	logs := p.Parse(data, nil)
+	if len(logs) != 2 || logs[0].Message != "error" || logs[1].Message != "partial" {
+		t.Fatalf("expected 'error' and 'partial' logs: found %v", logs)
+	}
+	for i, stream := range []string{"stderr", "stdout"} {
+		if value, _ := logs[i].Get(keyStream); value != stream {
+			t.Errorf("expected stream %s found %s", stream, value)
+		}
+		if value, _ := logs[i].Get(keyPod); value != "web-1" {
+			t.Errorf("expected pod web-1 found %s", value)
+		}
+	}
+
+	// lines that are not wrapped are kept
+	if logs = p.Parse(logLine("unwrapped"), nil); len(logs) != 1 || logs[0].Message != "unwrapped" {
+		t.Errorf("expected unwrapped line to be parsed: found %v", logs)
+	}
+}
+
+func TestContainerParserCRI(t *testing.T) {
+	p := newFileParser(formatNative, containerCRI, "app.log")
+	line := strings.TrimSuffix(logLine("partial"), "\n")
+	data := criLine("stdout", "P", line[:10]) +
+		criLine("stderr", "F", strings.TrimSuffix(logLine("error"), "\n")) +
+		criLine("stdout", "P", line[10:20]) +
+		criLine("stdout", "F", line[20:])
+	logs := p.Parse(data, nil)
+	if len(logs) != 2 || logs[0].Message != "error" || logs[1].Message != "partial" {
+		t.Fatalf("expected 'error' and 'partial' logs: found %v", logs)
+	}
+	if value, _ := logs[1].Get(keyStream); value != "stdout" {
+		t.Errorf("expected stream stdout found %s", value)
+	}
+	if _, ok := logs[1].Get(keyPod); ok {
+		t.Errorf("expected no container metadata for a file outside of /var/log/containers")
+	}
+}
+
+func TestContainerParserMaxLine(t *testing.T) {
+	p := newFileParser(formatNative, containerCRI, "app.log")
+	line := strings.TrimSuffix(logLine(strings.Repeat("a", maxContainerLine)), "\n")
+	// partial lines are emitted once the reassembled line exceeds maxContainerLine
+	logs := p.Parse(criLine("stdout", "P", line[:maxContainerLine/2]), nil)
+	logs = p.Parse(criLine("stdout", "P", line[maxContainerLine/2:]), logs)
+	if len(logs) != 1 || !strings.HasPrefix(logs[0].Message, "aaa") {
+		t.Fatalf("expected the reassembled line to be emitted once it exceeds the max size: found %d logs", len(logs))
+	}
+	logs = p.Parse(criLine("stdout", "F", strings.TrimSuffix(logLine("next"), "\n")), logs[:0])
+	if len(logs) != 1 || logs[0].Message != "next" {
+		t.Errorf("expected the next line to be parsed independently: found %v", logs)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+const (
+	// stdinPath is the path of the standard input. "-" is also accepted.
+	stdinPath = "/dev/stdin"
+	// followInterval is the interval at which followed files are checked for new data
+	followInterval = 250 * time.Millisecond
+)
+
+func isStdin(path string) bool {
+	return path == stdinPath || path == "-"
+}
+
+// expandFilePaths expands the glob patterns of the given paths. Paths without glob
+// meta characters are kept as they are even if they do not exist.
+func expandFilePaths(patterns []string) (paths []string, err error) {
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if !isStdin(pattern) && strings.ContainsAny(pattern, "*?[") {
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid file pattern '%s': %s", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match '%s'", pattern)
+			}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return
+}
+
+// FileReaderConfig is the configuration of a FileReader
+type FileReaderConfig struct {
+	// Format of the files: formatNative or formatSyslog
+	Format string
+	// Container is the log format of the container runtime that wraps the logs: containerDocker,
+	// containerCRI or empty if logs are not wrapped
+	Container string
+	// Follow waits for new data once the end of a file is reached, like tail -F
+	Follow bool
+	// Annotate sets the path of the file as a property of every log
+	Annotate bool
+}
+
+// inputFile is a file read by FileReader
+type inputFile struct {
+	path string
+	fd   *os.File
+	// reader decompresses the file if it is compressed
+	reader *decompressReadCloser
+	info   os.FileInfo
+	// pos is updated atomically so it can be read by Files
+	pos     int64
+	newline bool
+	// rotated is set once the path refers to a different file
+	rotated bool
+}
+
+func openInputFile(path string) (in *inputFile, err error) {
+	in = &inputFile{path: path, newline: true}
+	// the standard input is read but never closed
+	var file io.ReadCloser = ioutil.NopCloser(os.Stdin)
+	if isStdin(path) {
+		in.fd = os.Stdin
+	} else if in.fd, err = os.Open(path); err != nil {
+		return nil, err
+	} else {
+		file = in.fd
+	}
+	if in.info, err = in.fd.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if in.reader, err = newDecompressReadCloser(path, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// followable returns true if more data can be appended to the file once its end has been reached
+func (in *inputFile) followable() bool {
+	return in.info.Mode().IsRegular() && in.fd != os.Stdin && in.reader.compression == ""
+}
+
+func (in *inputFile) Read(b []byte) (n int, err error) {
+	n, err = in.reader.Read(b)
+	if n > 0 {
+		in.newline = b[n-1] == '\n'
+	}
+	atomic.AddInt64(&in.pos, int64(n))
+	return
+}
+
+// check checks if the file at the path of a followed file has been replaced or truncated
+func (in *inputFile) check() error {
+	info, err := os.Stat(in.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// removed: switch to the new file once it is created
+			in.rotated = true
+			return nil
+		}
+		return err
+	}
+	if !os.SameFile(info, in.info) {
+		in.rotated = true
+		return nil
+	}
+	if info.Size() < in.pos {
+		// truncated: read again from the beginning
+		if _, err = in.fd.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		atomic.StoreInt64(&in.pos, 0)
+	}
+	return nil
+}
+
+func (in *inputFile) Close() error {
+	return in.reader.Close()
+}
+
+// FileReader is a LogReader that reads files concurrently. Every file is parsed
+// independently and the resulting logs are merged.
+type FileReader struct {
+	cfg      FileReaderConfig
+	logchan  chan []logging.Log
+	errchan  chan error
+	quitchan chan struct{}
+	stopchan chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	files    map[*inputFile]struct{}
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewFileReader starts reading the given files
+func NewFileReader(paths []string, cfg *FileReaderConfig) (r *FileReader, err error) {
+	r = new(FileReader)
+	if err = r.Init(paths, cfg); err != nil {
+		r = nil
+	}
+	return
+}
+
+// Init starts reading the given files. Files that do not exist yet are
+// waited for if cfg.Follow is set.
+func (r *FileReader) Init(paths []string, cfg *FileReaderConfig) (err error) {
+	if err = validateFormat(cfg.Format); err != nil {
+		return
+	}
+	if err = validateContainer(cfg.Container); err != nil {
+		return
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files to read")
+	}
+	r.cfg = *cfg
+	r.logchan = make(chan []logging.Log)
+	r.errchan = make(chan error, 1)
+	r.quitchan = make(chan struct{})
+	r.stopchan = make(chan struct{})
+	r.done = make(chan struct{})
+	r.files = make(map[*inputFile]struct{})
+
+	files := make([]*inputFile, len(paths))
+	for i, path := range paths {
+		if files[i], err = openInputFile(path); err != nil && !(r.cfg.Follow && os.IsNotExist(err)) {
+			for _, in := range files[:i] {
+				if in != nil {
+					in.Close()
+				}
+			}
+			return
+		}
+		err = nil
+	}
+	for i, path := range paths {
+		r.wg.Add(1)
+		go r.readFile(path, files[i])
+	}
+	go func() {
+		r.wg.Wait()
+		close(r.done)
+	}()
+	return
+}
+
+func (r *FileReader) closing() bool {
+	select {
+	case <-r.quitchan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *FileReader) stopping() bool {
+	select {
+	case <-r.stopchan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *FileReader) fail(err error) {
+	select {
+	case r.errchan <- err:
+	default:
+	}
+}
+
+// send blocks until the logs are read so that files are read as fast as the pipeline processes them
+func (r *FileReader) send(logs []logging.Log, path string) bool {
+	if len(logs) == 0 {
+		return true
+	}
+	if r.cfg.Annotate {
+		for i := range logs {
+			logs[i].Set(keyFile, path)
+		}
+	}
+	select {
+	case r.logchan <- logs:
+		return true
+	case <-r.quitchan:
+		return false
+	}
+}
+
+// wait returns false if the reader is closed before the follow interval elapses
+func (r *FileReader) wait() bool {
+	select {
+	case <-time.After(followInterval):
+		return true
+	case <-r.quitchan:
+		return false
+	case <-r.stopchan:
+		return false
+	}
+}
+
+func (r *FileReader) track(in *inputFile) {
+	r.lock.Lock()
+	r.files[in] = struct{}{}
+	r.lock.Unlock()
+}
+
+// release closes the file. Files are only closed by the goroutine that reads them.
+func (r *FileReader) release(in *inputFile) {
+	r.lock.Lock()
+	delete(r.files, in)
+	r.lock.Unlock()
+	in.Close()
+}
+
+// readFile parses the file at the given path until its end or, if following files,
+// until the reader is stopped or closed. If in is nil, the file is opened once it is created.
+func (r *FileReader) readFile(path string, in *inputFile) {
+	defer r.wg.Done()
+	parser := newFileParser(r.cfg.Format, r.cfg.Container, path)
+	// lines are truncated to maxFrameSize by the parser, so larger reads are not needed
+	buf := make([]byte, maxFrameSize)
+	var err error
+	if in != nil {
+		r.track(in)
+	}
+	for !r.closing() && !r.stopping() {
+		if in == nil {
+			if in, err = openInputFile(path); err != nil {
+				if os.IsNotExist(err) && r.wait() {
+					continue
+				}
+				break
+			}
+			r.track(in)
+		}
+		var n int
+		n, err = in.Read(buf)
+		if n > 0 {
+			logs := parser.Parse(string(buf[:n]), nil)
+			observeInput(inputLabelFile, n, len(logs))
+			if !r.send(logs, path) {
+				break
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			break
+		}
+		err = nil
+		if !in.newline && (in.rotated || !r.cfg.Follow || !in.followable()) {
+			// last line of the file is complete
+			in.newline = true
+			logs := parser.Parse("\n", nil)
+			observeInput(inputLabelFile, 0, len(logs))
+			if !r.send(logs, path) {
+				break
+			}
+		}
+		if !r.cfg.Follow || !in.followable() {
+			break
+		}
+		if in.rotated {
+			// remaining data of the rotated file has been read
+			r.release(in)
+			in = nil
+			continue
+		}
+		if err = in.check(); err != nil || (!in.rotated && !r.wait()) {
+			break
+		}
+	}
+	if in != nil {
+		if !in.newline && r.stopping() {
+			// last line read before stopping is incomplete
+			in.newline = true
+			logs := parser.Parse("\n", nil)
+			observeInput(inputLabelFile, 0, len(logs))
+			r.send(logs, path)
+		}
+		r.release(in)
+	}
+	if err != nil && !r.closing() {
+		r.fail(fmt.Errorf("%s: %s", path, err))
+	}
+}
+
+// Files returns the read positions of the files that are open
+func (r *FileReader) Files() []FileStatus {
+	r.lock.Lock()
+	status := make([]FileStatus, 0, len(r.files))
+	for in := range r.files {
+		status = append(status, FileStatus{Path: in.path, Position: atomic.LoadInt64(&in.pos)})
+	}
+	r.lock.Unlock()
+	sort.Slice(status, func(i, j int) bool { return status[i].Path < status[j].Path })
+	return status
+}
+
+// ReadLogs blocks until logs are read from any of the files. io.EOF is returned once
+// all the files have been read, which never happens if following files.
+func (r *FileReader) ReadLogs(logs []logging.Log) ([]logging.Log, error) {
+	select {
+	case batch := <-r.logchan:
+		return append(logs, batch...), nil
+	case err := <-r.errchan:
+		return logs, err
+	case <-r.done:
+		// an error of the last file read is returned before io.EOF
+		select {
+		case err := <-r.errchan:
+			return logs, err
+		default:
+			return logs, io.EOF
+		}
+	case <-r.quitchan:
+		return logs, io.EOF
+	}
+}
+
+// Stop stops reading the files. ReadLogs returns io.EOF once the incomplete lines
+// have been parsed and all the logs read. Reads in progress are not interrupted.
+func (r *FileReader) Stop() {
+	r.stopOnce.Do(func() { close(r.stopchan) })
+}
+
+// Close stops reading the files. Every file is closed once its read in progress returns.
+// Close does not wait for them as reads from terminals cannot always be interrupted.
+func (r *FileReader) Close() error {
+	if !r.closing() {
+		close(r.quitchan)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func TestFileReaderError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// reading a directory fails, and the error is returned instead of io.EOF once the file is done
+	r, err := NewFileReader([]string{dir}, &FileReaderConfig{Format: formatNative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	<-r.done
+	if _, err = r.ReadLogs(make([]logging.Log, 0)); err == nil || err == io.EOF {
+		t.Errorf("expected read error found %v", err)
+	}
+}
+
+func TestFileReaderStdin(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	stdin := os.Stdin
+	os.Stdin = pr
+	defer func() { os.Stdin = stdin }()
+
+	// compression is detected from the first bytes read
+	if _, err = pw.WriteString(logLine("first")); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	r, err := NewFileReader([]string{"-"}, &FileReaderConfig{Format: formatNative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMessages(t, r, "first")
+	<-r.done
+	r.Close()
+
+	// the standard input is read until its end but never closed
+	if _, err = pr.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the standard input to be open: found %v", err)
+	}
+}
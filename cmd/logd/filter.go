@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileFilter selects the files read from a watched directory
+type FileFilter struct {
+	// Include are the glob patterns of the files to read. If empty, all files are read.
+	Include []string
+	// Exclude are the glob patterns of the files to ignore
+	Exclude []string
+	// MaxAge ignores the files that have not been modified for longer than MaxAge. Zero disables it.
+	MaxAge time.Duration
+}
+
+// matchAny returns true if any of the patterns matches the file base name or,
+// if pattern contains a path separator, its path relative to the watched directory
+func matchAny(patterns []string, rel string) bool {
+	base := filepath.Base(rel)
+	for _, p := range patterns {
+		name := base
+		if strings.ContainsRune(p, filepath.Separator) {
+			name = rel
+		}
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the file at rel path, relative to the watched directory, must be read
+func (f *FileFilter) Match(rel string, info os.FileInfo) bool {
+	if len(f.Include) != 0 && !matchAny(f.Include, rel) {
+		return false
+	}
+	if matchAny(f.Exclude, rel) {
+		return false
+	}
+	if f.MaxAge > 0 && time.Since(info.ModTime()) > f.MaxAge {
+		return false
+	}
+	return true
+}
+
+// parseWatchFlag parses a -r flag value: a directory optionally followed by comma separated
+// filter options. i.e. /var/log,include=*.log,exclude=*.gz,max_age=24h
+func parseWatchFlag(v string) (dir string, filter FileFilter, err error) {
+	opts := strings.Split(v, ",")
+	dir = opts[0]
+	for _, opt := range opts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("invalid option '%s' for directory %s", opt, dir)
+			return
+		}
+		switch kv[0] {
+		case "include", "exclude":
+			if _, err = filepath.Match(kv[1], ""); err != nil {
+				err = fmt.Errorf("invalid %s pattern '%s': %s", kv[0], kv[1], err)
+				return
+			}
+			if kv[0] == "include" {
+				filter.Include = append(filter.Include, kv[1])
+			} else {
+				filter.Exclude = append(filter.Exclude, kv[1])
+			}
+		case "max_age":
+			if filter.MaxAge, err = time.ParseDuration(kv[1]); err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown option '%s' for directory %s", kv[0], dir)
+			return
+		}
+	}
+	return
+}
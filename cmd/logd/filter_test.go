@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileFilterMatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	recent := filepath.Join(dir, "recent.log")
+	writeFile(t, recent, logLine("recent"))
+	old := filepath.Join(dir, "old.log")
+	writeFile(t, old, logLine("old"))
+	modified := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	recentInfo, err := os.Stat(recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldInfo, err := os.Stat(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter FileFilter
+		rel    string
+		info   os.FileInfo
+		match  bool
+	}{
+		{FileFilter{}, "app.log.gz", recentInfo, true},
+		{FileFilter{Include: []string{"*.log"}}, "app.log", recentInfo, true},
+		{FileFilter{Include: []string{"*.log"}}, "app.log.gz", recentInfo, false},
+		// patterns without a separator match the base name at any depth
+		{FileFilter{Include: []string{"*.log"}}, "nginx/access.log", recentInfo, true},
+		// patterns with a separator match the path relative to the watched directory
+		{FileFilter{Include: []string{"nginx/*.log"}}, "nginx/access.log", recentInfo, true},
+		{FileFilter{Include: []string{"nginx/*.log"}}, "app.log", recentInfo, false},
+		{FileFilter{Include: []string{"nginx/*.log"}}, "other/nginx/access.log", recentInfo, false},
+		{FileFilter{Exclude: []string{"*.swp"}}, "app.log", recentInfo, true},
+		{FileFilter{Exclude: []string{"*.swp"}}, ".app.log.swp", recentInfo, false},
+		// exclude patterns take precedence over include patterns
+		{FileFilter{Include: []string{"*.log"}, Exclude: []string{"debug.*"}}, "debug.log", recentInfo, false},
+		{FileFilter{MaxAge: time.Minute}, "recent.log", recentInfo, true},
+		{FileFilter{MaxAge: time.Minute}, "old.log", oldInfo, false},
+		{FileFilter{MaxAge: 2 * time.Hour}, "old.log", oldInfo, true},
+	}
+	for _, test := range tests {
+		if match := test.filter.Match(test.rel, test.info); match != test.match {
+			t.Errorf("%+v %s: expected %t found %t", test.filter, test.rel, test.match, match)
+		}
+	}
+}
+
+func TestParseWatchFlag(t *testing.T) {
+	dir, filter, err := parseWatchFlag("/var/log,include=*.log,include=*.txt,exclude=*.gz,max_age=24h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/var/log" || len(filter.Include) != 2 || filter.Include[1] != "*.txt" ||
+		len(filter.Exclude) != 1 || filter.Exclude[0] != "*.gz" || filter.MaxAge != 24*time.Hour {
+		t.Errorf("unexpected directory %s and filter %+v", dir, filter)
+	}
+
+	if dir, filter, err = parseWatchFlag("/var/log"); err != nil || dir != "/var/log" ||
+		len(filter.Include) != 0 || len(filter.Exclude) != 0 || filter.MaxAge != 0 {
+		t.Errorf("expected directory without filter: found %s %+v %v", dir, filter, err)
+	}
+
+	for _, v := range []string{
+		"/var/log,include",
+		"/var/log,include=[",
+		"/var/log,max_age=day",
+		"/var/log,size=10",
+	} {
+		if _, _, err = parseWatchFlag(v); err == nil {
+			t.Errorf("expected error parsing %s", v)
+		}
+	}
+}
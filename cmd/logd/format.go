@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ernestrc/logd/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// formatNative is the format expected by logging.Parser
+	formatNative = "native"
+	// formatSyslog is RFC 5424 or RFC 3164 syslog messages, one per line
+	formatSyslog = "syslog"
+	// formatJSON is JSON objects posted to the HTTP ingestion endpoint
+	formatJSON = "json"
+)
+
+// chunkParser parses chunks of data into logs. Incomplete lines are kept until the next chunk.
+type chunkParser interface {
+	Parse(chunk string, logs []logging.Log) []logging.Log
+}
+
+// lineParser is a chunkParser that splits chunks into lines and parses every line with parse
+type lineParser struct {
+	rest string
+	// discard is set while the remainder of a line longer than maxFrameSize is discarded
+	discard bool
+	parse   func(line string, logs []logging.Log) []logging.Log
+}
+
+func (p *lineParser) Parse(chunk string, logs []logging.Log) []logging.Log {
+	if p.discard {
+		i := strings.IndexByte(chunk, '\n')
+		if i < 0 {
+			return logs
+		}
+		chunk, p.discard = chunk[i+1:], false
+	}
+	p.rest += chunk
+	for {
+		i := strings.IndexByte(p.rest, '\n')
+		if i < 0 {
+			break
+		}
+		if line := p.rest[:i]; line != "" {
+			logs = p.parse(line, logs)
+		}
+		p.rest = p.rest[i+1:]
+	}
+	if len(p.rest) > maxFrameSize {
+		// lines longer than maxFrameSize are truncated
+		log.WithFields(log.Fields{
+			"tag":  "LineTooLong",
+			"size": maxFrameSize,
+		}).Debug()
+		logs = p.parse(p.rest[:maxFrameSize], logs)
+		p.rest, p.discard = "", true
+	}
+	return logs
+}
+
+func parseSyslogLine(line string, logs []logging.Log) []logging.Log {
+	lg, err := logging.ParseSyslog(line)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tag":   "SyslogParseFailure",
+			"error": err,
+		}).Debug()
+		metricParseErrors.WithLabelValues(formatSyslog).Inc()
+		// keep unparseable messages so they are not lost
+		lg = *logging.NewLog()
+		lg.Message = line
+	}
+	return append(logs, lg)
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case formatNative, formatSyslog:
+		return nil
+	default:
+		return fmt.Errorf("format must be one of '%s' or '%s': found '%s'", formatNative, formatSyslog, format)
+	}
+}
+
+// newChunkParser returns a parser for the given input format
+func newChunkParser(format string) chunkParser {
+	switch format {
+	case formatSyslog:
+		return &lineParser{parse: parseSyslogLine}
+	default:
+		return logging.NewParser()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestLineParserMaxLine(t *testing.T) {
+	p := newChunkParser(formatSyslog)
+	logs := p.Parse(strings.Repeat("a", maxFrameSize), nil)
+	if len(logs) != 0 {
+		t.Fatalf("expected no logs before the line exceeds the max size: found %d", len(logs))
+	}
+	// the line is truncated once it exceeds the max size and the rest of it is discarded
+	logs = p.Parse("b", logs)
+	logs = p.Parse("discarded\nnext\n", logs)
+	if len(logs) != 2 || len(logs[0].Message) != maxFrameSize || logs[1].Message != "next" {
+		t.Errorf("expected truncated line followed by 'next': found %d logs", len(logs))
+	}
+}
+
+func TestReadLineFrame(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("first\n"+strings.Repeat("a", maxFrameSize)+"\n"), 16)
+	if msg, err := readLineFrame(br); err != nil || string(msg) != "first\n" {
+		t.Errorf("expected 'first\\n' found '%s': %v", msg, err)
+	}
+	if _, err := readLineFrame(br); err == nil {
+		t.Errorf("expected error reading frame larger than %d bytes", maxFrameSize)
+	}
+}
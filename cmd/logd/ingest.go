@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ernestrc/logd/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ingestPath is the path of the HTTP ingestion endpoint
+	ingestPath         = "/logs"
+	maxIngestBodySize  = 64 * 1000 * 1000
+	defaultIngestQueue = 16
+)
+
+// errBodyTooLarge is returned when the request body, or its decompressed content, exceeds maxIngestBodySize
+var errBodyTooLarge = fmt.Errorf("request body exceeds max size of %d bytes", maxIngestBodySize)
+
+// readBody reads the request body, which is rejected with errBodyTooLarge if it exceeds
+// maxIngestBodySize instead of being silently truncated
+func readBody(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxIngestBodySize+1))
+	if len(data) > maxIngestBodySize {
+		return nil, errBodyTooLarge
+	}
+	return data, err
+}
+
+// ingestBatch is a batch of logs posted in a single request.
+// done is closed once the logs have been processed by the pipeline.
+type ingestBatch struct {
+	logs []logging.Log
+	done chan struct{}
+}
+
+// HTTPReaderConfig is the configuration of a HTTPReader
+type HTTPReaderConfig struct {
+	// Address to listen on. i.e. :8080
+	Address string
+	// Format of the raw lines posted with a non JSON Content-Type: formatNative or formatSyslog
+	Format string
+	// Headers are the request headers set as properties of every log
+	Headers []string
+	// Queue is the number of requests waiting to be processed before new requests are rejected
+	Queue int
+}
+
+// HTTPReader is a LogReader that accepts logs POSTed to its ingestion endpoint.
+// Requests are answered once their logs have been processed by the pipeline, or with
+// 429 if too many requests are waiting to be processed and 503 if the reader is stopped.
+type HTTPReader struct {
+	cfg       HTTPReaderConfig
+	server    *http.Server
+	listener  net.Listener
+	batchchan chan *ingestBatch
+	errchan   chan error
+	quitchan  chan struct{}
+	stopchan  chan struct{}
+	// batch returned by the last call to ReadLogs
+	pending   *ingestBatch
+	closeOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewHTTPReader starts the ingestion HTTP server
+func NewHTTPReader(cfg *HTTPReaderConfig) (r *HTTPReader, err error) {
+	r = new(HTTPReader)
+	if err = r.Init(cfg); err != nil {
+		r = nil
+	}
+	return
+}
+
+// Init starts the ingestion HTTP server
+func (r *HTTPReader) Init(cfg *HTTPReaderConfig) (err error) {
+	if err = validateFormat(cfg.Format); err != nil {
+		return
+	}
+	if cfg.Queue < 1 {
+		return fmt.Errorf("min http ingestion queue size is 1")
+	}
+	r.cfg = *cfg
+	r.batchchan = make(chan *ingestBatch, r.cfg.Queue)
+	r.errchan = make(chan error, 1)
+	r.quitchan = make(chan struct{})
+	r.stopchan = make(chan struct{})
+
+	if r.listener, err = net.Listen("tcp", r.cfg.Address); err != nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(ingestPath, r.handle)
+	r.server = &http.Server{Handler: mux}
+	go func() {
+		if err := r.server.Serve(r.listener); err != nil && err != http.ErrServerClosed {
+			select {
+			case r.errchan <- err:
+			default:
+			}
+		}
+	}()
+	return
+}
+
+func isJSON(contentType string) bool {
+	return strings.Contains(contentType, "json")
+}
+
+// parseRequest parses the request body into logs annotated with the configured
+// request headers and the remote address
+func (r *HTTPReader) parseRequest(req *http.Request) (logs []logging.Log, err error) {
+	var data []byte
+	if data, err = readBody(req.Body); err != nil {
+		return
+	}
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return
+		}
+		defer gz.Close()
+		if data, err = readBody(gz); err != nil {
+			return
+		}
+	}
+
+	if isJSON(req.Header.Get("Content-Type")) {
+		if logs, err = decodeJSONLogs(data, nil); err != nil {
+			metricParseErrors.WithLabelValues(formatJSON).Inc()
+			return
+		}
+	} else {
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		logs = newChunkParser(r.cfg.Format).Parse(string(data), nil)
+	}
+
+	observeInput(inputLabelHTTP, len(data), len(logs))
+	annotatePeer(logs, req.RemoteAddr)
+	for _, h := range r.cfg.Headers {
+		if v := req.Header.Get(h); v != "" {
+			for i := range logs {
+				logs[i].Set(strings.ToLower(h), v)
+			}
+		}
+	}
+	return
+}
+
+func (r *HTTPReader) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logs, err := r.parseRequest(req)
+	if err == errBodyTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(logs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	batch := &ingestBatch{logs, make(chan struct{})}
+	select {
+	case <-r.quitchan:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.stopchan:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case r.batchchan <- batch:
+	default:
+		log.WithFields(log.Fields{
+			"tag":  "IngestQueueFull",
+			"peer": req.RemoteAddr,
+		}).Debug()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	select {
+	case <-batch.done:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.quitchan:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// ReadLogs signals that the logs of the previous request have been processed and
+// blocks until the next request is received
+func (r *HTTPReader) ReadLogs(logs []logging.Log) ([]logging.Log, error) {
+	r.release()
+	select {
+	case batch := <-r.batchchan:
+		r.pending = batch
+		return append(logs, batch.logs...), nil
+	case err := <-r.errchan:
+		return logs, err
+	case <-r.quitchan:
+		return logs, io.EOF
+	case <-r.stopchan:
+		// requests waiting to be processed are read before returning io.EOF
+		select {
+		case batch := <-r.batchchan:
+			r.pending = batch
+			return append(logs, batch.logs...), nil
+		default:
+			return logs, io.EOF
+		}
+	}
+}
+
+// release answers the request of the batch returned by the last call to ReadLogs, which
+// must be released by every call to ReadLogs, including the last one that returns io.EOF,
+// so that the request is not answered with 503 once the reader is closed
+func (r *HTTPReader) release() {
+	if r.pending != nil {
+		close(r.pending.done)
+		r.pending = nil
+	}
+}
+
+// Stop rejects new requests with 503. ReadLogs returns io.EOF once the requests
+// waiting to be processed have been read.
+func (r *HTTPReader) Stop() {
+	r.stopOnce.Do(func() { close(r.stopchan) })
+}
+
+// Close stops the HTTP server. Requests waiting to be processed are answered with 503.
+func (r *HTTPReader) Close() (err error) {
+	r.closeOnce.Do(func() {
+		close(r.quitchan)
+		if r.server != nil {
+			err = r.server.Close()
+		} else if r.listener != nil {
+			err = r.listener.Close()
+		}
+	})
+	return
+}
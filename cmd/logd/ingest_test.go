@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIngestBodyTooLarge(t *testing.T) {
+	r := &HTTPReader{cfg: HTTPReaderConfig{Format: formatNative}}
+	body := bytes.Repeat([]byte("a"), maxIngestBodySize+1)
+
+	w := httptest.NewRecorder()
+	r.handle(w, httptest.NewRequest(http.MethodPost, ingestPath, bytes.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a body larger than the max size: found %d", w.Code)
+	}
+
+	// the decompressed content is rejected instead of being truncated
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(body)
+	gz.Close()
+	req := httptest.NewRequest(http.MethodPost, ingestPath, &gzipped)
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	r.handle(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a decompressed body larger than the max size: found %d", w.Code)
+	}
+}
+
+func TestIngestLastBatch(t *testing.T) {
+	r, err := NewHTTPReader(&HTTPReaderConfig{Address: "127.0.0.1:0", Format: formatNative, Queue: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	url := "http://" + r.listener.Addr().String() + ingestPath
+
+	answered := make(chan int, 1)
+	go func() {
+		res, err := http.Post(url, "text/plain", bytes.NewBufferString(logLine("one")))
+		if err != nil {
+			t.Error(err)
+			answered <- 0
+			return
+		}
+		res.Body.Close()
+		answered <- res.StatusCode
+	}()
+	logs, err := r.ReadLogs(nil)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("expected the posted log found %v: %v", logs, err)
+	}
+	r.Stop()
+	if _, err = r.ReadLogs(logs[:0]); err != io.EOF {
+		t.Fatalf("expected io.EOF found %v", err)
+	}
+	// the request is answered once its logs are processed, without waiting for Close
+	select {
+	case code := <-answered:
+		if code != http.StatusNoContent {
+			t.Errorf("expected 204 found %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the last request to be answered once its logs were processed")
+	}
+}
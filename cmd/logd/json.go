@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+// setJSONTimestamp sets the log timestamp from a RFC 3339 or "date time" value
+func setJSONTimestamp(lg *logging.Log, value string) error {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		lg.SetTime(t)
+		return nil
+	}
+	if strings.Count(value, " ") != 1 {
+		return fmt.Errorf("invalid timestamp '%s'", value)
+	}
+	lg.Set(logging.KeyTimestamp, value)
+	return nil
+}
+
+// decodeJSONLog decodes the next JSON object into a log. Object keys are set in order,
+// string values are unquoted and the rest of the values are kept as raw JSON.
+func decodeJSONLog(dec *json.Decoder) (lg logging.Log, err error) {
+	lg = *logging.NewLog()
+	var tok json.Token
+	if tok, err = dec.Token(); err != nil {
+		return
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		err = fmt.Errorf("expected JSON object: found %v", tok)
+		return
+	}
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return
+		}
+		key := tok.(string)
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return
+		}
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if key == logging.KeyTimestamp {
+			if err = setJSONTimestamp(&lg, value); err != nil {
+				return
+			}
+			continue
+		}
+		lg.Set(key, value)
+	}
+	// closing '}'
+	_, err = dec.Token()
+	return
+}
+
+// decodeJSONLogs decodes a JSON object, a JSON array of objects or newline
+// delimited JSON objects and appends the logs to the given slice
+func decodeJSONLogs(data []byte, logs []logging.Log) ([]logging.Log, error) {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	array := len(data) > 0 && data[0] == '['
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return logs, err
+		}
+	}
+	for dec.More() {
+		lg, err := decodeJSONLog(dec)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, lg)
+	}
+	if array {
+		// closing ']'
+		if _, err := dec.Token(); err != nil {
+			return logs, err
+		}
+	}
+	return logs, nil
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// framingNewline splits stream messages by newline
+	framingNewline = "newline"
+	// framingOctet splits stream messages using octet counting as defined by RFC 6587: "LEN SP MSG"
+	framingOctet = "octet"
+	// framingAuto detects the framing of every message: messages starting with a digit use
+	// octet counting and the rest are split by newline. Suitable for syslog messages.
+	framingAuto = "auto"
+)
+
+const (
+	// keyPeer is the log property set with the address of the peer that sent it
+	keyPeer = "peer"
+	// maxDatagramSize is the max size of datagrams. Larger datagrams are dropped.
+	maxDatagramSize = 64 * 1024
+	connBufferSize  = 64 * 1024
+	// maxFrameSize is the max size of stream messages
+	maxFrameSize = 1024 * 1024
+)
+
+// parseListenAddress parses addresses of the form tcp://host:port, tls://host:port, udp://host:port,
+// unix:///path/to/socket and unixgram:///path/to/socket
+func parseListenAddress(addr string) (network, address string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(addr); err != nil {
+		err = fmt.Errorf("invalid listen address '%s': %s", addr, err)
+		return
+	}
+	switch u.Scheme {
+	case "tcp", "tls", "udp":
+		network, address = u.Scheme, u.Host
+	case "unix", "unixgram":
+		network, address = u.Scheme, u.Host+u.Path
+	default:
+		err = fmt.Errorf("listen address scheme must be one of tcp, tls, udp, unix or unixgram: found '%s'", u.Scheme)
+	}
+	return
+}
+
+// NetReaderConfig is the configuration of a NetReader
+type NetReaderConfig struct {
+	// Framing of the messages sent over stream sockets: framingNewline, framingOctet or framingAuto
+	Framing string
+	// Format of the messages: formatNative or formatSyslog
+	Format string
+	// TLS is the configuration of tls:// listeners
+	TLS *tls.Config
+	// StopTimeout is the max time the connections open when the reader is stopped are read
+	// before they are closed. If zero, they are read until the peers close them.
+	StopTimeout time.Duration
+}
+
+// loadServerTLSConfig loads the server certificate and key. If ca is not empty,
+// clients are required to present a certificate signed by it.
+func loadServerTLSConfig(cert, key, ca string) (cfg *tls.Config, err error) {
+	cfg = &tls.Config{}
+	var pair tls.Certificate
+	if pair, err = tls.LoadX509KeyPair(cert, key); err != nil {
+		return
+	}
+	cfg.Certificates = []tls.Certificate{pair}
+	if ca != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(ca); err != nil {
+			return
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificates found in %s", ca)
+			return
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
+
+// NetReader is a LogReader that accepts logs from TCP, TLS, UDP and Unix sockets.
+// Every connection and datagram is parsed independently and the resulting logs are
+// annotated with the address of the peer.
+type NetReader struct {
+	cfg       NetReaderConfig
+	listeners []net.Listener
+	packets   []net.PacketConn
+	conns     map[net.Conn]struct{}
+	connsLock sync.Mutex
+	logchan   chan []logging.Log
+	errchan   chan error
+	quitchan  chan struct{}
+	stopchan  chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewNetReader starts listening on the given addresses
+func NewNetReader(addresses []string, cfg *NetReaderConfig) (r *NetReader, err error) {
+	r = new(NetReader)
+	if err = r.Init(addresses, cfg); err != nil {
+		r = nil
+	}
+	return
+}
+
+// Init starts listening on the given addresses
+func (r *NetReader) Init(addresses []string, cfg *NetReaderConfig) (err error) {
+	switch cfg.Framing {
+	case framingNewline, framingOctet, framingAuto:
+	default:
+		return fmt.Errorf("framing must be one of '%s', '%s' or '%s': found '%s'",
+			framingNewline, framingOctet, framingAuto, cfg.Framing)
+	}
+	if err = validateFormat(cfg.Format); err != nil {
+		return
+	}
+	r.cfg = *cfg
+	r.conns = make(map[net.Conn]struct{})
+	r.logchan = make(chan []logging.Log)
+	r.errchan = make(chan error, 1)
+	r.quitchan = make(chan struct{})
+	r.stopchan = make(chan struct{})
+	r.done = make(chan struct{})
+
+	for _, addr := range addresses {
+		if err = r.listen(addr); err != nil {
+			r.Close()
+			return
+		}
+	}
+	return
+}
+
+// removeStaleSocket removes the unix socket file left by a previous process
+func removeStaleSocket(path string) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+func (r *NetReader) listen(addr string) (err error) {
+	var network, address string
+	if network, address, err = parseListenAddress(addr); err != nil {
+		return
+	}
+	if network == "unix" || network == "unixgram" {
+		if err = removeStaleSocket(address); err != nil {
+			return
+		}
+	}
+	switch network {
+	case "udp", "unixgram":
+		var pc net.PacketConn
+		if pc, err = net.ListenPacket(network, address); err != nil {
+			return
+		}
+		r.packets = append(r.packets, pc)
+		r.wg.Add(1)
+		go r.servePackets(pc)
+	default:
+		var ln net.Listener
+		if network == "tls" {
+			if r.cfg.TLS == nil {
+				return fmt.Errorf("tls listener %s requires a certificate and a key", addr)
+			}
+			ln, err = tls.Listen("tcp", address, r.cfg.TLS)
+		} else {
+			ln, err = net.Listen(network, address)
+		}
+		if err != nil {
+			return
+		}
+		r.listeners = append(r.listeners, ln)
+		r.wg.Add(1)
+		go r.accept(ln)
+	}
+	return
+}
+
+func (r *NetReader) closing() bool {
+	select {
+	case <-r.quitchan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *NetReader) stopping() bool {
+	select {
+	case <-r.stopchan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *NetReader) fail(err error) {
+	select {
+	case r.errchan <- err:
+	default:
+	}
+}
+
+// send blocks until the logs are read so that peers are slowed down if pipeline is saturated
+func (r *NetReader) send(logs []logging.Log) bool {
+	if len(logs) == 0 {
+		return true
+	}
+	select {
+	case r.logchan <- logs:
+		return true
+	case <-r.quitchan:
+		return false
+	}
+}
+
+func annotatePeer(logs []logging.Log, peer string) []logging.Log {
+	for i := range logs {
+		logs[i].Set(keyPeer, peer)
+	}
+	return logs
+}
+
+func peerAddress(addr net.Addr, local net.Addr) string {
+	if addr == nil || addr.String() == "" || addr.String() == "@" {
+		// unnamed unix socket peers
+		return local.String()
+	}
+	return addr.String()
+}
+
+func (r *NetReader) accept(ln net.Listener) {
+	defer r.wg.Done()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if r.closing() || r.stopping() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.WithFields(log.Fields{
+					"tag":     "AcceptFailure",
+					"address": ln.Addr().String(),
+					"error":   err,
+				}).Error()
+				continue
+			}
+			r.fail(err)
+			return
+		}
+		r.connsLock.Lock()
+		if r.stopping() {
+			// new connections are not accepted once the reader is stopped
+			r.connsLock.Unlock()
+			c.Close()
+			return
+		}
+		r.conns[c] = struct{}{}
+		r.connsLock.Unlock()
+		r.wg.Add(1)
+		go r.serveConn(c)
+	}
+}
+
+func (r *NetReader) serveConn(c net.Conn) {
+	defer r.wg.Done()
+	defer func() {
+		r.connsLock.Lock()
+		delete(r.conns, c)
+		r.connsLock.Unlock()
+		c.Close()
+	}()
+
+	peer := peerAddress(c.RemoteAddr(), c.LocalAddr())
+	if err := r.readFrames(c, peer); err != nil && err != io.EOF && !r.closing() && !r.stopping() {
+		log.WithFields(log.Fields{
+			"tag":   "ConnReadFailure",
+			"peer":  peer,
+			"error": err,
+		}).Error()
+	}
+}
+
+// readOctetFrame reads a "LEN SP MSG" frame
+func readOctetFrame(br *bufio.Reader) (msg []byte, err error) {
+	var length string
+	if length, err = br.ReadString(' '); err != nil {
+		return nil, err
+	}
+	var n int
+	if n, err = strconv.Atoi(strings.TrimSpace(length)); err != nil {
+		return nil, fmt.Errorf("invalid octet count '%s'", strings.TrimSpace(length))
+	}
+	if n < 0 || n > maxFrameSize {
+		return nil, fmt.Errorf("octet count out of range: %d", n)
+	}
+	msg = make([]byte, n, n+1)
+	if _, err = io.ReadFull(br, msg); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// readLineFrame reads a newline terminated frame
+func readLineFrame(br *bufio.Reader) (msg []byte, err error) {
+	for {
+		var b []byte
+		b, err = br.ReadSlice('\n')
+		if len(msg)+len(b) > maxFrameSize {
+			return nil, fmt.Errorf("frame exceeds max size of %d bytes", maxFrameSize)
+		}
+		// b is only valid until the next read
+		msg = append(msg, b...)
+		if err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+func (r *NetReader) readFrame(br *bufio.Reader) ([]byte, error) {
+	framing := r.cfg.Framing
+	if framing == framingAuto {
+		framing = framingNewline
+		if b, err := br.Peek(1); err == nil && b[0] >= '0' && b[0] <= '9' {
+			framing = framingOctet
+		}
+	}
+	if framing == framingOctet {
+		return readOctetFrame(br)
+	}
+	return readLineFrame(br)
+}
+
+// readFrames parses all the frames sent by the peer. Logs are sent to the pipeline
+// once all the buffered data has been parsed.
+func (r *NetReader) readFrames(c net.Conn, peer string) error {
+	br := bufio.NewReaderSize(c, connBufferSize)
+	p := newChunkParser(r.cfg.Format)
+	var logs []logging.Log
+	for {
+		msg, err := r.readFrame(br)
+		if len(msg) > 0 {
+			if msg[len(msg)-1] != '\n' {
+				// octet counted frames and last line of connection are not terminated
+				msg = append(msg, '\n')
+			}
+			start := len(logs)
+			logs = p.Parse(string(msg), logs)
+			observeInput(inputLabelNet, len(msg), len(logs)-start)
+		}
+		if err != nil || br.Buffered() == 0 {
+			if !r.send(annotatePeer(logs, peer)) {
+				return nil
+			}
+			logs = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *NetReader) servePackets(pc net.PacketConn) {
+	defer r.wg.Done()
+	// datagrams are truncated to the size of the buffer, so the extra byte tells the larger ones apart
+	buf := make([]byte, maxDatagramSize+1)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if r.closing() || r.stopping() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			r.fail(err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		if n > maxDatagramSize {
+			metricDroppedDatagrams.Inc()
+			log.WithFields(log.Fields{
+				"tag":  "DatagramTooLarge",
+				"peer": peerAddress(addr, pc.LocalAddr()),
+				"size": maxDatagramSize,
+			}).Warn()
+			continue
+		}
+		msg := string(buf[:n])
+		if msg[n-1] != '\n' {
+			msg += "\n"
+		}
+		// every datagram is parsed independently
+		logs := newChunkParser(r.cfg.Format).Parse(msg, nil)
+		observeInput(inputLabelNet, n, len(logs))
+		if !r.send(annotatePeer(logs, peerAddress(addr, pc.LocalAddr()))) {
+			return
+		}
+	}
+}
+
+// ReadLogs blocks until logs are received from any of the peers or a listener fails
+func (r *NetReader) ReadLogs(logs []logging.Log) ([]logging.Log, error) {
+	select {
+	case batch := <-r.logchan:
+		return append(logs, batch...), nil
+	case err := <-r.errchan:
+		return logs, err
+	case <-r.quitchan:
+		return logs, io.EOF
+	case <-r.done:
+		return logs, io.EOF
+	}
+}
+
+// Stop stops all the listeners so no new connections are accepted. The open connections are
+// read until the peers close them or until StopTimeout, and ReadLogs returns io.EOF once the
+// logs received until then have been read.
+func (r *NetReader) Stop() {
+	r.stopListeners()
+}
+
+// stopListeners closes the listeners once and returns the first error
+func (r *NetReader) stopListeners() (err error) {
+	r.stopOnce.Do(func() {
+		close(r.stopchan)
+		for _, ln := range r.listeners {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		for _, pc := range r.packets {
+			if e := pc.Close(); e != nil && err == nil {
+				err = e
+			}
+			if addr, ok := pc.LocalAddr().(*net.UnixAddr); ok {
+				os.Remove(addr.Name)
+			}
+		}
+		if r.cfg.StopTimeout > 0 {
+			deadline := time.Now().Add(r.cfg.StopTimeout)
+			r.connsLock.Lock()
+			for c := range r.conns {
+				c.SetReadDeadline(deadline)
+			}
+			r.connsLock.Unlock()
+		}
+		go func() {
+			r.wg.Wait()
+			close(r.done)
+		}()
+	})
+	return
+}
+
+// Close stops all the listeners and closes all the connections
+func (r *NetReader) Close() (err error) {
+	if r.closing() {
+		return
+	}
+	close(r.quitchan)
+	err = r.stopListeners()
+	r.connsLock.Lock()
+	for c := range r.conns {
+		c.Close()
+	}
+	r.connsLock.Unlock()
+	r.wg.Wait()
+	return
+}
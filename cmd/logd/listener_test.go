@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestNetReader(t *testing.T, address string, framing string) *NetReader {
+	r, err := NewNetReader([]string{address}, &NetReaderConfig{Framing: framing, Format: formatNative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// octetFrame returns the message framed with octet counting
+func octetFrame(msg string) string {
+	return fmt.Sprintf("%d %s", len(msg), msg)
+}
+
+// sendFrames sends the data over a new connection to the stream listener of the reader and closes it
+func sendFrames(t *testing.T, r *NetReader, data string) {
+	addr := r.listeners[0].Addr()
+	c, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"tcp://127.0.0.1:5140", "tcp", "127.0.0.1:5140"},
+		{"tls://:6514", "tls", ":6514"},
+		{"udp://0.0.0.0:514", "udp", "0.0.0.0:514"},
+		{"unix:///var/run/logd.sock", "unix", "/var/run/logd.sock"},
+		{"unixgram:///dev/log", "unixgram", "/dev/log"},
+	}
+	for _, test := range tests {
+		network, address, err := parseListenAddress(test.addr)
+		if err != nil || network != test.network || address != test.address {
+			t.Errorf("%s: expected %s %s found %s %s: %v", test.addr, test.network, test.address, network, address, err)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:5140", "http://127.0.0.1:8080", "%"} {
+		if _, _, err := parseListenAddress(addr); err == nil {
+			t.Errorf("expected error parsing %s", addr)
+		}
+	}
+}
+
+func TestNetReaderFraming(t *testing.T) {
+	r := newTestNetReader(t, "tcp://127.0.0.1:0", framingNewline)
+	defer r.Close()
+	// last line of the connection is not terminated
+	sendFrames(t, r, logLine("first")+strings.TrimSuffix(logLine("second"), "\n"))
+	expectMessages(t, r, "first", "second")
+
+	r = newTestNetReader(t, "tcp://127.0.0.1:0", framingOctet)
+	defer r.Close()
+	// octet counted frames are not terminated and can contain newlines
+	sendFrames(t, r, octetFrame(strings.TrimSuffix(logLine("first"), "\n"))+octetFrame(logLine("second")+logLine("third")))
+	expectMessages(t, r, "first", "second", "third")
+
+	// auto framing uses octet counting for the frames that start with a digit
+	r, err := NewNetReader([]string{"tcp://127.0.0.1:0"}, &NetReaderConfig{Framing: framingAuto, Format: formatSyslog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sendFrames(t, r, "<13>first\n"+octetFrame("<13>second")+"<13>third\n")
+	expectMessages(t, r, "first", "second", "third")
+}
+
+func TestNetReaderPeer(t *testing.T) {
+	r := newTestNetReader(t, "tcp://127.0.0.1:0", framingNewline)
+	defer r.Close()
+	addr := r.listeners[0].Addr()
+	c, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte(logLine("first"))); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := r.ReadLogs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer, _ := logs[0].Get(keyPeer); len(logs) != 1 || peer != c.LocalAddr().String() {
+		t.Errorf("expected log annotated with peer %s found %v", c.LocalAddr(), logs)
+	}
+}
+
+func TestNetReaderMaxFrameSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		framing string
+		data    string
+	}{
+		{framingNewline, logLine("first") + logLine(strings.Repeat("a", maxFrameSize)) + logLine("discarded")},
+		{framingOctet, octetFrame(logLine("first")) + fmt.Sprintf("%d ", maxFrameSize+1) + logLine("discarded")},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("logd%d.sock", i))
+		r := newTestNetReader(t, "unix://"+path, test.framing)
+		defer r.Close()
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		// the connection can be closed before all the data is written
+		go c.Write([]byte(test.data))
+		// frames read before the frame larger than the max size are parsed and the connection is closed
+		expectMessages(t, r, "first")
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = ioutil.ReadAll(c); err != nil {
+			t.Errorf("%s: expected connection to be closed: %s", test.framing, err)
+		}
+		select {
+		case logs := <-r.logchan:
+			t.Errorf("%s: expected frames after the frame larger than the max size to be discarded: found %v", test.framing, logs)
+		default:
+		}
+	}
+}
+
+func TestNetReaderDatagramTooLarge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logd.sock")
+	r := newTestNetReader(t, "unixgram://"+path, framingNewline)
+	defer r.Close()
+
+	c, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dropped := metricDroppedDatagrams.Value()
+	// datagrams larger than the max size are dropped instead of truncated
+	if _, err = c.Write([]byte(logLine(strings.Repeat("a", maxDatagramSize)))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Write([]byte(logLine("next"))); err != nil {
+		t.Fatal(err)
+	}
+	expectMessages(t, r, "next")
+	if n := metricDroppedDatagrams.Value() - dropped; n != 1 {
+		t.Errorf("expected 1 dropped datagram found %v", n)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"io"
@@ -9,10 +8,10 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"runtime"
 	"runtime/pprof"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ernestrc/logd/logging"
 	"github.com/ernestrc/logd/lua"
@@ -23,6 +22,7 @@ import (
 
 const pprofServer = "localhost:6060"
 const defaultDebugFile = "/dev/stderr"
+const defaultShutdownTimeout = 30 * time.Second
 
 type dirFlagType []string
 
@@ -39,16 +39,21 @@ func (d *dirFlagType) Set(v string) error {
 }
 
 // TODO check that bench is not being called with -r flag
-var scriptFlag = flag.String("R", "", "Run Lua script processing pipeline")
+var configFlag = flag.String("c", "", "read the script, inputs, parser, sandbox, outputs and daemon options from this TOML file. Options given in the command line take precedence")
 var benchFlag = flag.String("B", "", "Benchmark Lua script processing pipeline")
 var fullBenchFlag = flag.String("F", "", "Benchmark full processing pipeline (log parsing + lua processing)")
-var fileFlag = flag.String("f", "/dev/stdin", "File to read data from")
 var cpuProfileFlag = flag.String("p", "", "write cpu profile to file")
 var memProfileFlag = flag.String("m", "", "write mem profile to file on SIGUSR2")
 var logDebugLevel = flag.Bool("d", false, "enable debug logs")
 var logDebugFile = flag.String("o", defaultDebugFile, "write logs to file")
+var metricsFlag = flag.String("metrics", "", fmt.Sprintf("serve the internal metrics in the Prometheus exposition format at http://<address>%s", metricsPath))
+var adminFlag = flag.String("admin", "", fmt.Sprintf("serve the admin API to reload the script, flush outputs, change config and inspect logd at http://<address>%s", adminPath))
 var profServer = flag.Bool("s", false, fmt.Sprintf("start a pprof server at %s", pprofServer))
-var dirFlag dirFlagType
+var shutdownTimeoutFlag = flag.Duration("shutdown-timeout", defaultShutdownTimeout, "time to wait on SIGTERM or SIGINT for the inputs to stop and, afterwards, for the outputs to deliver the pending data")
+var watchScriptFlag = flag.Bool("watch-script", false, "reload the lua script when it or any of the modules it requires change. If the new version fails to load, the previous one keeps running")
+
+// pipelineFlags configures the pipeline of the command line
+var pipelineFlags = newPipelineConfig("", flag.CommandLine)
 
 func printFlag(f *flag.Flag) {
 	s := fmt.Sprintf("\t-%s", f.Name)
@@ -87,6 +92,7 @@ func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage:\n\n")
 		fmt.Fprintf(os.Stderr, "\t%s (-S|-R|-B) <lua> [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\t%s -c <config> [options]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nCommands:\n\n")
 		flag.VisitAll(printCommands)
 		fmt.Fprintf(os.Stderr, "\nOptions:\n\n")
@@ -94,48 +100,22 @@ func init() {
 	}
 }
 
-func runPipeline(l *lua.Sandbox, exit chan<- error, reader io.Reader) {
-	p := logging.NewParser()
-
+func runPipeline(c *control, exit chan<- error, reader LogReader) {
 	logs := make([]logging.Log, 0)
 
-	var buf [64 * 1000 * 1000]byte
 	var err error
-	var n int
-
-	if l.ProtectedMode() {
-	protectedCall:
-		for {
-			if n, err = reader.Read(buf[:]); err != nil {
-				break
-			}
-			logs = p.Parse(string(buf[:n]), logs)
-			for _, log := range logs {
-				if err = l.ProtectedCallOnLog(&log); err != nil {
-					break protectedCall
-				}
-			}
-			logs = logs[:0]
+	for {
+		if logs, err = reader.ReadLogs(logs[:0]); err != nil {
+			break
 		}
-	} else {
-	call:
-		for {
-			if n, err = reader.Read(buf[:]); err != nil {
-				break
-			}
-
-			logs = p.Parse(string(buf[:n]), logs)
-			for _, log := range logs {
-				if err = l.CallOnLog(&log); err != nil {
-					break call
-				}
-			}
-			logs = logs[:0]
+		if err = c.process(logs); err != nil {
+			break
 		}
 	}
+	c.finish()
 	if err != nil && err != io.EOF {
 		fmt.Fprint(os.Stderr, "error: ")
-		exit <- err
+		exit <- c.pipeline.pipelineError(err)
 	} else {
 		exit <- nil
 	}
@@ -152,17 +132,40 @@ func exitError(err error) {
 	os.Exit(1)
 }
 
-func validateFlags() string {
-	if *scriptFlag == "" && *benchFlag == "" && *fullBenchFlag == "" {
+func validateFlags(pipelines []*pipelineConfig) string {
+	if len(pipelines) != 0 {
+		validatePipelines(pipelines)
+		return ""
+	}
+	script := pipelineFlags.script
+	if script == "" && *benchFlag == "" && *fullBenchFlag == "" {
 		usageError(fmt.Errorf("no lua script provided"))
 	}
-	if (*scriptFlag != "" && *benchFlag != "") ||
+	if (script != "" && *benchFlag != "") ||
 		(*fullBenchFlag != "" && *benchFlag != "") ||
-		(*fullBenchFlag != "" && *scriptFlag != "") {
+		(*fullBenchFlag != "" && script != "") {
 		usageError(fmt.Errorf("only one mode is allowed"))
 	}
-	if *scriptFlag != "" {
-		return *scriptFlag
+	if err := pipelineFlags.validate(); err != nil {
+		usageError(err)
+	}
+	if pipelineFlags.container != "" && script == "" {
+		usageError(fmt.Errorf("container log formats can only be used to run lua scripts reading files with -f or -r"))
+	}
+	if (len(pipelineFlags.listen) != 0 || pipelineFlags.http != "") && script == "" {
+		usageError(fmt.Errorf("network listeners can only be used to run lua scripts"))
+	}
+	if len(pipelineFlags.files) > 1 && script == "" {
+		usageError(fmt.Errorf("multiple files can only be read to run lua scripts"))
+	}
+	if len(pipelineFlags.dirs) != 0 && script == "" {
+		usageError(fmt.Errorf("directories can only be monitored to run lua scripts"))
+	}
+	if *watchScriptFlag && script == "" {
+		usageError(fmt.Errorf("scripts can only be watched to run lua scripts"))
+	}
+	if script != "" {
+		return script
 	}
 
 	if *fullBenchFlag != "" {
@@ -172,32 +175,40 @@ func validateFlags() string {
 	return *benchFlag
 }
 
-type bufioReadCloser struct {
-	bufio.Reader
-}
-
-func (b *bufioReadCloser) Close() error {
-	return nil
-}
-
-func getReader() (io.ReadCloser, error) {
-	if len(dirFlag) != 0 {
-		reader, err := NewReader()
-		if err != nil {
-			panic(err)
+// validatePipelines validates the pipelines of the config file, which cannot be combined
+// with the modes and pipeline options of the command line
+func validatePipelines(pipelines []*pipelineConfig) {
+	if *benchFlag != "" || *fullBenchFlag != "" {
+		usageError(fmt.Errorf("pipelines of the config file cannot be benchmarked"))
+	}
+	// the flags of the command line pipeline are defined in a different set to tell them apart
+	options := newPipelineConfig("", flag.NewFlagSet("", flag.ContinueOnError)).flags
+	flag.Visit(func(f *flag.Flag) {
+		if options.Lookup(f.Name) != nil {
+			usageError(fmt.Errorf("-%s cannot be used with the pipelines of the config file", f.Name))
 		}
-		for _, dir := range dirFlag {
-			if err := reader.Watch(dir); err != nil {
-				return nil, err
+	})
+	stdin, checkpoints := "", make(map[string]string)
+	for _, p := range pipelines {
+		if p.script == "" {
+			exitError(fmt.Errorf("%s: pipelines.%s.script is not set", p.configPath, p.name))
+		}
+		if err := p.validate(); err != nil {
+			exitError(p.pipelineError(err))
+		}
+		if p.readsStdin() {
+			if stdin != "" {
+				exitError(fmt.Errorf("pipelines %s and %s read %s: only one pipeline can read it", stdin, p.name, stdinPath))
 			}
+			stdin = p.name
+		}
+		if p.checkpoint != "" && len(p.dirs) != 0 {
+			if other, ok := checkpoints[p.checkpoint]; ok {
+				exitError(fmt.Errorf("pipelines %s and %s use the same checkpoint file %s", other, p.name, p.checkpoint))
+			}
+			checkpoints[p.checkpoint] = p.name
 		}
-		return reader, nil
-	}
-	reader, err := os.Open(*fileFlag)
-	if err != nil {
-		exitError(err)
 	}
-	return &bufioReadCloser{*bufio.NewReader(reader)}, nil
 }
 
 func createProfileFile(name string) *os.File {
@@ -230,33 +241,56 @@ func memProfile() *os.File {
 	return createProfileFile(*memProfileFlag)
 }
 
-func handleSignal(l *lua.Sandbox, script string, exit chan error, sig os.Signal) {
+func handleSignal(controls []*control, exit chan error, sig os.Signal) {
 	fmt.Fprintf(os.Stderr, "received: %s\n", sig)
 	switch sig {
 	case syscall.SIGUSR2:
 		if f := memProfile(); f != nil {
 			defer f.Close()
-			runtime.GC()
-			if err := pprof.WriteHeapProfile(f); err != nil {
+			if err := writeHeapProfile(f); err != nil {
 				exit <- err
 			}
 		}
 	case syscall.SIGUSR1:
-		// reload lua state
-		if err := l.Init(script); err != nil {
-			panic(err)
+		// errors are logged and the scripts keep running
+		for _, c := range controls {
+			c.reload()
+		}
+	case syscall.SIGTERM, syscall.SIGINT:
+		for _, c := range controls {
+			c.stop(exit, *shutdownTimeoutFlag)
 		}
 	default:
 		exit <- nil
 	}
 }
 
-func sigHandler(l *lua.Sandbox, script string, exit chan error, sig chan os.Signal) {
+func sigHandler(controls []*control, exit chan error, sig chan os.Signal) {
 	for sig := range sig {
-		if l.SignalHandlerDefined() {
-			l.CallOnSignal(sig)
+		for _, c := range controls {
+			if l := c.current(); l.SignalHandlerDefined() {
+				l.CallOnSignal(sig)
+			}
 		}
-		handleSignal(l, script, exit, sig)
+		handleSignal(controls, exit, sig)
+	}
+}
+
+func runMetricsServer(exit chan error) {
+	if err := serveMetrics(*metricsFlag); err != nil {
+		exit <- err
+	}
+}
+
+func runAdminServer(controls []*control, exit chan error) {
+	if err := serveAdmin(*adminFlag, controls); err != nil {
+		exit <- err
+	}
+}
+
+func runScriptWatcher(c *control, exit chan error) {
+	if err := c.watchScript(); err != nil {
+		exit <- c.pipeline.pipelineError(err)
 	}
 }
 
@@ -303,13 +337,48 @@ func initLogging() {
 	}
 }
 
+// startPipeline creates the sandbox and the inputs of the pipeline and starts processing
+// its logs, or benchmarking the script with -B and -F
+func startPipeline(p *pipelineConfig, script string, exit chan error) *control {
+	l, err := lua.NewSandboxConfig(p.name, script, p.sandbox)
+	if err != nil {
+		exitError(p.pipelineError(err))
+	}
+
+	if p.script == "" {
+		readCloser, err := p.reader()
+		if err != nil {
+			exitError(err)
+		}
+		if *benchFlag != "" {
+			go runLuaBench(l, exit, readCloser)
+		} else {
+			go runFullBench(l, exit, readCloser)
+		}
+		return newControl(l, script, p, nil)
+	}
+
+	reader, err := p.logReader()
+	if err != nil {
+		exitError(p.pipelineError(err))
+	}
+	c := newControl(l, script, p, reader)
+	go runPipeline(c, exit, reader)
+	return c
+}
+
 func main() {
 	var err error
-	var l *lua.Sandbox
 
-	flag.Var(&dirFlag, "r", "Monitor directory recursively, ingesting all the new data written to files. Overrides -f flag")
 	flag.Parse()
-	script := validateFlags()
+
+	var pipelines []*pipelineConfig
+	if *configFlag != "" {
+		if pipelines, err = loadConfig(*configFlag); err != nil {
+			exitError(err)
+		}
+	}
+	script := validateFlags(pipelines)
 
 	initLogging()
 
@@ -317,42 +386,58 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	l, err = lua.NewSandbox(script)
-	if err != nil {
-		exitError(err)
-	}
-	defer l.Close()
-
+	// exit is not closed, since pipelines that failed to stop may still send to it
 	exit := make(chan error)
-	defer close(exit)
 
-	readCloser, err := getReader()
-	if err != nil {
-		exitError(err)
+	var controls []*control
+	if len(pipelines) == 0 {
+		controls = append(controls, startPipeline(pipelineFlags, script, exit))
 	}
-	defer readCloser.Close()
-
-	if *scriptFlag != "" {
-		go runPipeline(l, exit, readCloser)
-	} else if *benchFlag != "" {
-		go runLuaBench(l, exit, readCloser)
-	} else {
-		go runFullBench(l, exit, readCloser)
+	for _, p := range pipelines {
+		controls = append(controls, startPipeline(p, p.script, exit))
 	}
 
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	defer close(signals)
 
-	go sigHandler(l, script, exit, signals)
-	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go sigHandler(controls, exit, signals)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
 
 	if *profServer {
 		go runPprofServer(exit)
 	}
 
-	err = <-exit
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+	if *metricsFlag != "" {
+		go runMetricsServer(exit)
+	}
+
+	if *adminFlag != "" {
+		go runAdminServer(controls, exit)
+	}
+
+	if *watchScriptFlag {
+		for _, c := range controls {
+			go runScriptWatcher(c, exit)
+		}
+	}
+
+	status := 0
+	// logd exits once all the pipelines finish, or as soon as one of them or a server fails
+	for running := len(controls); running > 0; running-- {
+		if err = <-exit; err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			status = 1
+			break
+		}
+	}
+	// pipelines still running after a failure are stopped before shutting them down
+	stopPipelines(controls, *shutdownTimeoutFlag)
+	// data buffered by the outputs is delivered and read positions are persisted
+	for _, err = range shutdown(controls, *shutdownTimeoutFlag) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		status = 1
+	}
+	if status != 0 {
+		os.Exit(status)
 	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ernestrc/logd/lua"
+	"github.com/ernestrc/logd/metrics"
+)
+
+// metricsPath is the path of the Prometheus metrics endpoint
+const metricsPath = "/metrics"
+
+// input names used as the label of the input metrics
+const (
+	inputLabelFile = "file"
+	inputLabelDir  = "dir"
+	inputLabelNet  = "net"
+	inputLabelHTTP = "http"
+)
+
+var (
+	metricBytesRead = metrics.Default.NewCounterVec("logd_input_bytes_total",
+		"Bytes read from the inputs", "input")
+	metricLogsParsed = metrics.Default.NewCounterVec("logd_input_logs_total",
+		"Logs parsed from the data read from the inputs", "input")
+	metricParseErrors = metrics.Default.NewCounterVec("logd_input_parse_errors_total",
+		"Lines or messages that could not be parsed", "format")
+	metricOpenFiles = metrics.Default.NewGauge("logd_dir_reader_open_files",
+		"Files open by the readers of monitored directories")
+	metricDroppedDatagrams = metrics.Default.NewCounter("logd_net_reader_dropped_datagrams_total",
+		"Datagrams dropped because they are larger than the max datagram size")
+)
+
+// observeInput counts the bytes read from an input and the logs parsed from them
+func observeInput(input string, bytes, logs int) {
+	metricBytesRead.WithLabelValues(input).Add(float64(bytes))
+	metricLogsParsed.WithLabelValues(input).Add(float64(logs))
+}
+
+// serveMetrics serves the internal metrics and the metrics defined by the Lua script
+// in the Prometheus text exposition format
+func serveMetrics(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metrics.Handler(metrics.Default, lua.Metrics))
+	return http.ListenAndServe(address, mux)
+}
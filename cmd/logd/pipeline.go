@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ernestrc/logd/config"
+	"github.com/ernestrc/logd/lua"
+)
+
+// pipelineConfig configures the inputs, parser, script and sandbox of a pipeline. The pipeline
+// configured with the command line flags is unnamed, the ones of the pipelines tables of the
+// config file are named after their table.
+type pipelineConfig struct {
+	name   string
+	script string
+	// flags are the options of the pipeline, which have the same name as the command line flags
+	flags *flag.FlagSet
+
+	files              dirFlagType
+	dirs               dirFlagType
+	listen             dirFlagType
+	httpHeaders        dirFlagType
+	follow             bool
+	fromBeginning      bool
+	sourceHost         bool
+	sourceInode        bool
+	checkpoint         string
+	checkpointInterval time.Duration
+	framing            string
+	tlsCert            string
+	tlsKey             string
+	tlsCA              string
+	http               string
+	httpQueue          int
+	format             string
+	container          string
+
+	// sandbox are the values set with config_set once the script is loaded
+	sandbox map[string]interface{}
+	// configPath is the -c file and keys are the config file keys that defined the sandbox values
+	configPath string
+	keys       map[string]config.Value
+}
+
+// newPipelineConfig returns a pipeline configured with flags defined in the given flag set
+func newPipelineConfig(name string, flags *flag.FlagSet) *pipelineConfig {
+	p := &pipelineConfig{name: name, flags: flags}
+	flags.StringVar(&p.script, "R", "", "Run Lua script processing pipeline")
+	flags.Var(&p.files, "f", fmt.Sprintf("File to read data from [default: %s]. Can be repeated and contain glob patterns to read several files concurrently", stdinPath))
+	flags.Var(&p.dirs, "r", "Monitor directory recursively, ingesting all the new data written to files. Overrides -f flag. "+
+		"Files can be filtered with comma separated options: <dir>,include=<glob>,exclude=<glob>,max_age=<duration>")
+	flags.Var(&p.listen, "l", "Listen for logs on tcp://host:port, tls://host:port, udp://host:port, unix:///path or unixgram:///path. Overrides -f and -r flags")
+	flags.Var(&p.httpHeaders, "http-header", "Set the value of the given request header as a property of the logs POSTed to the -http endpoint")
+	flags.StringVar(&p.format, "format", formatNative, fmt.Sprintf("format of the input logs: '%s' or '%s'", formatNative, formatSyslog))
+	flags.StringVar(&p.container, "container", "", fmt.Sprintf("unwrap the logs read with -f and -r from the container runtime log format: '%s' or '%s'", containerDocker, containerCRI))
+	flags.StringVar(&p.framing, "framing", "", fmt.Sprintf("framing of stream sockets: '%s', '%s' or '%s'. Default is '%s' with syslog format and '%s' otherwise",
+		framingNewline, framingOctet, framingAuto, framingAuto, framingNewline))
+	flags.StringVar(&p.tlsCert, "tls-cert", "", "certificate file of tls:// listeners")
+	flags.StringVar(&p.tlsKey, "tls-key", "", "private key file of tls:// listeners")
+	flags.StringVar(&p.tlsCA, "tls-ca", "", "CA certificate file used to verify the client certificates of tls:// listeners")
+	flags.StringVar(&p.http, "http", "", fmt.Sprintf("Accept logs POSTed to http://<address>%s. Overrides -f, -r and -l flags", ingestPath))
+	flags.IntVar(&p.httpQueue, "http-queue", defaultIngestQueue, "number of http requests waiting to be processed before new requests are rejected with 429")
+	flags.StringVar(&p.checkpoint, "checkpoint", "", "store the read positions of the files monitored with -r in this file and resume from them on startup")
+	flags.DurationVar(&p.checkpointInterval, "checkpoint-interval", defaultCheckpointInterval, "interval at which read positions are stored in the -checkpoint file")
+	flags.BoolVar(&p.fromBeginning, "from-beginning", false, "read files monitored with -r that have no stored read position from the beginning instead of from the end")
+	flags.BoolVar(&p.sourceHost, "source-host", false, "set the hostname as the 'host' property of the logs read from files monitored with -r")
+	flags.BoolVar(&p.follow, "follow", false, "follow the files given with -f like tail -F: wait for new data, and reopen files that are rotated or do not exist yet")
+	flags.BoolVar(&p.sourceInode, "source-inode", false, "set the inode of the source file as the 'inode' property of the logs read from files monitored with -r")
+	return p
+}
+
+// newNamedPipelineConfig returns a pipeline of the config file
+func newNamedPipelineConfig(name, configPath string) *pipelineConfig {
+	p := newPipelineConfig(name, flag.NewFlagSet(name, flag.ContinueOnError))
+	p.configPath = configPath
+	return p
+}
+
+// validate checks the options that can be wrong regardless of the mode logd runs in
+func (p *pipelineConfig) validate() error {
+	if err := validateFormat(p.format); err != nil {
+		return err
+	}
+	if err := validateContainer(p.container); err != nil {
+		return err
+	}
+	if p.container != "" && (len(p.listen) != 0 || p.http != "") {
+		return fmt.Errorf("container log formats can only be used to read files with -f or -r")
+	}
+	return nil
+}
+
+// readsStdin returns whether the pipeline reads its logs from stdin
+func (p *pipelineConfig) readsStdin() bool {
+	if p.http != "" || len(p.listen) != 0 || len(p.dirs) != 0 {
+		return false
+	}
+	for _, path := range p.filePaths() {
+		if isStdin(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pipelineError points errors setting the sandbox configuration at the config file key that defined
+// the value, and other errors at the pipeline
+func (p *pipelineConfig) pipelineError(err error) error {
+	if e, ok := err.(*lua.ConfigError); ok {
+		if v, ok := p.keys[e.Key]; ok {
+			return config.ValueError(p.configPath, v, "%s", e.Err)
+		}
+	}
+	if p.name != "" {
+		return fmt.Errorf("pipeline %s: %s", p.name, err)
+	}
+	return err
+}
+
+func (p *pipelineConfig) dirReader() (*DirReader, error) {
+	reader, err := NewReader(&DirReaderConfig{
+		Checkpoint:         p.checkpoint,
+		CheckpointInterval: p.checkpointInterval,
+		FromBeginning:      p.fromBeginning,
+		Format:             p.format,
+		Container:          p.container,
+		SourceHost:         p.sourceHost,
+		SourceInode:        p.sourceInode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range p.dirs {
+		dir, filter, err := parseWatchFlag(v)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		if err := reader.Watch(dir, &filter); err != nil {
+			reader.Close()
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+func (p *pipelineConfig) filePaths() []string {
+	if len(p.files) == 0 {
+		return []string{stdinPath}
+	}
+	return p.files
+}
+
+// reader returns the reader of the file benchmarked with -B and -F
+func (p *pipelineConfig) reader() (io.ReadCloser, error) {
+	paths, err := expandFilePaths(p.filePaths())
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 1 {
+		return nil, fmt.Errorf("multiple files can only be read to run lua scripts")
+	}
+	reader := os.Stdin
+	if !isStdin(paths[0]) {
+		if reader, err = os.Open(paths[0]); err != nil {
+			return nil, err
+		}
+	}
+	return newDecompressReadCloser(paths[0], reader)
+}
+
+func (p *pipelineConfig) fileReader() (*FileReader, error) {
+	paths, err := expandFilePaths(p.filePaths())
+	if err != nil {
+		return nil, err
+	}
+	return NewFileReader(paths, &FileReaderConfig{
+		Format:    p.format,
+		Container: p.container,
+		Follow:    p.follow,
+		Annotate:  len(paths) > 1,
+	})
+}
+
+func (p *pipelineConfig) netReaderConfig() (cfg *NetReaderConfig, err error) {
+	cfg = &NetReaderConfig{Framing: p.framing, Format: p.format, StopTimeout: *shutdownTimeoutFlag}
+	if cfg.Framing == "" {
+		cfg.Framing = framingNewline
+		if cfg.Format == formatSyslog {
+			cfg.Framing = framingAuto
+		}
+	}
+	if p.tlsCert != "" || p.tlsKey != "" {
+		cfg.TLS, err = loadServerTLSConfig(p.tlsCert, p.tlsKey, p.tlsCA)
+	}
+	return
+}
+
+func (p *pipelineConfig) logReader() (LogReader, error) {
+	if p.http != "" {
+		return NewHTTPReader(&HTTPReaderConfig{
+			Address: p.http,
+			Format:  p.format,
+			Headers: p.httpHeaders,
+			Queue:   p.httpQueue,
+		})
+	}
+	if len(p.listen) != 0 {
+		cfg, err := p.netReaderConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewNetReader(p.listen, cfg)
+	}
+	if len(p.dirs) != 0 {
+		return p.dirReader()
+	}
+	return p.fileReader()
+}
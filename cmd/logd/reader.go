@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/ernestrc/logd/logging"
+)
+
+const readBufferSize = 64 * 1000 * 1000
+
+// LogReader is an input of structured logs
+type LogReader interface {
+	// ReadLogs appends the next available logs to the given slice and returns it.
+	// Calling ReadLogs again signals that the previously returned logs have been processed.
+	ReadLogs(logs []logging.Log) ([]logging.Log, error)
+	// Stop stops reading new data. ReadLogs returns the logs that have already been
+	// received, including the incomplete lines buffered by the parsers, and io.EOF afterwards.
+	Stop()
+	Close() error
+}
+
+// FileStatus is the read position of a file read by a LogReader
+type FileStatus struct {
+	Path string `json:"path"`
+	// Position is the number of bytes read from the file
+	Position int64 `json:"position"`
+	// Rotated is set if the file has been rotated and its remaining data is being read
+	Rotated bool `json:"rotated,omitempty"`
+}
+
+// fileLister is implemented by the LogReaders that read files
+type fileLister interface {
+	// Files returns the files being read sorted by path
+	Files() []FileStatus
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/ernestrc/logd/lua"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// scriptReloadDelay is the time to wait after a script file changes before reloading it,
+// so that the several events generated by editors when saving a file cause a single reload
+const scriptReloadDelay = 200 * time.Millisecond
+
+// replace loads the script in a new sandbox and, if it loads successfully, hands over
+// the state of the current sandbox to it and swaps them. Otherwise, the current sandbox
+// keeps running.
+func (c *control) replace() (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer func() {
+		if err != nil {
+			c.lastError, c.lastErrorAt = fmt.Errorf("reload: %s", err), time.Now()
+		}
+	}()
+
+	var next *lua.Sandbox
+	if next, err = lua.LoadSandbox(c.pipeline.name, c.script, c.pipeline.sandbox); err != nil {
+		return
+	}
+	c.sandboxLock.Lock()
+	if c.closed {
+		c.sandboxLock.Unlock()
+		next.Close()
+		return fmt.Errorf("pipeline is shut down")
+	}
+	prev := c.sandbox
+	if err = prev.Replace(next); err != nil {
+		c.sandboxLock.Unlock()
+		next.Close()
+		return
+	}
+	c.sandbox = next
+	c.sandboxLock.Unlock()
+	c.reloads++
+
+	// data buffered by the outputs of the previous sandbox is delivered
+	if e := prev.Close(); e != nil {
+		fields := log.Fields{"tag": "ScriptCloseFailure", "script": c.script, "error": e}
+		if c.pipeline.name != "" {
+			fields["pipeline"] = c.pipeline.name
+		}
+		log.WithFields(fields).Error()
+	}
+	return
+}
+
+// reload replaces the sandbox with a new one running the current version of the script
+// and logs the outcome. If the script fails to load, the current sandbox keeps running.
+func (c *control) reload() (err error) {
+	fields := log.Fields{"script": c.script}
+	if c.pipeline.name != "" {
+		fields["pipeline"] = c.pipeline.name
+	}
+	if err = c.replace(); err != nil {
+		fields["tag"], fields["error"] = "ScriptReloadFailure", err
+		log.WithFields(fields).Error()
+		return
+	}
+	fields["tag"] = "ScriptReloaded"
+	log.WithFields(fields).Info()
+	return
+}
+
+// watchFiles watches the directories of the script files, since editors usually
+// replace files when saving them, and returns the absolute paths of the files
+func (c *control) watchFiles(watcher *fsnotify.Watcher) (files map[string]bool, err error) {
+	files = make(map[string]bool)
+	for _, file := range c.current().ScriptFiles() {
+		if file, err = filepath.Abs(file); err != nil {
+			return
+		}
+		if err = watcher.Add(filepath.Dir(file)); err != nil {
+			return
+		}
+		files[file] = true
+	}
+	return
+}
+
+// watchScript reloads the script when it or any of the modules it requires change
+func (c *control) watchScript() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	files, err := c.watchFiles(watcher)
+	if err != nil {
+		return err
+	}
+	var reload <-chan time.Time
+	for {
+		select {
+		case event := <-watcher.Events:
+			if files[event.Name] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				reload = time.After(scriptReloadDelay)
+			}
+		case err := <-watcher.Errors:
+			log.WithFields(log.Fields{
+				"tag":   "ScriptWatchFailure",
+				"error": err,
+			}).Error()
+		case <-reload:
+			reload = nil
+			if err := c.reload(); err != nil {
+				continue
+			}
+			// modules required by the new version are watched too
+			if files, err = c.watchFiles(watcher); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+	"github.com/ernestrc/logd/lua"
+)
+
+const testReloadScript = `
+local logd = require("logd")
+local helper = require("helper")
+logd.config_set("tick", helper.tick)
+function logd.on_tick() end
+function logd.on_log(logptr) end
+`
+
+// waitReload writes the file until the status of the pipeline satisfies done, since
+// the file can be written before the watcher is started
+func waitReload(t *testing.T, c *control, path, data string, done func(adminStatus) bool) adminStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		writeFile(t, path, data)
+		for i := 0; i < 10; i++ {
+			time.Sleep(scriptReloadDelay / 4)
+			if status := c.status(); done(status) {
+				return status
+			}
+		}
+	}
+	t.Fatalf("timed out waiting for %s to be reloaded", path)
+	return adminStatus{}
+}
+
+func TestWatchScript(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "main.lua")
+	helper := filepath.Join(dir, "helper.lua")
+	writeFile(t, script, testReloadScript)
+	writeFile(t, helper, "return {tick = 60000}")
+	sandbox, err := lua.NewSandboxConfig("", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newControl(sandbox, script, &pipelineConfig{}, nil)
+	defer func() { c.current().Close() }()
+	go c.watchScript()
+
+	// modules required by the script are watched too
+	reloaded := func(s adminStatus) bool { return s.Reloads == 1 || s.LastError != "" }
+	if status := waitReload(t, c, helper, "return {tick = 50000}", reloaded); status.LastError != "" {
+		t.Fatalf("unexpected reload error %s", status.LastError)
+	}
+	if tick := fmt.Sprint(c.current().Config()["tick"]); tick != "50000" {
+		t.Errorf("expected the new version of the module to be loaded: found tick %s", tick)
+	}
+
+	// the current version keeps running if the new one fails to load
+	current := c.current()
+	failed := func(s adminStatus) bool { return s.LastError != "" }
+	if status := waitReload(t, c, script, "invalid script", failed); status.LastError == "" || status.Reloads != 1 {
+		t.Errorf("expected the reload to fail: found %+v", status)
+	}
+	if c.current() != current {
+		t.Errorf("expected the sandbox not to be replaced after a failed reload")
+	}
+
+	// and the script is still watched afterwards
+	fixed := func(s adminStatus) bool { return s.Reloads == 2 }
+	waitReload(t, c, script, testReloadScript+"logd.config_set(\"tick\", 40000)\n", fixed)
+	if tick := fmt.Sprint(c.current().Config()["tick"]); tick != "40000" {
+		t.Errorf("expected the fixed script to be loaded: found tick %s", tick)
+	}
+}
+
+func TestReloadRollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "main.lua")
+	writeFile(t, filepath.Join(dir, "helper.lua"), "return {tick = 60000}")
+	writeFile(t, script, testReloadScript)
+	sandbox, err := lua.NewSandboxConfig("", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newControl(sandbox, script, &pipelineConfig{}, nil)
+	defer func() { c.current().Close() }()
+
+	// the new version loads but fails to take over the state of the current one
+	writeFile(t, script, testReloadScript+`
+function logd.on_reload(state) error("failed to reload") end
+`)
+	if err = c.reload(); err == nil {
+		t.Fatalf("expected logd.on_reload error")
+	}
+	if c.current() != sandbox {
+		t.Errorf("expected the current sandbox to keep running")
+	}
+	if status := c.status(); status.Reloads != 0 || status.LastError == "" {
+		t.Errorf("expected the reload error in the status: found %+v", status)
+	}
+	if err = c.process(make([]logging.Log, 1)); err != nil {
+		t.Errorf("expected the current sandbox to process logs: %s", err)
+	}
+}
@@ -1,39 +1,196 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ernestrc/logd/logging"
 	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCheckpointInterval = 5 * time.Second
+
+const (
+	// rotateGracePeriod is the time a rotated file is kept open to read the data written by
+	// writers that have not reopened it yet, unless the new file is written to before
+	rotateGracePeriod = 5 * time.Second
+	// rotatePollInterval is the interval at which rotated files are read while kept open,
+	// since writes to files moved out of the watched directories are not notified
+	rotatePollInterval = 250 * time.Millisecond
+)
+
+// properties set on every log read by DirReader
+const (
+	keyFile  = "file"
+	keyInode = "inode"
 )
 
 type watchFile struct {
-	fd   *os.File
-	info os.FileInfo
+	name   string
+	fd     *os.File
+	info   os.FileInfo
+	parser chunkParser
+	// reader decompresses archives
+	reader io.ReadCloser
+	// newline is false if the last data read is an incomplete line
+	newline bool
+	// pos is the current read offset
+	pos int64
+	// offset is the read offset up to which logs have been processed
+	offset int64
+	// rotated is the time at which the file was renamed or removed
+	rotated time.Time
+	// head is the fingerprint of the data read from the beginning of the file
+	head fingerprint
+}
+
+// updateHead fingerprints the data read from the beginning of the file until it is fingerprintSize
+func (w *watchFile) updateHead() (err error) {
+	if w.head.size < fingerprintSize && w.head.size < w.pos {
+		w.head, err = readFingerprint(w.fd, w.pos)
+	}
+	return
+}
+
+// pendingOffset is the offset of a file that will be committed once
+// the logs read have been processed
+type pendingOffset struct {
+	file   *watchFile
+	offset int64
+}
+
+// DirReaderConfig is the configuration of a DirReader
+type DirReaderConfig struct {
+	// Checkpoint is the path of the file where read positions are stored.
+	// If empty, read positions are not stored.
+	Checkpoint string
+	// CheckpointInterval is the interval at which read positions are stored
+	CheckpointInterval time.Duration
+	// FromBeginning reads files without a stored read position from the beginning
+	// instead of from the end
+	FromBeginning bool
+	// Format of the files: formatNative or formatSyslog
+	Format string
+	// Container is the log format of the container runtime that wraps the logs: containerDocker,
+	// containerCRI or empty if logs are not wrapped
+	Container string
+	// SourceHost sets the hostname as a property of every log
+	SourceHost bool
+	// SourceInode sets the inode of the source file as a property of every log
+	SourceInode bool
 }
 
 type DirReader struct {
-	watcher *fsnotify.Watcher
-	files   map[string]*watchFile
-	tail    bool
+	cfg      DirReaderConfig
+	watcher  *fsnotify.Watcher
+	filters  map[string]*FileFilter
+	files    map[string]*watchFile
+	tail     bool
+	offsets  map[fileID]int64
+	heads    map[fileID]fingerprint
+	archives map[string]checkpointEntry
+	draining []*watchFile
+	unread   []string
+	pending  pendingOffset
+	dirty    bool
+	ticker   *time.Ticker
+	tickchan <-chan time.Time
+	hostname string
+	buf      []byte
+	// openFiles is the number of open files reported to the open files metric
+	openFiles int
+	// status is a snapshot of the read positions of the files, updated after every read
+	status     []FileStatus
+	statusLock sync.Mutex
+	stopchan   chan struct{}
+	stopOnce   sync.Once
+	// flushing are the files whose parsers have to be flushed once the reader is stopped
+	flushing []*watchFile
+	stopped  bool
 }
 
-func NewReader() (*DirReader, error) {
+func NewReader(cfg *DirReaderConfig) (*DirReader, error) {
 	d := new(DirReader)
-	return d, d.Init()
+	return d, d.Init(cfg)
 }
 
-func (d *DirReader) Init() (err error) {
+func (d *DirReader) Init(cfg *DirReaderConfig) (err error) {
+	d.cfg = *cfg
+	if err = validateFormat(d.cfg.Format); err != nil {
+		return
+	}
+	if err = validateContainer(d.cfg.Container); err != nil {
+		return
+	}
+	if d.cfg.SourceHost {
+		if d.hostname, err = os.Hostname(); err != nil {
+			return
+		}
+	}
+	d.buf = make([]byte, readBufferSize)
+	d.stopchan = make(chan struct{})
 	d.files = make(map[string]*watchFile)
+	d.filters = make(map[string]*FileFilter)
+	d.tail = !d.cfg.FromBeginning
+	d.offsets = make(map[fileID]int64)
+	d.heads = make(map[fileID]fingerprint)
+	d.archives = make(map[string]checkpointEntry)
+	if d.cfg.Checkpoint != "" {
+		if d.cfg.CheckpointInterval <= 0 {
+			return fmt.Errorf("checkpoint interval must be positive")
+		}
+		if d.offsets, d.heads, err = loadCheckpoint(d.cfg.Checkpoint); err != nil {
+			return
+		}
+		d.ticker = time.NewTicker(d.cfg.CheckpointInterval)
+		d.tickchan = d.ticker.C
+	}
 	d.watcher, err = fsnotify.NewWatcher()
-	d.tail = true
 	return
 }
 
-func (d *DirReader) addFile(name string) (err error) {
+// seekStart positions the file at its stored read position or, if there is none,
+// at the beginning or end of the file depending on configuration
+func (d *DirReader) seekStart(w *watchFile, fromStart bool) (err error) {
+	if id, ok := getFileID(w.info); ok {
+		if offset, ok := d.offsets[id]; ok {
+			if offset > w.info.Size() || !d.heads[id].matches(w.fd) {
+				// file was truncated while logd was not running, or it is a new file that reuses the inode
+				offset = 0
+			} else {
+				w.head = d.heads[id]
+			}
+			if w.pos, err = w.fd.Seek(offset, io.SeekStart); err != nil {
+				return
+			}
+			w.offset = w.pos
+			return w.updateHead()
+		}
+	}
+	if d.tail && !fromStart {
+		// seek EOF
+		if w.pos, err = w.fd.Seek(0, io.SeekEnd); err != nil {
+			return
+		}
+		w.offset = w.pos
+		return w.updateHead()
+	}
+	return
+}
+
+// addFile opens the file and positions it at its stored read position. Files without a
+// stored read position are read from the beginning if fromStart is set.
+func (d *DirReader) addFile(name string, fromStart bool) (err error) {
 	if _, ok := d.files[name]; ok {
-		// panic(fmt.Errorf("tried to add file twice: %s", name))
 		return
 	}
 	var f *os.File
@@ -41,87 +198,423 @@ func (d *DirReader) addFile(name string) (err error) {
 	if err != nil {
 		return
 	}
-	w := &watchFile{}
+	w := &watchFile{name: name, parser: newFileParser(d.cfg.Format, d.cfg.Container, name), newline: true}
 	w.fd = f
 	if w.info, err = w.fd.Stat(); err != nil {
+		f.Close()
 		return
 	}
-	if d.tail {
-		// seek EOF
-		_, err = w.fd.Seek(0, 2)
+	if i := d.drainingIndex(w.info); i >= 0 {
+		// rotated file renamed within the watched directories is read from where it was left
+		rotated := d.draining[i]
+		d.draining = append(d.draining[:i], d.draining[i+1:]...)
+		rotated.name, rotated.rotated = name, time.Time{}
+		d.files[name] = rotated
+		return f.Close()
+	}
+	header := make([]byte, len(zstdMagic))
+	n, _ := w.fd.ReadAt(header, 0)
+	if compression := detectCompression(name, header[:n]); compression != "" {
+		return d.addArchive(w, compression, fromStart)
+	}
+	if err = d.seekStart(w, fromStart); err != nil {
+		f.Close()
+		return
 	}
 	d.files[name] = w
+	if w.pos < w.info.Size() {
+		// data written before the file was added is read without waiting for new writes
+		d.unread = append(d.unread, name)
+	}
 	return
 }
 
+// match returns true if the file at the given path matches the filter of the
+// watched directory that contains it
+func (d *DirReader) match(path string, info os.FileInfo) bool {
+	var root string
+	for dir := range d.filters {
+		if (path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))) && len(dir) > len(root) {
+			root = dir
+		}
+	}
+	if root == "" {
+		return true
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return d.filters[root].Match(rel, info)
+}
+
+// addArchive reads the compressed file once if it was found when the directory was first scanned and
+// FromBeginning is set. Archives created while watching the directory are ignored, as they are usually
+// rotated files which have already been read, and so are the archives that have been completely read before.
+func (d *DirReader) addArchive(w *watchFile, compression string, created bool) (err error) {
+	id, _ := getFileID(w.info)
+	offset, ok := d.offsets[id]
+	done := ok && offset == w.info.Size()
+	if done || created {
+		d.archives[w.name] = checkpointEntry{Path: w.name, Dev: id.dev, Inode: id.inode, Offset: w.info.Size()}
+		d.dirty = true
+	}
+	if created || done || !d.cfg.FromBeginning {
+		return w.fd.Close()
+	}
+	if w.reader, err = newDecompressor(w.fd, compression); err != nil {
+		w.fd.Close()
+		return
+	}
+	d.draining = append(d.draining, w)
+	return
+}
+
+// scanFiles adds all the regular files under dir that match its filter and watches all its sub-directories
 func (d *DirReader) scanFiles(dir string) error {
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		mode := info.Mode()
 		if mode.IsDir() && dir != path {
-			return d.scanFiles(path)
+			return d.watcher.Add(path)
 		}
-		if mode.IsRegular() {
-			err = d.addFile(path)
+		if mode.IsRegular() && d.match(path, info) {
+			err = d.addFile(path, false)
 		}
 		return err
 	})
-	if err != nil {
-		return err
+}
+
+// checkTruncated rewinds the file if it has been truncated under the current read offset,
+// or truncated and written to past it, which changes the data at the beginning of the file
+func (d *DirReader) checkTruncated(w *watchFile) (err error) {
+	var fi os.FileInfo
+	if fi, err = w.fd.Stat(); err != nil {
+		return
 	}
-	return nil
+	if fi.Size() < w.pos || !w.head.matches(w.fd) {
+		log.WithFields(log.Fields{
+			"tag":  "FileTruncated",
+			"path": w.fd.Name(),
+		}).Info()
+		if w.pos, err = w.fd.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		w.offset = 0
+		w.head = fingerprint{}
+		if d.pending.file == w {
+			d.pending = pendingOffset{}
+		}
+		d.dirty = true
+	}
+	return
 }
 
-func (d *DirReader) readFile(name string, buf []byte) (n int, err error) {
-	r := d.files[name].fd
-	if n, err = r.Read(buf); err == io.EOF {
+func (d *DirReader) read(w *watchFile, buf []byte) (n int, err error) {
+	if w.reader != nil {
+		// archives are only considered processed once they have been completely read
+		if n, err = w.reader.Read(buf); err == io.EOF {
+			err = nil
+		}
+		if n > 0 {
+			w.newline = buf[n-1] == '\n'
+		}
+		return
+	}
+	if n, err = w.fd.Read(buf); err == io.EOF {
 		err = nil
 	}
+	if n > 0 {
+		w.newline = buf[n-1] == '\n'
+	}
+	// only complete lines are considered processed
+	if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+		d.pending = pendingOffset{w, w.pos + int64(i) + 1}
+	}
+	w.pos += int64(n)
+	if err == nil {
+		err = w.updateHead()
+	}
 	return
 }
 
-func (d *DirReader) removeFile(name string) error {
-	if err := d.files[name].fd.Close(); err != nil {
-		return err
+func (d *DirReader) readFile(name string, buf []byte) (w *watchFile, n int, err error) {
+	w = d.files[name]
+	if w == nil {
+		// file was not seen before. i.e. rotated file created before it was watched
+		var fi os.FileInfo
+		if fi, err = os.Stat(name); err != nil {
+			if os.IsNotExist(err) {
+				// already removed
+				err = nil
+			}
+			return
+		}
+		if !fi.Mode().IsRegular() || !d.match(name, fi) {
+			return
+		}
+		if err = d.addFile(name, true); err != nil {
+			if os.IsNotExist(err) {
+				// already removed
+				err = nil
+			}
+			return
+		}
+		if w = d.files[name]; w == nil {
+			// archives are not tailed
+			return
+		}
 	}
-	d.files[name] = nil
-	return nil
+	if err = d.checkTruncated(w); err != nil {
+		return
+	}
+	n, err = d.read(w, buf)
+	return
 }
 
-func (d *DirReader) getFileInfo(name string) (fi os.FileInfo, err error) {
-	f := d.files[name]
-	if f == nil {
-		err = d.addFile(name)
-		if err != nil {
+// drainingIndex returns the index of the file in the rotated files being read, or -1
+func (d *DirReader) drainingIndex(info os.FileInfo) int {
+	for i, w := range d.draining {
+		if w.reader == nil && os.SameFile(w.info, info) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rotationDone returns whether the writers of the rotated file are done writing to it: either
+// the new file at its path has been written to or the grace period has elapsed
+func (d *DirReader) rotationDone(w *watchFile) bool {
+	if next := d.files[w.name]; next != nil && next.pos > 0 {
+		return true
+	}
+	return time.Since(w.rotated) >= rotateGracePeriod
+}
+
+// drainTick returns a channel that fires after rotatePollInterval while there are rotated files
+// being read, so that the data written to them is read even if it is not notified
+func (d *DirReader) drainTick() <-chan time.Time {
+	if len(d.draining) == 0 {
+		return nil
+	}
+	return time.After(rotatePollInterval)
+}
+
+// drain reads the remaining data of the files that have been rotated or removed. Rotated files
+// are kept open until their writers are done writing to them, see rotationDone, and closed
+// once they have been completely read. Archives are closed once they have been completely read.
+func (d *DirReader) drain(buf []byte) (w *watchFile, n int, err error) {
+	for i := 0; i < len(d.draining); {
+		w = d.draining[i]
+		if n, err = d.read(w, buf); n > 0 || err != nil {
 			return
 		}
-		f = d.files[name]
+		if w.reader == nil && !d.rotationDone(w) {
+			i++
+			continue
+		}
+		if !w.newline {
+			// last line of a rotated file is complete
+			w.newline = true
+			buf[0] = '\n'
+			return w, 1, nil
+		}
+		if id, ok := getFileID(w.info); ok {
+			if w.reader != nil {
+				w.reader.Close()
+				d.offsets[id] = w.info.Size()
+				d.archives[w.name] = checkpointEntry{Path: w.name, Dev: id.dev, Inode: id.inode, Offset: w.info.Size()}
+				d.dirty = true
+			} else {
+				// if rotated file is seen again it will be read from where it was left
+				d.offsets[id] = w.pos
+				d.heads[id] = w.head
+			}
+		}
+		w.fd.Close()
+		d.draining = append(d.draining[:i], d.draining[i+1:]...)
 	}
-	fi = f.info
-	return
+	return nil, 0, nil
+}
+
+// rotate stops watching the file at the given path, which has been renamed or removed.
+// Its remaining data is drained before reading any other file, and the data written to it
+// afterwards is read until the writers are done with it.
+func (d *DirReader) rotate(name string) {
+	if _, ok := d.archives[name]; ok {
+		delete(d.archives, name)
+		d.dirty = true
+	}
+	w := d.files[name]
+	if w == nil {
+		return
+	}
+	delete(d.files, name)
+	w.rotated = time.Now()
+	d.draining = append(d.draining, w)
+	d.dirty = true
 }
 
-func (d *DirReader) Read(buf []byte) (n int, err error) {
+// create watches the directory or adds the file created at the given path
+func (d *DirReader) create(name string) (err error) {
 	var fi os.FileInfo
-	for {
-		select {
-		case event := <-d.watcher.Events:
-			fi, err = d.getFileInfo(event.Name)
-			if err != nil {
-				return
+	if fi, err = os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			// already removed
+			err = nil
+		}
+		return
+	}
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		err = d.watcher.Add(name)
+		if err == nil {
+			err = d.scanFiles(name)
+		}
+	case mode.IsRegular() && d.match(name, fi):
+		// rotated files must be read from the beginning
+		err = d.addFile(name, true)
+	}
+	return
+}
+
+// commit marks the data returned by the previous Read as processed
+func (d *DirReader) commit() {
+	if d.pending.file != nil {
+		d.pending.file.offset = d.pending.offset
+		d.pending = pendingOffset{}
+		d.dirty = true
+	}
+}
+
+// checkpointEntry returns the processed read position of the file
+func (w *watchFile) checkpointEntry() (e checkpointEntry, ok bool) {
+	var id fileID
+	if id, ok = getFileID(w.info); ok {
+		e = checkpointEntry{
+			Path:     w.name,
+			Dev:      id.dev,
+			Inode:    id.inode,
+			Offset:   w.offset,
+			HeadSize: w.head.size,
+			HeadSum:  w.head.sum,
+		}
+	}
+	return
+}
+
+// saveCheckpoint stores the processed read positions of all the watched files and of the
+// rotated files that are still being read, so they are read from where they were left if
+// logd is restarted before they are done
+func (d *DirReader) saveCheckpoint() error {
+	if d.cfg.Checkpoint == "" || !d.dirty {
+		return nil
+	}
+	entries := make([]checkpointEntry, 0, len(d.files)+len(d.draining)+len(d.archives))
+	for _, w := range d.files {
+		if e, ok := w.checkpointEntry(); ok {
+			entries = append(entries, e)
+		}
+	}
+	for _, w := range d.draining {
+		// archives are only stored once they have been completely read
+		if w.reader != nil {
+			continue
+		}
+		if e, ok := w.checkpointEntry(); ok {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range d.archives {
+		entries = append(entries, e)
+	}
+	if err := saveCheckpoint(d.cfg.Checkpoint, entries); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+func (d *DirReader) stopping() bool {
+	select {
+	case <-d.stopchan:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush returns a newline for every file whose last line is incomplete once the reader
+// is stopped, so the data buffered by its parser is parsed. io.EOF is returned once
+// all the parsers have been flushed. Parsers are not flushed if read positions are stored,
+// since incomplete lines are read again from their beginning once logd is restarted.
+func (d *DirReader) flush(buf []byte) (w *watchFile, n int, err error) {
+	if !d.stopped {
+		d.stopped = true
+		if d.cfg.Checkpoint == "" {
+			for _, f := range d.files {
+				d.flushing = append(d.flushing, f)
 			}
+			d.flushing = append(d.flushing, d.draining...)
+		}
+	}
+	for len(d.flushing) > 0 {
+		w, d.flushing = d.flushing[0], d.flushing[1:]
+		if !w.newline {
+			w.newline = true
+			buf[0] = '\n'
+			return w, 1, nil
+		}
+	}
+	return nil, 0, io.EOF
+}
 
-			switch mode := fi.Mode(); {
-			case mode.IsRegular() && event.Op&fsnotify.Write == fsnotify.Write:
-				return d.readFile(event.Name, buf)
-			case event.Op&fsnotify.Remove == fsnotify.Remove:
-				if err = d.Remove(event.Name); err != nil {
+// next reads the data written to any of the watched files
+func (d *DirReader) next(buf []byte) (w *watchFile, n int, err error) {
+	for {
+		if d.stopping() {
+			return d.flush(buf)
+		}
+		if w, n, err = d.drain(buf); n > 0 || err != nil {
+			return
+		}
+		if len(d.unread) > 0 {
+			if d.files[d.unread[0]] != nil {
+				if w, n, err = d.readFile(d.unread[0], buf); n > 0 || err != nil {
 					return
 				}
+			}
+			d.unread = d.unread[1:]
+			continue
+		}
+		select {
+		case <-d.stopchan:
+		case <-d.drainTick():
+		case <-d.tickchan:
+			if err := d.saveCheckpoint(); err != nil {
+				log.WithFields(log.Fields{
+					"tag":   "CheckpointFailure",
+					"path":  d.cfg.Checkpoint,
+					"error": err,
+				}).Error()
+			}
+		case event := <-d.watcher.Events:
+			switch {
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				d.rotate(event.Name)
 			case event.Op&fsnotify.Create == fsnotify.Create:
-				if err = d.Watch(event.Name); err != nil {
+				if err = d.create(event.Name); err != nil {
+					return
+				}
+				if d.files[event.Name] != nil {
+					if w, n, err = d.readFile(event.Name, buf); n > 0 || err != nil {
+						return
+					}
+				}
+			case event.Op&fsnotify.Write == fsnotify.Write:
+				if w, n, err = d.readFile(event.Name, buf); n > 0 || err != nil {
 					return
 				}
 			}
@@ -131,30 +624,119 @@ func (d *DirReader) Read(buf []byte) (n int, err error) {
 	}
 }
 
+// annotate sets the source file properties
+func (d *DirReader) annotate(logs []logging.Log, w *watchFile) {
+	var inode string
+	if d.cfg.SourceInode {
+		if id, ok := getFileID(w.info); ok {
+			inode = strconv.FormatUint(id.inode, 10)
+		}
+	}
+	for i := range logs {
+		logs[i].Set(keyFile, w.name)
+		if d.hostname != "" {
+			logs[i].Set(logging.KeyHost, d.hostname)
+		}
+		if inode != "" {
+			logs[i].Set(keyInode, inode)
+		}
+	}
+}
+
+// ReadLogs parses the data written to any of the watched files. Every file is parsed
+// independently and logs are annotated with the path of the file they were read from.
+// Calling ReadLogs again signals that the logs previously read have been processed.
+func (d *DirReader) ReadLogs(logs []logging.Log) ([]logging.Log, error) {
+	d.commit()
+	w, n, err := d.next(d.buf)
+	if err != nil {
+		return logs, err
+	}
+	start := len(logs)
+	logs = w.parser.Parse(string(d.buf[:n]), logs)
+	d.annotate(logs[start:], w)
+	observeInput(inputLabelDir, n, len(logs)-start)
+	d.updateOpenFiles()
+	d.updateStatus()
+	return logs, nil
+}
+
+// updateOpenFiles updates the open files metric with the files opened and closed since the last update
+func (d *DirReader) updateOpenFiles() {
+	n := len(d.files) + len(d.draining)
+	metricOpenFiles.Add(float64(n - d.openFiles))
+	d.openFiles = n
+}
+
+// updateStatus takes a snapshot of the read positions of the files so they can be
+// read by Files without synchronizing with the reads
+func (d *DirReader) updateStatus() {
+	status := make([]FileStatus, 0, len(d.files)+len(d.draining))
+	for name, w := range d.files {
+		status = append(status, FileStatus{Path: name, Position: w.pos})
+	}
+	for _, w := range d.draining {
+		status = append(status, FileStatus{Path: w.name, Position: w.pos, Rotated: true})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Path < status[j].Path })
+	d.statusLock.Lock()
+	d.status = status
+	d.statusLock.Unlock()
+}
+
+// Files returns the read positions of the watched files as of the last read
+func (d *DirReader) Files() []FileStatus {
+	d.statusLock.Lock()
+	defer d.statusLock.Unlock()
+	return d.status
+}
+
+// Stop stops reading the watched files. ReadLogs returns the logs of the incomplete
+// lines of the files, unless read positions are stored, and io.EOF afterwards.
+// Stop can be called concurrently with ReadLogs.
+func (d *DirReader) Stop() {
+	d.stopOnce.Do(func() { close(d.stopchan) })
+}
+
 // Close will release all the resources held by this DirReader.
 // Init must be called again to use this instance again.
 func (d *DirReader) Close() error {
+	if d.ticker != nil {
+		d.ticker.Stop()
+	}
+	if err := d.saveCheckpoint(); err != nil {
+		return err
+	}
+	for _, f := range d.draining {
+		if f.reader != nil {
+			f.reader.Close()
+		}
+		f.fd.Close()
+	}
+	d.draining = nil
 	for _, f := range d.files {
 		if err := f.fd.Close(); err != nil {
 			return err
 		}
 	}
 	d.files = nil
+	d.updateOpenFiles()
+	d.updateStatus()
 	return d.watcher.Close()
 }
 
-// Watch adds directory to list of monitored directories
-func (d *DirReader) Watch(dir string) (err error) {
+// Watch adds directory to list of monitored directories. Only the files that match
+// the filter are read. If filter is nil, all the files are read.
+func (d *DirReader) Watch(dir string, filter *FileFilter) (err error) {
+	if filter == nil {
+		filter = &FileFilter{}
+	}
+	dir = filepath.Clean(dir)
+	d.filters[dir] = filter
 	if err = d.watcher.Add(dir); err != nil {
 		return
 	}
-	return d.scanFiles(dir)
-}
-
-func (d *DirReader) Remove(name string) error {
-	if err := d.removeFile(name); err != nil {
-		return err
-	}
-	// TODO recursively
-	return d.watcher.Remove(name)
+	err = d.scanFiles(dir)
+	d.updateStatus()
+	return
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func logLine(msg string) string {
+	return fmt.Sprintf("2017-04-19 18:01:11,437     INFO [main]    app	%s\n", msg)
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, f *os.File, msg string) {
+	if _, err := f.WriteString(logLine(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// readLogs reads logs until n logs are read or a second passes
+func readLogs(t *testing.T, r LogReader, n int) []logging.Log {
+	result := make(chan []logging.Log, 1)
+	go func() {
+		logs := make([]logging.Log, 0)
+		for len(logs) < n {
+			var err error
+			if logs, err = r.ReadLogs(logs); err != nil {
+				break
+			}
+		}
+		result <- logs
+	}()
+	select {
+	case logs := <-result:
+		return logs
+	case <-time.After(time.Second + rotatePollInterval):
+		t.Fatalf("timed out waiting for %d logs", n)
+		return nil
+	}
+}
+
+// readMessages reads logs until n messages are read or a second passes
+func readMessages(t *testing.T, r LogReader, n int) []string {
+	var messages []string
+	for _, lg := range readLogs(t, r, n) {
+		messages = append(messages, lg.Message)
+	}
+	return messages
+}
+
+func expectMessages(t *testing.T, r LogReader, expected ...string) {
+	messages := readMessages(t, r, len(expected))
+	if fmt.Sprint(messages) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v found %v", expected, messages)
+	}
+}
+
+func newTestDirReader(t *testing.T, dir string, cfg DirReaderConfig) *DirReader {
+	cfg.Format = formatNative
+	if cfg.CheckpointInterval == 0 {
+		cfg.CheckpointInterval = time.Hour
+	}
+	r, err := NewReader(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Watch(dir, nil); err != nil {
+		r.Close()
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDirReaderRotation(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "app.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	appendFile(t, f, "first")
+
+	r := newTestDirReader(t, dir, DirReaderConfig{FromBeginning: true})
+	defer r.Close()
+	expectMessages(t, r, "first")
+
+	// rotated out of the watched directory while the writer keeps writing to it
+	if err = os.Rename(path, filepath.Join(root, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, f, "late")
+	expectMessages(t, r, "late")
+
+	// writer reopens the file at its path
+	writeFile(t, path, logLine("new"))
+	expectMessages(t, r, "new")
+	appendFile(t, f, "after new file")
+	expectMessages(t, r, "after new file")
+
+	// rotated file is closed once it is completely read and the new file has been written to
+	writeFile(t, path, logLine("new")+logLine("newer"))
+	expectMessages(t, r, "newer")
+	if len(r.draining) != 0 {
+		t.Errorf("expected rotated file to be closed once the new file is written to")
+	}
+}
+
+func TestDirReaderRotationRename(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	appendFile(t, f, "first")
+
+	r := newTestDirReader(t, dir, DirReaderConfig{FromBeginning: true})
+	defer r.Close()
+	expectMessages(t, r, "first")
+
+	// rotated file renamed within the watched directory is read from where it was left
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	// events are processed in order, so the rename has been processed once other.log is read
+	writeFile(t, filepath.Join(dir, "other.log"), logLine("other"))
+	expectMessages(t, r, "other")
+	appendFile(t, f, "late")
+	expectMessages(t, r, "late")
+	if files := r.Files(); len(files) != 2 || files[0].Path != path+".1" || files[0].Rotated || files[0].Position != int64(len(logLine("first"))+len(logLine("late"))) {
+		t.Errorf("expected rotated file to be read as %s.1: found %v", path, files)
+	}
+}
+
+func TestDirReaderMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "old.log")
+	writeFile(t, path, logLine("old"))
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&DirReaderConfig{Format: formatNative, FromBeginning: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err = r.Watch(dir, &FileFilter{MaxAge: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	// files found by their events are filtered by their age too
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, f, "written")
+	f.Close()
+	if err = os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "new.log"), logLine("new"))
+	expectMessages(t, r, "new")
+}
+
+func TestDirReaderAnnotate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+	writeFile(t, first, "")
+	writeFile(t, second, "")
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestDirReader(t, dir, DirReaderConfig{SourceHost: true, SourceInode: true})
+	defer r.Close()
+
+	// partial lines of different files are parsed independently
+	line := logLine("first")
+	f, err := os.OpenFile(first, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(line[:len(line)/2]); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, second, logLine("second"))
+	expectMessages(t, r, "second")
+	if _, err = f.WriteString(line[len(line)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	logs := readLogs(t, r, 1)
+	if len(logs) != 1 || logs[0].Message != "first" {
+		t.Fatalf("expected the line of %s to be complete: found %v", first, logs)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := getFileID(info)
+	for key, expected := range map[string]string{
+		keyFile:         first,
+		keyInode:        strconv.FormatUint(id.inode, 10),
+		logging.KeyHost: hostname,
+	} {
+		if value, _ := logs[0].Get(key); value != expected {
+			t.Errorf("expected %s to be %s found %s", key, expected, value)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Value is a key defined in a config file. Key is the dotted path of the key
+// including its table, i.e. "sandbox.http.timeout", and Value is a string, int64,
+// float64, bool or a []interface{} of them. Path are the segments of the path, which
+// can contain dots if quoted, i.e. [pipelines."app.logs"] keys start with "pipelines"
+// and "app.logs". Line is the line where the key, or the inline table that holds it, is defined.
+type Value struct {
+	Key   string
+	Path  []string
+	Value interface{}
+	Line  int
+}
+
+// Error is returned when a config file cannot be parsed or one of its values is not valid.
+// It points at the line and, when known, at the key that caused it.
+type Error struct {
+	File string
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (e *Error) Error() (s string) {
+	if e.File != "" {
+		s = e.File + ":"
+	}
+	if e.Line != 0 {
+		s += strconv.Itoa(e.Line) + ":"
+	}
+	if s != "" {
+		s += " "
+	}
+	if e.Key != "" {
+		s += e.Key + ": "
+	}
+	return s + e.Msg
+}
+
+// ValueError returns an Error pointing at the key that defined v
+func ValueError(file string, v Value, format string, args ...interface{}) *Error {
+	return &Error{File: file, Line: v.Line, Key: v.Key, Msg: fmt.Sprintf(format, args...)}
+}
+
+// lookupKey returns the value of the key in the decoded document. Keys of the inline tables
+// of an array cannot be looked up, so the array that holds them is returned with its path instead.
+func lookupKey(doc map[string]interface{}, key toml.Key) (v interface{}, path toml.Key) {
+	v = doc
+	for i, k := range key {
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			return v, key[:i]
+		}
+		v = table[k]
+	}
+	return v, key
+}
+
+// checkValue returns an error if v is not a string, int64, float64, bool or an array of them
+func checkValue(v interface{}, array bool) error {
+	switch v := v.(type) {
+	case string, int64, float64, bool:
+		return nil
+	case []interface{}:
+		if array {
+			return fmt.Errorf("nested arrays are not supported")
+		}
+		for _, e := range v {
+			if err := checkValue(e, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []map[string]interface{}, map[string]interface{}:
+		return fmt.Errorf("arrays of tables are not supported")
+	case time.Time:
+		return fmt.Errorf("dates and times are not supported")
+	default:
+		return fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// parseErrorPrefix starts the errors returned by the toml package, i.e.
+// "Near line 3 (last key parsed 'name'): expected value but found ..."
+const parseErrorPrefix = "Near line "
+
+// parseError converts an error of the toml package into an Error pointing at its line
+func parseError(err error) *Error {
+	msg := err.Error()
+	if !strings.HasPrefix(msg, parseErrorPrefix) {
+		return &Error{Msg: msg}
+	}
+	e := &Error{Msg: msg[len(parseErrorPrefix):]}
+	if i := strings.IndexByte(e.Msg, ' '); i > 0 {
+		e.Line, _ = strconv.Atoi(e.Msg[:i])
+	}
+	if i := strings.Index(e.Msg, "): "); i >= 0 {
+		e.Msg = e.Msg[i+len("): "):]
+	}
+	return e
+}
+
+// Parse parses the TOML document and returns its values in the order they are defined.
+// Tables are flattened, so a key k in table [a.b] is returned as "a.b.k".
+func Parse(data []byte) (values []Value, err error) {
+	var doc map[string]interface{}
+	var md toml.MetaData
+	if md, err = toml.Decode(string(data), &doc); err != nil {
+		return nil, parseError(err)
+	}
+	lines := keyLines(string(data))
+	for _, key := range md.Keys() {
+		if md.Type(key...) == "Hash" {
+			continue
+		}
+		value, path := lookupKey(doc, key)
+		v := Value{Key: strings.Join(path, "."), Path: path, Value: value, Line: lines.line(path)}
+		if err = checkValue(v.Value, false); err != nil {
+			return nil, &Error{Line: v.Line, Key: v.Key, Msg: err.Error()}
+		}
+		values = append(values, v)
+	}
+	return
+}
+
+// expandString replaces ${NAME} with the value of the environment variable NAME
+// as returned by lookup. $$ is replaced with $.
+func expandString(s string, lookup func(string) (string, bool)) (string, error) {
+	if strings.IndexByte(s, '$') < 0 {
+		return s, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated environment variable reference")
+			}
+			name := s[i+2 : i+end]
+			if name == "" {
+				return "", fmt.Errorf("empty environment variable reference")
+			}
+			value, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable '%s' is not set", name)
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func expandValue(v interface{}, lookup func(string) (string, bool)) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandString(v, lookup)
+	case []interface{}:
+		for i, e := range v {
+			var err error
+			if v[i], err = expandValue(e, lookup); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// Expand replaces the references to environment variables in the string values,
+// so that secrets do not need to be written in config files. References are
+// written as ${NAME} and are looked up with lookup. An error is returned if
+// a variable is not set.
+func Expand(values []Value, lookup func(string) (string, bool)) (err error) {
+	for i := range values {
+		if values[i].Value, err = expandValue(values[i].Value, lookup); err != nil {
+			return &Error{Line: values[i].Line, Key: values[i].Key, Msg: err.Error()}
+		}
+	}
+	return
+}
+
+// Load reads and parses the config file and expands the environment variables referenced by it
+func Load(path string) (values []Value, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	if values, err = Parse(data); err == nil {
+		err = Expand(values, os.LookupEnv)
+	}
+	if e, ok := err.(*Error); ok {
+		e.File = path
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# logd config
+script = "pipeline.lua"
+
+[input]
+files = [
+	"/var/log/app.log", # the application
+	'/var/log/C:\other.log',
+]
+follow = true
+http_queue = 1_024
+
+[sandbox]
+tick = 500
+ratio = 0.5
+"http.timeout" = "5s"
+description = """
+multi-line "string"
+"""
+
+[sandbox.kafka]
+"bootstrap.servers" = "localhost:9092"
+"sasl.password" = "${KAFKA_PASSWORD}"
+`
+
+func testEnv(name string) (string, bool) {
+	if name == "KAFKA_PASSWORD" {
+		return "s3cr3t", true
+	}
+	return "", false
+}
+
+func TestParse(t *testing.T) {
+	values, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Expand(values, testEnv); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Value{
+		{Key: "script", Path: []string{"script"}, Value: "pipeline.lua", Line: 2},
+		{Key: "input.files", Path: []string{"input", "files"}, Value: []interface{}{"/var/log/app.log", `/var/log/C:\other.log`}, Line: 5},
+		{Key: "input.follow", Path: []string{"input", "follow"}, Value: true, Line: 9},
+		{Key: "input.http_queue", Path: []string{"input", "http_queue"}, Value: int64(1024), Line: 10},
+		{Key: "sandbox.tick", Path: []string{"sandbox", "tick"}, Value: int64(500), Line: 13},
+		{Key: "sandbox.ratio", Path: []string{"sandbox", "ratio"}, Value: 0.5, Line: 14},
+		{Key: "sandbox.http.timeout", Path: []string{"sandbox", "http.timeout"}, Value: "5s", Line: 15},
+		{Key: "sandbox.description", Path: []string{"sandbox", "description"}, Value: "multi-line \"string\"\n", Line: 16},
+		{Key: "sandbox.kafka.bootstrap.servers", Path: []string{"sandbox", "kafka", "bootstrap.servers"}, Value: "localhost:9092", Line: 21},
+		{Key: "sandbox.kafka.sasl.password", Path: []string{"sandbox", "kafka", "sasl.password"}, Value: "s3cr3t", Line: 22},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v found %v", expected, values)
+	}
+}
+
+func TestParseStrings(t *testing.T) {
+	values, err := Parse([]byte(`a = "tab\there \"quoted\" \u00e9 # not a comment"` + "\nb = 'no \\escapes'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := values[0].Value; s != "tab\there \"quoted\" é # not a comment" {
+		t.Errorf("unexpected basic string %q", s)
+	}
+	if s := values[1].Value; s != `no \escapes` {
+		t.Errorf("unexpected literal string %q", s)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"a = 1\na = 2", "2: Key 'a' has already been defined."},
+		{"\n\nname = logd", "3: expected value but found \"logd\" instead"},
+		{"a = [[1], [2]]", "1: a: nested arrays are not supported"},
+		{"a = 1\n\n[[b]]\nc = 1", "3: b: arrays of tables are not supported"},
+		{"a = [{b = 1}]", "1: a: arrays of tables are not supported"},
+		{"a = \"\"\"\n\"\"\"\nb = 1979-05-27T07:32:00Z", "3: b: dates and times are not supported"},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.config))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: expected error '%s' found '%v'", test.config, test.err, err)
+		}
+	}
+}
+
+func TestParseInlineTables(t *testing.T) {
+	values, err := Parse([]byte(`sandbox = {tick = 500, kafka = {"bootstrap.servers" = "localhost:9092"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Value{
+		{Key: "sandbox.tick", Path: []string{"sandbox", "tick"}, Value: int64(500), Line: 1},
+		{Key: "sandbox.kafka.bootstrap.servers", Path: []string{"sandbox", "kafka", "bootstrap.servers"}, Value: "localhost:9092", Line: 1},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v found %v", expected, values)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	values := []Value{
+		{Key: "a", Value: "user:${KAFKA_PASSWORD}@host $$HOME $", Line: 1},
+		{Key: "b", Value: []interface{}{"${KAFKA_PASSWORD}", int64(1)}, Line: 2},
+	}
+	if err := Expand(values, testEnv); err != nil {
+		t.Fatal(err)
+	}
+	if s := values[0].Value; s != "user:s3cr3t@host $HOME $" {
+		t.Errorf("unexpected expanded string %q", s)
+	}
+	if a := values[1].Value; !reflect.DeepEqual(a, []interface{}{"s3cr3t", int64(1)}) {
+		t.Errorf("unexpected expanded array %v", a)
+	}
+
+	err := Expand([]Value{{Key: "kafka.password", Value: "${MISSING}", Line: 7}}, testEnv)
+	if err == nil || !strings.Contains(err.Error(), "7: kafka.password: environment variable 'MISSING' is not set") {
+		t.Errorf("expected error for unset variable found %v", err)
+	}
+	err = Expand([]Value{{Key: "a", Value: "${OPEN", Line: 1}}, testEnv)
+	if err == nil {
+		t.Errorf("expected error for unterminated reference")
+	}
+}
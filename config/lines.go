@@ -0,0 +1,174 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySep joins the segments of a key path so quoted segments with dots do not collide
+const keySep = "\x00"
+
+// lineIndex are the lines where the table headers and the keys of a document are defined,
+// indexed by the segments of their path joined with keySep
+type lineIndex map[string]int
+
+// line returns the line where the key is defined. Keys of inline tables are not
+// indexed, so the line of the closest table or key that holds it is returned.
+func (lines lineIndex) line(key []string) int {
+	for n := len(key); n > 0; n-- {
+		if line, ok := lines[strings.Join(key[:n], keySep)]; ok {
+			return line
+		}
+	}
+	return 0
+}
+
+// lineScanner finds the lines of the keys of a TOML document. The document must
+// have been parsed successfully already, so syntax errors are not reported.
+type lineScanner struct {
+	data string
+	pos  int
+	line int
+}
+
+// keyLines returns the lines where the table headers and the keys of the document are defined
+func keyLines(data string) lineIndex {
+	lines := make(lineIndex)
+	s := lineScanner{data: data, line: 1}
+	var table []string
+	for s.skipSpace(true); s.pos < len(s.data); s.skipSpace(true) {
+		line := s.line
+		var path []string
+		switch s.data[s.pos] {
+		case '#':
+			s.skipComment()
+			continue
+		case '[':
+			// tables and arrays of tables, [a.b] or [[a.b]]
+			for s.pos < len(s.data) && s.data[s.pos] == '[' {
+				s.pos++
+			}
+			table = s.key()
+			path = table
+			s.skipComment()
+		default:
+			path = append(append([]string{}, table...), s.key()...)
+			s.skipValue()
+		}
+		// keys of arrays of tables are defined again by every element
+		if k := strings.Join(path, keySep); lines[k] == 0 {
+			lines[k] = line
+		}
+	}
+	return lines
+}
+
+func (s *lineScanner) skipSpace(newlines bool) {
+	for ; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r':
+		case '\n':
+			if !newlines {
+				return
+			}
+			s.line++
+		default:
+			return
+		}
+	}
+}
+
+// skipComment skips the rest of the line, but not the newline
+func (s *lineScanner) skipComment() {
+	if i := strings.IndexByte(s.data[s.pos:], '\n'); i >= 0 {
+		s.pos += i
+	} else {
+		s.pos = len(s.data)
+	}
+}
+
+// key returns the segments of a dotted key: bare, "basic" or 'literal' keys separated by dots
+func (s *lineScanner) key() (path []string) {
+	for {
+		s.skipSpace(false)
+		if s.pos == len(s.data) {
+			return
+		}
+		start := s.pos
+		switch s.data[s.pos] {
+		case '"':
+			s.skipString('"', false)
+			segment, err := strconv.Unquote(s.data[start:s.pos])
+			if err != nil {
+				segment = s.data[start+1 : s.pos-1]
+			}
+			path = append(path, segment)
+		case '\'':
+			s.skipString('\'', false)
+			path = append(path, s.data[start+1:s.pos-1])
+		default:
+			for s.pos < len(s.data) && isBareKeyChar(s.data[s.pos]) {
+				s.pos++
+			}
+			path = append(path, s.data[start:s.pos])
+		}
+		s.skipSpace(false)
+		if s.pos == len(s.data) || s.data[s.pos] != '.' {
+			return
+		}
+		s.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// skipString skips the string that starts at the current position. Basic strings
+// are delimited by double quotes, which can be escaped, and literal strings by single quotes.
+func (s *lineScanner) skipString(quote byte, multiline bool) {
+	end := string(quote)
+	if multiline {
+		end = strings.Repeat(end, 3)
+	}
+	for s.pos += len(end); s.pos < len(s.data); s.pos++ {
+		switch c := s.data[s.pos]; {
+		case c == '\\' && quote == '"':
+			if s.pos+1 < len(s.data) && s.data[s.pos+1] == '\n' {
+				s.line++
+			}
+			s.pos++
+		case c == '\n':
+			s.line++
+		case strings.HasPrefix(s.data[s.pos:], end):
+			s.pos += len(end)
+			return
+		}
+	}
+}
+
+// skipValue skips the value of a key up to the newline that ends it.
+// Arrays and multi-line strings can span several lines.
+func (s *lineScanner) skipValue() {
+	depth := 0
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; c {
+		case '"', '\'':
+			s.skipString(c, strings.HasPrefix(s.data[s.pos:], strings.Repeat(string(c), 3)))
+			continue
+		case '#':
+			s.skipComment()
+			continue
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '\n':
+			if depth <= 0 {
+				return
+			}
+			s.line++
+		}
+		s.pos++
+	}
+}
@@ -0,0 +1,42 @@
+--
+-- This example drops duplicated messages and keeps its cache and counters when the script is reloaded with SIGUSR1
+-- This can be suplied to the logd executable: logd -R examples/dedup.lua -f /var/log/mylog.log
+--
+local logd = require("logd")
+
+local seen = {}
+local dropped = 0
+
+function logd.on_tick ()
+	logd.debug({ msg = "dedup stats", dropped = tostring(dropped) })
+end
+
+function logd.on_start ()
+	logd.debug({ msg = "dedup started" })
+end
+
+-- the table returned is supplied to logd.on_reload after the script is loaded again
+function logd.on_shutdown ()
+	return { seen = seen, dropped = dropped }
+end
+
+function logd.on_reload (state)
+	seen = state.seen or {}
+	dropped = state.dropped or 0
+	logd.debug({ msg = "dedup reloaded", dropped = tostring(dropped) })
+end
+
+function logd.on_log (logptr)
+	local msg = logd.log_get(logptr, "msg")
+	if msg == nil then
+		return
+	end
+	if seen[msg] then
+		dropped = dropped + 1
+		return
+	end
+	seen[msg] = true
+	print(logd.log_string(logptr))
+end
+
+logd.config_set("tick", 10000)
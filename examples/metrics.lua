@@ -0,0 +1,29 @@
+--
+-- This example derives metrics from the logs, which can be scraped by Prometheus at http://localhost:9100/metrics
+-- This can be suplied to the logd executable: logd -R examples/metrics.lua -metrics localhost:9100 -f /var/log/mylog.log
+--
+local logd = require("logd")
+
+function logd.on_tick ()
+end
+
+function logd.on_log (logptr)
+	local level = logd.log_get(logptr, "level")
+	local class = logd.log_get(logptr, "class")
+
+	logd.counter_add("app_logs_total", 1, { level = level })
+
+	if level == "ERROR" then
+		logd.counter_add("app_errors_total", 1, { class = class })
+	end
+
+	-- access logs with a 'duration' property in milliseconds
+	local duration = tonumber(logd.log_get(logptr, "duration"))
+	if duration ~= nil then
+		local labels = { status = logd.log_get(logptr, "status") }
+		logd.histogram_observe("app_request_duration_seconds", duration / 1000, labels, { 0.01, 0.05, 0.1, 0.5, 1, 5 })
+		logd.gauge_set("app_last_request_duration_seconds", duration / 1000)
+	end
+end
+
+logd.config_set("tick", 1000)
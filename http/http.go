@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ernestrc/logd/metrics"
 	uuid "github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
@@ -30,19 +31,35 @@ type Config struct {
 	Concurrency int
 	ChanBuffer  int
 	Timeout     time.Duration
+	// Retries is the number of times a failed request is retried before
+	// it is reported as an Error. Each retry waits twice as long as the previous one,
+	// starting with RetryBackoff.
+	Retries      int
+	RetryBackoff time.Duration
 }
 
 type request struct {
 	*http.Request
 	time.Time
-	ctx        *log.Entry
-	cancelFunc context.CancelFunc // not used for now
+	ctx     *log.Entry
+	payload string
+	timeout time.Duration
 }
 
+var (
+	metricRequests = metrics.Default.NewCounterVec("logd_http_requests_total",
+		"HTTP posts made by the async HTTP client", "host")
+	metricFailures = metrics.Default.NewCounterVec("logd_http_request_failures_total",
+		"HTTP posts that failed after all the retries", "host")
+	metricDuration = metrics.Default.NewHistogramVec("logd_http_request_duration_seconds",
+		"Time from the submission of the HTTP posts until they complete, including retries", metrics.DefaultBuckets, "host")
+)
+
 const defaultTimeout = 5 * 1000 * 1000 * 1000 // 5s in ns
+const defaultRetryBackoff = 100 * 1000 * 1000 // 100ms in ns
 
 // DefaultConfig is a client config with sane defaults
-var DefaultConfig = Config{Concurrency: 4, ChanBuffer: 100, Timeout: defaultTimeout}
+var DefaultConfig = Config{Concurrency: 4, ChanBuffer: 100, Timeout: defaultTimeout, RetryBackoff: defaultRetryBackoff}
 
 func validateConfiguration(cfg *Config) (err error) {
 	if cfg == nil {
@@ -56,6 +73,10 @@ func validateConfiguration(cfg *Config) (err error) {
 		err = fmt.Errorf("config error: min http channel buffer is 1")
 		return
 	}
+	if cfg.Retries < 0 {
+		err = fmt.Errorf("config error: min http retries is 0")
+		return
+	}
 
 	return
 }
@@ -67,6 +88,12 @@ type Error struct {
 }
 
 func postRequest(client *http.Client, req *request) error {
+	// add HTTP timeout; body is rewound so request can be retried
+	ctx, cancelFunc := context.WithTimeout(context.Background(), req.timeout)
+	defer cancelFunc()
+	req.Request = req.Request.WithContext(ctx)
+	req.Body = ioutil.NopCloser(strings.NewReader(req.payload))
+
 	res, err := client.Do(req.Request)
 	if err != nil {
 		return err
@@ -81,7 +108,22 @@ func postRequest(client *http.Client, req *request) error {
 	return fmt.Errorf("request to '%s' status: %+v", req.URL.String(), res.Status)
 }
 
-func poster(id int, reqchan chan *request, errorchan chan<- Error, quitchan chan struct{}) {
+func postRequestRetry(client *http.Client, req *request, retries int, backoff time.Duration) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = postRequest(client, req); err == nil || attempt >= retries {
+			return
+		}
+		req.ctx.WithFields(log.Fields{
+			"tag":     "HttpPostRetry",
+			"error":   err,
+			"attempt": attempt + 1,
+		}).Debug()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func poster(id int, cfg Config, reqchan chan *request, errorchan chan<- Error, quitchan chan struct{}) {
 	var client http.Client
 	log.WithFields(log.Fields{
 		"tag":      "HttpWorkerStart",
@@ -92,9 +134,13 @@ func poster(id int, reqchan chan *request, errorchan chan<- Error, quitchan chan
 			"tag":      "HttpPostAttempt",
 			"workerId": id,
 		}).Debug()
-		err := postRequest(&client, req)
+		err := postRequestRetry(&client, req, cfg.Retries, cfg.RetryBackoff)
 		duration := time.Now().UnixNano() - req.Time.UnixNano()
+		host := req.URL.Host
+		metricRequests.WithLabelValues(host).Inc()
+		metricDuration.WithLabelValues(host).Observe(float64(duration) / float64(time.Second))
 		if err != nil {
+			metricFailures.WithLabelValues(host).Inc()
 			if errorchan != nil {
 				errorchan <- Error{req, err}
 			}
@@ -137,7 +183,7 @@ func (a *AsyncClient) initWorkers() {
 	a.reqchan = make([]chan *request, a.cfg.Concurrency)
 	for i := 0; i < a.cfg.Concurrency; i++ {
 		a.reqchan[i] = make(chan *request, a.cfg.ChanBuffer)
-		go poster(i, a.reqchan[i], a.errorchan, a.quitchan)
+		go poster(i, a.cfg, a.reqchan[i], a.errorchan, a.quitchan)
 	}
 }
 
@@ -166,28 +212,34 @@ func (a *AsyncClient) Init(cfg *Config, errorchan chan<- Error) (err error) {
 	return
 }
 
-func (a *AsyncClient) newPostRequest(logEntry *log.Entry, url, contentType, payload string) (*request, error) {
+func (a *AsyncClient) newPostRequest(logEntry *log.Entry, url, payload string, header http.Header) (*request, error) {
 	time := time.Now()
-	httpReq, err := http.NewRequest("POST", url, strings.NewReader(payload))
+	httpReq, err := http.NewRequest("POST", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	httpReq.Header.Add("Content-Type", contentType)
-
-	// add HTTP timeout
-	httpCtx, cancelFunc := context.WithTimeout(context.Background(), a.cfg.Timeout)
-	httpReq = httpReq.WithContext(httpCtx)
+	for k, v := range header {
+		httpReq.Header[k] = v
+	}
 
 	return &request{
 		httpReq,
 		time,
 		logEntry,
-		cancelFunc,
+		payload,
+		a.cfg.Timeout,
 	}, nil
 }
 
 // Post makes an HTTP post to the given url and sets the Content-Type header accordingly
 func (a *AsyncClient) Post(url string, payload string, contentType string, affinity int) (err error) {
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+	return a.PostWithHeader(url, payload, header, affinity)
+}
+
+// PostWithHeader makes an HTTP post to the given url with the given request headers
+func (a *AsyncClient) PostWithHeader(url string, payload string, header http.Header, affinity int) (err error) {
 	maxAffinity := a.cfg.Concurrency - 1
 	if affinity > maxAffinity {
 		err = fmt.Errorf("Post: cannot pass affinity greater than %d (only %d channels available)", maxAffinity, a.cfg.Concurrency)
@@ -206,7 +258,7 @@ func (a *AsyncClient) Post(url string, payload string, contentType string, affin
 	ctx.Debug()
 
 	var req *request
-	if req, err = a.newPostRequest(ctx, url, contentType, payload); err != nil {
+	if req, err = a.newPostRequest(ctx, url, payload, header); err != nil {
 		return
 	}
 
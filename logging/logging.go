@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 )
 
 const (
@@ -31,6 +32,16 @@ type Property struct {
 	value string
 }
 
+// Key returns the property key
+func (p Property) Key() string {
+	return p.key
+}
+
+// Value returns the property value
+func (p Property) Value() string {
+	return p.value
+}
+
 // Log represents a structured log
 type Log struct {
 	/* header */
@@ -62,6 +73,33 @@ func (l *Log) Timestamp() string {
 	return fmt.Sprintf("%s %s", l.date, l.time)
 }
 
+// timeLayout is the layout of the log header timestamp once the fractional
+// seconds separator has been normalized to '.'
+const timeLayout = "2006-01-02 15:04:05.999999999"
+
+// layouts used by SetTime
+const (
+	dateLayout  = "2006-01-02"
+	clockLayout = "15:04:05.999999"
+)
+
+// Time parses the log timestamp in the local timezone.
+// It returns an error if the log has no timestamp or if it is not in the expected format.
+func (l *Log) Time() (time.Time, error) {
+	if l.date == "" || l.time == "" {
+		return time.Time{}, fmt.Errorf("log has no timestamp")
+	}
+	ts := l.date + " " + strings.Replace(l.time, ",", ".", 1)
+	return time.ParseInLocation(timeLayout, ts, time.Local)
+}
+
+// SetTime sets the log timestamp to the given time in the local timezone
+func (l *Log) SetTime(t time.Time) {
+	t = t.Local()
+	l.date = t.Format(dateLayout)
+	l.time = t.Format(clockLayout)
+}
+
 // Remove will remove the passed key from the log properties.
 // It returns true if a property with the given key was found and removed.
 func (l *Log) Remove(key string) (found bool) {
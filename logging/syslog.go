@@ -0,0 +1,213 @@
+package logging
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// properties set by ParseSyslog
+const (
+	KeyFacility = "facility"
+	KeySeverity = "severity"
+	KeyHost     = "host"
+	KeyApp      = "app"
+	KeyProcID   = "procid"
+	KeyMsgID    = "msgid"
+)
+
+const (
+	syslogNil        = "-"
+	syslogBOM        = "\xef\xbb\xbf"
+	rfc3164Layout    = "Jan _2 15:04:05"
+	rfc3164LayoutLen = len(rfc3164Layout)
+)
+
+var syslogFacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogSeverityLevels = []string{"EMERG", "ALERT", "CRIT", Error, Warn, "NOTICE", Info, Debug}
+
+func (l *Log) setSyslogProp(key, value string) {
+	if value != "" && value != syslogNil {
+		l.Set(key, value)
+	}
+}
+
+// parsePRI parses the "<PRI>" part of the message and returns the remainder
+func parsePRI(msg string) (facility, severity int, rest string, err error) {
+	end := strings.IndexByte(msg, '>')
+	if len(msg) < 3 || msg[0] != '<' || end < 2 || end > 4 {
+		err = fmt.Errorf("invalid syslog priority")
+		return
+	}
+	var pri int
+	if pri, err = strconv.Atoi(msg[1:end]); err != nil || pri < 0 || pri > 191 {
+		err = fmt.Errorf("invalid syslog priority '%s'", msg[1:end])
+		return
+	}
+	facility, severity, rest = pri/8, pri%8, msg[end+1:]
+	return
+}
+
+// nextField returns the next space delimited field and the remainder
+func nextField(msg string) (field, rest string) {
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		return msg[:i], msg[i+1:]
+	}
+	return msg, ""
+}
+
+// parseStructuredData parses the RFC 5424 structured data elements into properties
+// and returns the remainder of the message
+func (l *Log) parseStructuredData(msg string) (rest string, err error) {
+	if strings.HasPrefix(msg, syslogNil) {
+		return msg[1:], nil
+	}
+	for len(msg) > 0 && msg[0] == '[' {
+		i := 1
+		// skip SD-ID
+		for i < len(msg) && msg[i] != ' ' && msg[i] != ']' {
+			i++
+		}
+		for i < len(msg) && msg[i] == ' ' {
+			// SD-PARAM: name="value"
+			eq := strings.IndexByte(msg[i:], '=')
+			if eq < 0 || i+eq+1 >= len(msg) || msg[i+eq+1] != '"' {
+				return "", fmt.Errorf("invalid syslog structured data")
+			}
+			name := msg[i+1 : i+eq]
+			i += eq + 2
+			var value []byte
+			for ; i < len(msg) && msg[i] != '"'; i++ {
+				if msg[i] == '\\' && i+1 < len(msg) {
+					switch msg[i+1] {
+					case '"', '\\', ']':
+						i++
+					}
+				}
+				value = append(value, msg[i])
+			}
+			if i >= len(msg) {
+				return "", fmt.Errorf("unterminated syslog structured data param value")
+			}
+			l.Set(name, string(value))
+			i++
+		}
+		if i >= len(msg) || msg[i] != ']' {
+			return "", fmt.Errorf("unterminated syslog structured data element")
+		}
+		msg = msg[i+1:]
+	}
+	return msg, nil
+}
+
+func (l *Log) parseRFC5424(msg string) (err error) {
+	var ts, host, app, procid, msgid string
+	ts, msg = nextField(msg)
+	host, msg = nextField(msg)
+	app, msg = nextField(msg)
+	procid, msg = nextField(msg)
+	msgid, msg = nextField(msg)
+
+	if ts == syslogNil {
+		l.SetTime(time.Now())
+	} else {
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return fmt.Errorf("invalid syslog timestamp '%s'", ts)
+		}
+		l.SetTime(t)
+	}
+	l.setSyslogProp(KeyHost, host)
+	l.setSyslogProp(KeyApp, app)
+	l.setSyslogProp(KeyProcID, procid)
+	l.setSyslogProp(KeyMsgID, msgid)
+
+	if msg, err = l.parseStructuredData(msg); err != nil {
+		return
+	}
+	l.Message = strings.TrimPrefix(strings.TrimPrefix(msg, " "), syslogBOM)
+	return
+}
+
+// parseRFC3164Time parses the timestamp, which has no year, as the closest
+// past time in the local time zone
+func parseRFC3164Time(ts string, now time.Time) (t time.Time, err error) {
+	if t, err = time.ParseInLocation(rfc3164Layout, ts, time.Local); err != nil {
+		return
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 0, 1)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return
+}
+
+func (l *Log) parseRFC3164(msg string) {
+	now := time.Now()
+	if len(msg) < rfc3164LayoutLen+1 || msg[rfc3164LayoutLen] != ' ' {
+		l.SetTime(now)
+		l.Message = msg
+		return
+	}
+	t, err := parseRFC3164Time(msg[:rfc3164LayoutLen], now)
+	if err != nil {
+		// message without timestamp
+		l.SetTime(now)
+		l.Message = msg
+		return
+	}
+	l.SetTime(t)
+	msg = msg[rfc3164LayoutLen+1:]
+
+	// hostname is omitted by some senders so first field is the tag
+	if field, rest := nextField(msg); !strings.HasSuffix(field, ":") && !strings.ContainsRune(field, '[') {
+		l.setSyslogProp(KeyHost, field)
+		msg = rest
+	}
+
+	tagEnd := strings.IndexAny(msg, ":[ ")
+	if tagEnd <= 0 || msg[tagEnd] == ' ' {
+		l.Message = msg
+		return
+	}
+	l.setSyslogProp(KeyApp, msg[:tagEnd])
+	msg = msg[tagEnd:]
+	if msg[0] == '[' {
+		if end := strings.IndexByte(msg, ']'); end > 0 {
+			l.setSyslogProp(KeyProcID, msg[1:end])
+			msg = msg[end+1:]
+		}
+	}
+	l.Message = strings.TrimPrefix(strings.TrimPrefix(msg, ":"), " ")
+}
+
+// ParseSyslog parses a RFC 5424 or RFC 3164 syslog message. Severity is mapped to the log level,
+// and facility, severity, hostname, app name, process id and message id are set as properties.
+// RFC 5424 structured data params are also set as properties.
+func ParseSyslog(msg string) (l Log, err error) {
+	l = Log{props: make([]Property, 0)}
+	msg = strings.TrimRight(msg, "\r\n")
+
+	var facility, severity int
+	if facility, severity, msg, err = parsePRI(msg); err != nil {
+		return
+	}
+	l.Level = syslogSeverityLevels[severity]
+	l.Set(KeyFacility, syslogFacilityNames[facility])
+	l.Set(KeySeverity, syslogSeverityNames[severity])
+
+	if strings.HasPrefix(msg, "1 ") {
+		err = l.parseRFC5424(msg[2:])
+	} else {
+		l.parseRFC3164(msg)
+	}
+	return
+}
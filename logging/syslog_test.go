@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func testSyslogProps(t *testing.T, log *Log, props map[string]string) {
+	for k, v := range props {
+		testGetProp(t, log, k, v)
+	}
+}
+
+func TestParseSyslogRFC5424(t *testing.T) {
+	log, err := ParseSyslog(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"] ` + syslogBOM + "An application event\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Level != "NOTICE" || log.Message != "An application event" {
+		t.Errorf("expected NOTICE/'An application event' found %s/'%s'", log.Level, log.Message)
+	}
+	testSyslogProps(t, &log, map[string]string{
+		KeyFacility:   "local4",
+		KeySeverity:   "notice",
+		KeyHost:       "mymachine.example.com",
+		KeyApp:        "evntslog",
+		KeyMsgID:      "ID47",
+		"iut":         "3",
+		"eventSource": `App"lication]`,
+	})
+	if _, ok := log.Get(KeyProcID); ok {
+		t.Errorf("expected nil procid to not be set")
+	}
+	expected := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)
+	if ts, err := log.Time(); err != nil || !ts.Equal(expected) {
+		t.Errorf("expected time %s found %s: %v", expected, ts, err)
+	}
+}
+
+func TestParseSyslogRFC5424NoData(t *testing.T) {
+	log, err := ParseSyslog("<34>1 2003-10-11T22:14:15Z - su 123 - -")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Level != "CRIT" || log.Message != "" {
+		t.Errorf("expected CRIT/'' found %s/'%s'", log.Level, log.Message)
+	}
+	testSyslogProps(t, &log, map[string]string{KeyFacility: "auth", KeyApp: "su", KeyProcID: "123"})
+
+	if _, err := ParseSyslog(`<34>1 2003-10-11T22:14:15Z - su 123 - [id a="b`); err == nil {
+		t.Errorf("expected error for unterminated structured data")
+	}
+}
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	log, err := ParseSyslog("<34>Oct 11 22:14:15 mymachine su[42]: 'su root' failed for lonvick on /dev/pts/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Level != "CRIT" || log.Message != "'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("expected CRIT/'...' found %s/'%s'", log.Level, log.Message)
+	}
+	testSyslogProps(t, &log, map[string]string{KeyHost: "mymachine", KeyApp: "su", KeyProcID: "42"})
+	if ts, err := log.Time(); err != nil || ts.Month() != time.October || ts.Day() != 11 || ts.Hour() != 22 {
+		t.Errorf("unexpected time %s: %v", ts, err)
+	}
+
+	log, err = ParseSyslog("<13>Feb  5 17:32:18 logger: no hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := log.Get(KeyHost); ok || log.Message != "no hostname" {
+		t.Errorf("expected no host and message 'no hostname' found '%s'", log.Message)
+	}
+	testSyslogProps(t, &log, map[string]string{KeyApp: "logger", KeySeverity: "notice", KeyFacility: "user"})
+
+	log, err = ParseSyslog("<13>no timestamp")
+	if err != nil || log.Message != "no timestamp" {
+		t.Errorf("expected message 'no timestamp' found '%s': %v", log.Message, err)
+	}
+}
+
+func TestParseSyslogInvalidPRI(t *testing.T) {
+	for _, msg := range []string{"", "13>", "<>", "<192>1 - - - - - -", "<1x>", "<1234>"} {
+		if _, err := ParseSyslog(msg); err == nil {
+			t.Errorf("expected error parsing '%s'", msg)
+		}
+	}
+}
@@ -22,6 +22,23 @@ const (
 	luaNameLogStringFn    = "log_string"
 	luaNameLogJSONFn      = "log_json"
 	luaNameDebugFn        = "debug"
+	luaNameLokiPushFn     = "loki_push"
+	luaNameLogSplunkFn    = "log_splunk"
+	luaNameSplunkSendFn   = "splunk_send"
+	luaNameLogGELFFn      = "log_gelf"
+	luaNameGELFSendFn     = "gelf_send"
+	luaNameSyslogSendFn   = "syslog_send"
+	luaNameSocketWriteFn  = "socket_write"
+	luaNameCounterAddFn   = "counter_add"
+	luaNameGaugeSetFn     = "gauge_set"
+	luaNameHistogramFn    = "histogram_observe"
+	luaNameStatsdCountFn  = "statsd_count"
+	luaNameStatsdGaugeFn  = "statsd_gauge"
+	luaNameStatsdTimingFn = "statsd_timing"
+	luaNameStatsdSetFn    = "statsd_set"
+	luaNameStatsdHistFn   = "statsd_histogram"
+	luaNameLogInfluxFn    = "log_influx"
+	luaNameInfluxSendFn   = "influx_send"
 )
 
 var logdAPI = []lua.RegistryFunction{
@@ -39,6 +56,23 @@ var logdAPI = []lua.RegistryFunction{
 	{Name: luaNameKafkaMessageFn, Function: luaKafkaMessage},
 	{Name: luaNameKafkaProduceFn, Function: luaKafkaProduce},
 	{Name: luaNameDebugFn, Function: luaDebug},
+	{Name: luaNameLokiPushFn, Function: luaLokiPush},
+	{Name: luaNameLogSplunkFn, Function: luaLogSplunk},
+	{Name: luaNameSplunkSendFn, Function: luaSplunkSend},
+	{Name: luaNameLogGELFFn, Function: luaLogGELF},
+	{Name: luaNameGELFSendFn, Function: luaGELFSend},
+	{Name: luaNameSyslogSendFn, Function: luaSyslogSend},
+	{Name: luaNameSocketWriteFn, Function: luaSocketWrite},
+	{Name: luaNameCounterAddFn, Function: luaCounterAdd},
+	{Name: luaNameGaugeSetFn, Function: luaGaugeSet},
+	{Name: luaNameHistogramFn, Function: luaHistogramObserve},
+	{Name: luaNameStatsdCountFn, Function: luaStatsdCount},
+	{Name: luaNameStatsdGaugeFn, Function: luaStatsdGauge},
+	{Name: luaNameStatsdTimingFn, Function: luaStatsdTiming},
+	{Name: luaNameStatsdSetFn, Function: luaStatsdSet},
+	{Name: luaNameStatsdHistFn, Function: luaStatsdHistogram},
+	{Name: luaNameLogInfluxFn, Function: luaLogInflux},
+	{Name: luaNameInfluxSendFn, Function: luaInfluxSend},
 	/* hooks are left undefined
 	{Name: luaNameOnLogFn, Function: nil},
 	{Name: luaNameOnSignalFn, Function: nil},
@@ -96,6 +130,17 @@ func getArgInt(l *lua.State, i int, fn string) int {
 	return arg
 }
 
+// getTableKey returns the key of the table entry pushed by Next, which must be a string.
+// Keys are not converted with ToString, which would confuse Next.
+func getTableKey(l *lua.State, fn string) string {
+	if l.TypeOf(-2) != lua.TypeString {
+		panic(fmt.Errorf("table key must be a string in call to builtin '%s': found %s",
+			fn, l.TypeOf(-2)))
+	}
+	k, _ := l.ToString(-2)
+	return k
+}
+
 func getStateSandbox(l *lua.State) *Sandbox {
 	l.Global(luaNameSandboxContext)
 	sandbox, ok := l.ToUserData(-1).(*Sandbox)
@@ -163,11 +208,7 @@ func luaDebug(l *lua.State) int {
 		l.PushNil()
 
 		for l.Next(1) {
-			k, ok := l.ToString(-2)
-			if !ok {
-				panic(fmt.Errorf("table key must be a string in call to builtin '%s': found %s",
-					luaNameDebugFn, l.TypeOf(-2)))
-			}
+			k := getTableKey(l, luaNameDebugFn)
 			v := l.ToValue(-1)
 			fields[k] = v
 			l.Pop(1)
@@ -200,6 +241,122 @@ func luaSetConfig(l *lua.State) int {
 		err = sandbox.setHTTPTimeout(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigHTTPTimeout))
 	case luaConfigHTTPChannelBuffer:
 		err = sandbox.setHTTPChannelBuffer(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigHTTPChannelBuffer))
+	case luaConfigHTTPRetries:
+		err = sandbox.setHTTPRetries(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigHTTPRetries))
+	case luaConfigHTTPRetryBackoff:
+		err = sandbox.setHTTPRetryBackoff(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigHTTPRetryBackoff))
+	case luaConfigLokiURL:
+		err = sandbox.setLokiURL(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigLokiURL))
+	case luaConfigLokiTenantID:
+		err = sandbox.setLokiTenantID(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigLokiTenantID))
+	case luaConfigLokiLabels:
+		err = sandbox.setLokiLabels(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigLokiLabels))
+	case luaConfigLokiFormat:
+		err = sandbox.setLokiFormat(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigLokiFormat))
+	case luaConfigLokiBatchSize:
+		err = sandbox.setLokiBatchSize(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigLokiBatchSize))
+	case luaConfigLokiBatchWait:
+		err = sandbox.setLokiBatchWait(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigLokiBatchWait))
+	case luaConfigLokiSink:
+		err = sandbox.setLokiSink(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigLokiSink))
+	case luaConfigSplunkURL:
+		err = sandbox.setSplunkURL(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkURL))
+	case luaConfigSplunkToken:
+		err = sandbox.setSplunkToken(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkToken))
+	case luaConfigSplunkHost:
+		err = sandbox.setSplunkHost(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkHost))
+	case luaConfigSplunkSource:
+		err = sandbox.setSplunkSource(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkSource))
+	case luaConfigSplunkSourceType:
+		err = sandbox.setSplunkSourceType(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkSourceType))
+	case luaConfigSplunkIndex:
+		err = sandbox.setSplunkIndex(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkIndex))
+	case luaConfigSplunkBatchSize:
+		err = sandbox.setSplunkBatchSize(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigSplunkBatchSize))
+	case luaConfigSplunkBatchWait:
+		err = sandbox.setSplunkBatchWait(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSplunkBatchWait))
+	case luaConfigSplunkSink:
+		err = sandbox.setSplunkSink(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigSplunkSink))
+	case luaConfigGELFAddress:
+		err = sandbox.setGELFAddress(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigGELFAddress))
+	case luaConfigGELFHost:
+		err = sandbox.setGELFHost(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigGELFHost))
+	case luaConfigGELFCompress:
+		err = sandbox.setGELFCompress(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigGELFCompress))
+	case luaConfigGELFChunkSize:
+		err = sandbox.setGELFChunkSize(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigGELFChunkSize))
+	case luaConfigGELFSink:
+		err = sandbox.setGELFSink(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigGELFSink))
+	case luaConfigSyslogAddress:
+		err = sandbox.setSyslogAddress(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogAddress))
+	case luaConfigSyslogFormat:
+		err = sandbox.setSyslogFormat(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogFormat))
+	case luaConfigSyslogFacility:
+		err = sandbox.setSyslogFacility(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogFacility))
+	case luaConfigSyslogHostname:
+		err = sandbox.setSyslogHostname(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogHostname))
+	case luaConfigSyslogAppName:
+		err = sandbox.setSyslogAppName(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogAppName))
+	case luaConfigSyslogSDID:
+		err = sandbox.setSyslogSDID(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogSDID))
+	case luaConfigSyslogBuffer:
+		err = sandbox.setSyslogBuffer(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigSyslogBuffer))
+	case luaConfigSyslogTLSCA:
+		err = sandbox.setSyslogTLSCA(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogTLSCA))
+	case luaConfigSyslogTLSCert:
+		err = sandbox.setSyslogTLSCert(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogTLSCert))
+	case luaConfigSyslogTLSKey:
+		err = sandbox.setSyslogTLSKey(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSyslogTLSKey))
+	case luaConfigSyslogTLSInsecure:
+		err = sandbox.setSyslogTLSInsecure(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigSyslogTLSInsecure))
+	case luaConfigSyslogSink:
+		err = sandbox.setSyslogSink(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigSyslogSink))
+	case luaConfigSocketFormat:
+		err = sandbox.setSocketFormat(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSocketFormat))
+	case luaConfigSocketFraming:
+		err = sandbox.setSocketFraming(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSocketFraming))
+	case luaConfigSocketBuffer:
+		err = sandbox.setSocketBuffer(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigSocketBuffer))
+	case luaConfigSocketSink:
+		err = sandbox.setSocketSink(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigSocketSink))
+	case luaConfigStatsdAddress:
+		err = sandbox.setStatsdAddress(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigStatsdAddress))
+	case luaConfigStatsdPrefix:
+		err = sandbox.setStatsdPrefix(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigStatsdPrefix))
+	case luaConfigStatsdTags:
+		err = sandbox.setStatsdTags(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigStatsdTags))
+	case luaConfigStatsdFlush:
+		err = sandbox.setStatsdFlushInterval(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigStatsdFlush))
+	case luaConfigStatsdPacketSize:
+		err = sandbox.setStatsdMaxPacketSize(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigStatsdPacketSize))
+	case luaConfigInfluxURL:
+		err = sandbox.setInfluxURL(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxURL))
+	case luaConfigInfluxToken:
+		err = sandbox.setInfluxToken(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxToken))
+	case luaConfigInfluxDatabase:
+		err = sandbox.setInfluxDatabase(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxDatabase))
+	case luaConfigInfluxRP:
+		err = sandbox.setInfluxRetentionPolicy(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxRP))
+	case luaConfigInfluxOrg:
+		err = sandbox.setInfluxOrg(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxOrg))
+	case luaConfigInfluxBucket:
+		err = sandbox.setInfluxBucket(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxBucket))
+	case luaConfigInfluxMeasurement:
+		err = sandbox.setInfluxMeasurement(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxMeasurement))
+	case luaConfigInfluxMeasureKey:
+		err = sandbox.setInfluxMeasurementKey(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxMeasureKey))
+	case luaConfigInfluxTags:
+		err = sandbox.setInfluxTags(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxTags))
+	case luaConfigInfluxFields:
+		err = sandbox.setInfluxFields(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxFields))
+	case luaConfigInfluxPrecision:
+		err = sandbox.setInfluxPrecision(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxPrecision))
+	case luaConfigInfluxBatchSize:
+		err = sandbox.setInfluxBatchSize(getArgInt(l, 2, luaNameConfigFn+"#"+luaConfigInfluxBatchSize))
+	case luaConfigInfluxBatchWait:
+		err = sandbox.setInfluxBatchWait(getArgString(l, 2, luaNameConfigFn+"#"+luaConfigInfluxBatchWait))
+	case luaConfigInfluxSink:
+		err = sandbox.setInfluxSink(getArgBool(l, 2, luaNameConfigFn+"#"+luaConfigInfluxSink))
 	default:
 		if !sandbox.setKafkaConfig(key, l.ToValue(2)) {
 			err = fmt.Errorf("unknown config key in call to `%s`: '%s'. Available keys: %v",
@@ -210,6 +367,7 @@ func luaSetConfig(l *lua.State) int {
 		lua.Errorf(l, "%s: %s", luaNameConfigFn, err)
 		panic("unreachable")
 	}
+	sandbox.configValues[key] = configValue(l.ToValue(2))
 	return 0
 }
 
@@ -1,5 +1,10 @@
 package lua
 
+import (
+	"fmt"
+	"sort"
+)
+
 // sandboxConfig represents the configuration of a lua.Sandbox
 type sandboxConfig struct {
 	// general
@@ -14,6 +19,64 @@ const (
 	luaConfigHTTPConcurrency   = "http.concurrency"
 	luaConfigHTTPTimeout       = "http.timeout"
 	luaConfigHTTPChannelBuffer = "http.channel_buffer"
+	luaConfigHTTPRetries       = "http.retries"
+	luaConfigHTTPRetryBackoff  = "http.retry_backoff"
+	luaConfigLokiURL           = "loki.url"
+	luaConfigLokiTenantID      = "loki.tenant_id"
+	luaConfigLokiLabels        = "loki.labels"
+	luaConfigLokiFormat        = "loki.format"
+	luaConfigLokiBatchSize     = "loki.batch_size"
+	luaConfigLokiBatchWait     = "loki.batch_wait"
+	luaConfigLokiSink          = "loki.sink"
+	luaConfigSplunkURL         = "splunk.url"
+	luaConfigSplunkToken       = "splunk.token"
+	luaConfigSplunkHost        = "splunk.host"
+	luaConfigSplunkSource      = "splunk.source"
+	luaConfigSplunkSourceType  = "splunk.sourcetype"
+	luaConfigSplunkIndex       = "splunk.index"
+	luaConfigSplunkBatchSize   = "splunk.batch_size"
+	luaConfigSplunkBatchWait   = "splunk.batch_wait"
+	luaConfigSplunkSink        = "splunk.sink"
+	luaConfigGELFAddress       = "gelf.address"
+	luaConfigGELFHost          = "gelf.host"
+	luaConfigGELFCompress      = "gelf.compress"
+	luaConfigGELFChunkSize     = "gelf.chunk_size"
+	luaConfigGELFSink          = "gelf.sink"
+	luaConfigSyslogAddress     = "syslog.address"
+	luaConfigSyslogFormat      = "syslog.format"
+	luaConfigSyslogFacility    = "syslog.facility"
+	luaConfigSyslogHostname    = "syslog.hostname"
+	luaConfigSyslogAppName     = "syslog.app_name"
+	luaConfigSyslogSDID        = "syslog.sd_id"
+	luaConfigSyslogBuffer      = "syslog.buffer"
+	luaConfigSyslogTLSCA       = "syslog.tls_ca"
+	luaConfigSyslogTLSCert     = "syslog.tls_cert"
+	luaConfigSyslogTLSKey      = "syslog.tls_key"
+	luaConfigSyslogTLSInsecure = "syslog.tls_insecure"
+	luaConfigSyslogSink        = "syslog.sink"
+	luaConfigSocketFormat      = "socket.format"
+	luaConfigSocketFraming     = "socket.framing"
+	luaConfigSocketBuffer      = "socket.buffer"
+	luaConfigSocketSink        = "socket.sink"
+	luaConfigStatsdAddress     = "statsd.address"
+	luaConfigStatsdPrefix      = "statsd.prefix"
+	luaConfigStatsdTags        = "statsd.tags"
+	luaConfigStatsdFlush       = "statsd.flush_interval"
+	luaConfigStatsdPacketSize  = "statsd.max_packet_size"
+	luaConfigInfluxURL         = "influx.url"
+	luaConfigInfluxToken       = "influx.token"
+	luaConfigInfluxDatabase    = "influx.database"
+	luaConfigInfluxRP          = "influx.retention_policy"
+	luaConfigInfluxOrg         = "influx.org"
+	luaConfigInfluxBucket      = "influx.bucket"
+	luaConfigInfluxMeasurement = "influx.measurement"
+	luaConfigInfluxMeasureKey  = "influx.measurement_key"
+	luaConfigInfluxTags        = "influx.tags"
+	luaConfigInfluxFields      = "influx.fields"
+	luaConfigInfluxPrecision   = "influx.precision"
+	luaConfigInfluxBatchSize   = "influx.batch_size"
+	luaConfigInfluxBatchWait   = "influx.batch_wait"
+	luaConfigInfluxSink        = "influx.sink"
 )
 
 var availableConfigKeys = []string{
@@ -22,4 +85,145 @@ var availableConfigKeys = []string{
 	luaConfigHTTPConcurrency,
 	luaConfigHTTPTimeout,
 	luaConfigHTTPChannelBuffer,
+	luaConfigHTTPRetries,
+	luaConfigHTTPRetryBackoff,
+	luaConfigLokiURL,
+	luaConfigLokiTenantID,
+	luaConfigLokiLabels,
+	luaConfigLokiFormat,
+	luaConfigLokiBatchSize,
+	luaConfigLokiBatchWait,
+	luaConfigLokiSink,
+	luaConfigSplunkURL,
+	luaConfigSplunkToken,
+	luaConfigSplunkHost,
+	luaConfigSplunkSource,
+	luaConfigSplunkSourceType,
+	luaConfigSplunkIndex,
+	luaConfigSplunkBatchSize,
+	luaConfigSplunkBatchWait,
+	luaConfigSplunkSink,
+	luaConfigGELFAddress,
+	luaConfigGELFHost,
+	luaConfigGELFCompress,
+	luaConfigGELFChunkSize,
+	luaConfigGELFSink,
+	luaConfigSyslogAddress,
+	luaConfigSyslogFormat,
+	luaConfigSyslogFacility,
+	luaConfigSyslogHostname,
+	luaConfigSyslogAppName,
+	luaConfigSyslogSDID,
+	luaConfigSyslogBuffer,
+	luaConfigSyslogTLSCA,
+	luaConfigSyslogTLSCert,
+	luaConfigSyslogTLSKey,
+	luaConfigSyslogTLSInsecure,
+	luaConfigSyslogSink,
+	luaConfigSocketFormat,
+	luaConfigSocketFraming,
+	luaConfigSocketBuffer,
+	luaConfigSocketSink,
+	luaConfigStatsdAddress,
+	luaConfigStatsdPrefix,
+	luaConfigStatsdTags,
+	luaConfigStatsdFlush,
+	luaConfigStatsdPacketSize,
+	luaConfigInfluxURL,
+	luaConfigInfluxToken,
+	luaConfigInfluxDatabase,
+	luaConfigInfluxRP,
+	luaConfigInfluxOrg,
+	luaConfigInfluxBucket,
+	luaConfigInfluxMeasurement,
+	luaConfigInfluxMeasureKey,
+	luaConfigInfluxTags,
+	luaConfigInfluxFields,
+	luaConfigInfluxPrecision,
+	luaConfigInfluxBatchSize,
+	luaConfigInfluxBatchWait,
+	luaConfigInfluxSink,
+}
+
+// configValue converts a value passed to config_set to a type that can be serialized
+func configValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, float64, nil:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Config returns the configuration values set with config_set since the script was loaded
+func (l *Sandbox) Config() map[string]interface{} {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	values := make(map[string]interface{}, len(l.configValues))
+	for k, v := range l.configValues {
+		values[k] = v
+	}
+	return values
+}
+
+// ConfigError is returned when one of the configuration values given to NewSandboxConfig
+// or LoadSandbox cannot be set
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config key '%s': %s", e.Key, e.Err)
+}
+
+// SetConfig sets the configuration key to the given string, boolean or number value
+// as if logd.config_set(key, value) was called by the script. Values are reset to
+// the ones set by the script, and the ones the sandbox was created with, when it is reloaded.
+func (l *Sandbox) SetConfig(key string, value interface{}) (err error) {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	return l.setConfig(key, value)
+}
+
+// applyConfig sets the configuration values the sandbox was created with, in order
+// of their keys. Caller must hold luaLock.
+func (l *Sandbox) applyConfig() (err error) {
+	keys := make([]string, 0, len(l.initConfig))
+	for key := range l.initConfig {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = l.setConfig(key, l.initConfig[key]); err != nil {
+			return &ConfigError{Key: key, Err: err}
+		}
+	}
+	return
+}
+
+// caller must hold luaLock
+func (l *Sandbox) setConfig(key string, value interface{}) (err error) {
+	top := l.state.Top()
+	defer l.state.SetTop(top)
+	l.state.PushGoFunction(luaSetConfig)
+	l.state.PushString(key)
+	switch v := value.(type) {
+	case string:
+		l.state.PushString(v)
+	case bool:
+		l.state.PushBoolean(v)
+	case float64:
+		l.state.PushNumber(v)
+	case int:
+		l.state.PushInteger(v)
+	default:
+		return fmt.Errorf("%s: value of '%s' must be a string, boolean or number: found %T", luaNameConfigFn, key, value)
+	}
+	if err = l.state.ProtectedCall(2, 0, 0); err != nil {
+		if msg, ok := l.state.ToString(-1); ok {
+			err = fmt.Errorf("%s", msg)
+		}
+	}
+	return
 }
@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const gelfSinkName = "gelf"
+
+// luaLogGELF will serialize the log and return it as a GELF message.
+// lua signature is function log_gelf(logptr) str
+func luaLogGELF(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameLogGELFFn)
+	sandbox := getStateSandbox(l)
+	var buf bytes.Buffer
+	output.WriteGELF(&buf, log, sandbox.gelfConfig)
+	l.PushString(buf.String())
+	return 1
+}
+
+// luaGELFSend will send the log as a GELF message to the configured address.
+// UDP and TCP messages are sent synchronously and HTTP messages asynchronously via the HTTP client.
+// lua signature is function gelf_send(logptr)
+func luaGELFSend(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameGELFSendFn)
+	sandbox := getStateSandbox(l)
+
+	if sandbox.gelf == nil {
+		if err := sandbox.initGELF(); err != nil {
+			lua.Errorf(l, "gelf initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+
+	// Avoid resource contention. See luaHTTPPost
+	sandbox.luaLock.Unlock()
+	defer sandbox.luaLock.Lock()
+	if err := sandbox.gelf.Push(log); err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+// HTTP client is only initialized if GELF messages are sent over HTTP
+func (l *Sandbox) initGELFHTTP() (err error) {
+	if l.http == nil && strings.HasPrefix(l.gelfConfig.Address, "http") {
+		err = l.initHTTP()
+	}
+	return
+}
+
+func (l *Sandbox) initGELF() (err error) {
+	if err = l.initGELFHTTP(); err != nil {
+		return
+	}
+	l.gelf, err = output.NewGELF(l.gelfConfig, l.http)
+	return
+}
+
+// re-initializes GELF client if it is running so new configuration takes effect
+func (l *Sandbox) reloadGELF() (err error) {
+	if l.gelf != nil {
+		if err = l.initGELFHTTP(); err != nil {
+			return
+		}
+		err = l.gelf.Init(l.gelfConfig, l.http)
+	}
+	return
+}
+
+func (l *Sandbox) setGELFAddress(address string) error {
+	l.gelfConfig.Address = address
+	return l.reloadGELF()
+}
+
+func (l *Sandbox) setGELFHost(host string) error {
+	l.gelfConfig.Host = host
+	return l.reloadGELF()
+}
+
+func (l *Sandbox) setGELFCompress(enabled bool) error {
+	l.gelfConfig.Compress = enabled
+	return l.reloadGELF()
+}
+
+func (l *Sandbox) setGELFChunkSize(size int) error {
+	l.gelfConfig.ChunkSize = size
+	return l.reloadGELF()
+}
+
+func (l *Sandbox) setGELFSink(enabled bool) (err error) {
+	if enabled && l.gelf == nil {
+		if err = l.initGELF(); err != nil {
+			return
+		}
+	}
+	l.setSink(gelfSinkName, l.gelf, enabled)
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	stdHttp "net/http"
+	"sync/atomic"
 	"time"
 
 	lua "github.com/Shopify/go-lua"
@@ -29,7 +30,7 @@ func luaHTTPGet(l *lua.State) int {
 	if l.ToValue(2) != nil {
 		l.PushNil()
 		for l.Next(2) {
-			k := lua.CheckString(l, -2)
+			k := getTableKey(l, luaNameHTTPGetFn)
 			v := lua.CheckString(l, -1)
 			req.Header.Add(k, v)
 			l.Pop(1)
@@ -98,6 +99,30 @@ func (l *Sandbox) setHTTPTimeout(timeoutStr string) (err error) {
 	return
 }
 
+func (l *Sandbox) setHTTPRetries(retries int) (err error) {
+	l.httpConfig.Retries = retries
+	if l.http != nil {
+		if err = l.http.Init(l.httpConfig, l.httpErrors); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *Sandbox) setHTTPRetryBackoff(backoffStr string) (err error) {
+	var backoff time.Duration
+	if backoff, err = time.ParseDuration(backoffStr); err != nil {
+		return
+	}
+	l.httpConfig.RetryBackoff = backoff
+	if l.http != nil {
+		if err = l.http.Init(l.httpConfig, l.httpErrors); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (l *Sandbox) setHTTPChannelBuffer(c int) (err error) {
 	l.httpConfig.ChanBuffer = c
 	if l.http != nil {
@@ -121,6 +146,10 @@ func (l *Sandbox) setHTTPConcurrency(c int) (err error) {
 func (l *Sandbox) callOnHTTPError(e http.Error) {
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
+	// errors of abandoned clients can be reported once the sandbox is closed
+	if l.state == nil {
+		return
+	}
 
 	l.state.Global(luaNameLogdModule)
 	defer l.state.Pop(1)
@@ -141,8 +170,26 @@ func (l *Sandbox) callOnHTTPError(e http.Error) {
 	l.state.Call(3, 0)
 }
 
-func (l *Sandbox) pollHTTPErrors() {
-	for err := range l.httpErrors {
-		l.callOnHTTPError(err)
+// pollHTTP starts supplying the errors of the http client to logd.on_http_error
+func (l *Sandbox) pollHTTP() {
+	// the channel is read before the poller starts, since it is reset once the client is closed
+	errs := l.httpErrors
+	l.poll(func(quit <-chan struct{}) { l.pollHTTPErrors(errs, quit) })
+}
+
+// pollHTTPErrors supplies the http errors to logd.on_http_error until the client
+// is closed or handed over to another Sandbox
+func (l *Sandbox) pollHTTPErrors(errs <-chan http.Error, quit <-chan struct{}) {
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			atomic.AddInt64(&l.undelivered, 1)
+			l.callOnHTTPError(err)
+		case <-quit:
+			return
+		}
 	}
 }
@@ -0,0 +1,157 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const influxSinkName = "influx"
+
+// luaLogInflux will serialize the log and return it as a line of the InfluxDB line protocol.
+// lua signature is function log_influx(logptr) str
+func luaLogInflux(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameLogInfluxFn)
+	sandbox := getStateSandbox(l)
+	var buf bytes.Buffer
+	if err := output.WriteInfluxLine(&buf, log, sandbox.influxConfig); err != nil {
+		lua.Errorf(l, "%s: %s", luaNameLogInfluxFn, err)
+		panic("unreachable")
+	}
+	l.PushString(buf.String())
+	return 1
+}
+
+// luaInfluxSend will add the log to the current InfluxDB batch. Batches are sent
+// asynchronously via the HTTP client when batch size or wait time are reached.
+// lua signature is function influx_send(logptr)
+func luaInfluxSend(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameInfluxSendFn)
+	sandbox := getStateSandbox(l)
+
+	if sandbox.influx == nil {
+		if err := sandbox.initInflux(); err != nil {
+			lua.Errorf(l, "influx initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+
+	// Avoid resource contention. See luaHTTPPost
+	sandbox.luaLock.Unlock()
+	defer sandbox.luaLock.Lock()
+	if err := sandbox.influx.Push(log); err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+func (l *Sandbox) initInflux() (err error) {
+	if l.http == nil {
+		if err = l.initHTTP(); err != nil {
+			return
+		}
+	}
+	l.influx, err = output.NewInflux(l.influxConfig, l.http)
+	return
+}
+
+// re-initializes Influx client if it is running so new configuration takes effect
+func (l *Sandbox) reloadInflux() (err error) {
+	if l.influx != nil {
+		err = l.influx.Init(l.influxConfig, l.http)
+	}
+	return
+}
+
+func splitConfigList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (l *Sandbox) setInfluxURL(url string) error {
+	l.influxConfig.URL = url
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxToken(token string) error {
+	l.influxConfig.Token = token
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxDatabase(database string) error {
+	l.influxConfig.Database = database
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxRetentionPolicy(rp string) error {
+	l.influxConfig.RetentionPolicy = rp
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxOrg(org string) error {
+	l.influxConfig.Org = org
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxBucket(bucket string) error {
+	l.influxConfig.Bucket = bucket
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxMeasurement(measurement string) error {
+	l.influxConfig.Measurement = measurement
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxMeasurementKey(key string) error {
+	l.influxConfig.MeasurementKey = key
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxTags(tags string) error {
+	l.influxConfig.Tags = splitConfigList(tags)
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxFields(fields string) error {
+	l.influxConfig.Fields = splitConfigList(fields)
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxPrecision(precision string) error {
+	l.influxConfig.Precision = precision
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxBatchSize(size int) error {
+	l.influxConfig.BatchSize = size
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxBatchWait(waitStr string) (err error) {
+	var wait time.Duration
+	if wait, err = time.ParseDuration(waitStr); err != nil {
+		return
+	}
+	l.influxConfig.BatchWait = wait
+	return l.reloadInflux()
+}
+
+func (l *Sandbox) setInfluxSink(enabled bool) (err error) {
+	if enabled && l.influx == nil {
+		if err = l.initInflux(); err != nil {
+			return
+		}
+	}
+	l.setSink(influxSinkName, l.influx, enabled)
+	return
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync/atomic"
 
 	lua "github.com/Shopify/go-lua"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
@@ -93,6 +94,8 @@ func luaKafkaProduce(l *lua.State) int {
 	sandbox.luaLock.Unlock()
 	defer sandbox.luaLock.Lock()
 	channel <- message
+	sandbox.metrics.kafkaProduced.Inc()
+	sandbox.metrics.kafkaQueue.Add(1)
 	return 0
 }
 
@@ -153,8 +156,18 @@ func (l *Sandbox) setKafkaConfig(key string, value interface{}) bool {
 }
 
 func (l *Sandbox) callOnKafkaReport(m *kafka.Message) {
+	l.metrics.kafkaQueue.Add(-1)
+	if m.TopicPartition.Error != nil {
+		l.metrics.kafkaFailed.Inc()
+		atomic.AddInt64(&l.undelivered, 1)
+	}
+
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
+	// errors of abandoned clients can be reported once the sandbox is closed
+	if l.state == nil {
+		return
+	}
 
 	l.state.Global(luaNameLogdModule)
 	defer l.state.Pop(1)
@@ -180,8 +193,27 @@ func (l *Sandbox) callOnKafkaReport(m *kafka.Message) {
 	l.state.Call(2, 0)
 }
 
-func (l *Sandbox) pollKafkaEvents() {
-	for ev := range l.kafka.Events() {
+// pollKafka starts supplying the delivery reports of the kafka producer to logd.on_kafka_report
+func (l *Sandbox) pollKafka() {
+	// the channel is read before the poller starts, since the producer is reset once it is closed
+	events := l.kafka.Events()
+	l.poll(func(quit <-chan struct{}) { l.pollKafkaEvents(events, quit) })
+}
+
+// pollKafkaEvents supplies the delivery reports to logd.on_kafka_report until the
+// producer is closed or handed over to another Sandbox
+func (l *Sandbox) pollKafkaEvents(events chan kafka.Event, quit <-chan struct{}) {
+	for {
+		var ev kafka.Event
+		var ok bool
+		select {
+		case ev, ok = <-events:
+			if !ok {
+				return
+			}
+		case <-quit:
+			return
+		}
 		switch ev.(type) {
 		case *kafka.Message:
 			l.callOnKafkaReport(ev.(*kafka.Message))
@@ -228,9 +260,10 @@ func setSaneKafkaDefaults(config *kafka.ConfigMap) {
 	})
 }
 
-func (l *Sandbox) flushKafka() {
+func (l *Sandbox) flushKafka() (err error) {
 	timeout := l.getFlushTimeout()
 	if unflushed := l.kafka.Flush(timeout); unflushed > 0 {
-		panic(fmt.Errorf("failed to flush %d kafka messages", unflushed))
+		err = fmt.Errorf("failed to flush %d kafka messages", unflushed)
 	}
+	return
 }
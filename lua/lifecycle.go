@@ -0,0 +1,300 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+
+	lua "github.com/Shopify/go-lua"
+)
+
+// maxStateDepth is the max nesting of the tables returned by logd.on_shutdown.
+// It also prevents tables with cycles from being copied forever.
+const maxStateDepth = 32
+
+// stateTable is a copy of a table returned by logd.on_shutdown that can be pushed into
+// a different lua state. Keys are strings, numbers or booleans and values can also be stateTables.
+type stateTable map[interface{}]interface{}
+
+// toStateValue copies the value at the given index of the lua stack
+func toStateValue(l *lua.State, index, depth int) (v interface{}, err error) {
+	switch l.TypeOf(index) {
+	case lua.TypeNil:
+		return nil, nil
+	case lua.TypeBoolean:
+		return l.ToBoolean(index), nil
+	case lua.TypeNumber:
+		n, _ := l.ToNumber(index)
+		return n, nil
+	case lua.TypeString:
+		s, _ := l.ToString(index)
+		return s, nil
+	case lua.TypeTable:
+		return toStateTable(l, index, depth+1)
+	default:
+		return nil, fmt.Errorf("values of type %s cannot be kept across reloads", lua.TypeNameOf(l, index))
+	}
+}
+
+// toStateTable copies the table at the given index of the lua stack
+func toStateTable(l *lua.State, index, depth int) (t stateTable, err error) {
+	if depth > maxStateDepth {
+		return nil, fmt.Errorf("tables nested more than %d levels or with cycles cannot be kept across reloads", maxStateDepth)
+	}
+	// every nested table needs room for its key, value and the next key
+	if !l.CheckStack(3) {
+		return nil, fmt.Errorf("stack overflow copying the table")
+	}
+	index = l.AbsIndex(index)
+	t = make(stateTable)
+	l.PushNil()
+	for l.Next(index) {
+		var k, v interface{}
+		// only string keys are converted with ToString, which would confuse Next otherwise
+		if k, err = toStateValue(l, -2, depth); err == nil {
+			if _, ok := k.(stateTable); ok {
+				err = fmt.Errorf("table keys cannot be kept across reloads")
+			} else {
+				v, err = toStateValue(l, -1, depth)
+			}
+		}
+		if err != nil {
+			l.Pop(2)
+			return nil, err
+		}
+		t[k] = v
+		l.Pop(1)
+	}
+	return
+}
+
+// pushStateValue pushes a copy of the value into the lua stack
+func pushStateValue(l *lua.State, v interface{}) {
+	switch v := v.(type) {
+	case bool:
+		l.PushBoolean(v)
+	case float64:
+		l.PushNumber(v)
+	case string:
+		l.PushString(v)
+	case stateTable:
+		// tables are nested at most maxStateDepth levels, so the stack can always grow
+		l.CheckStack(3)
+		l.CreateTable(0, len(v))
+		for k, e := range v {
+			pushStateValue(l, k)
+			pushStateValue(l, e)
+			l.RawSet(-3)
+		}
+	default:
+		l.PushNil()
+	}
+}
+
+// callHook calls the logd hook with the given name if it is defined by the script. The nargs
+// arguments are pushed with push and the first result, if any, is returned as a stateTable.
+// Caller must hold luaLock.
+func (l *Sandbox) callHook(name string, nargs int, push func(), result bool) (state stateTable, err error) {
+	if l.state == nil || !l.hookDefined(name) {
+		return
+	}
+	top := l.state.Top()
+	defer l.state.SetTop(top)
+
+	l.state.Global(luaNameLogdModule)
+	l.state.Field(-1, name)
+	if push != nil {
+		push()
+	}
+	nresults := 0
+	if result {
+		nresults = 1
+	}
+	if err = l.state.ProtectedCall(nargs, nresults, 0); err != nil {
+		if msg, ok := l.state.ToString(-1); ok {
+			err = fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("%s.%s: %s", luaNameLogdModule, name, err)
+	}
+	if !result {
+		return
+	}
+	switch l.state.TypeOf(-1) {
+	case lua.TypeNil:
+	case lua.TypeTable:
+		if state, err = toStateTable(l.state, -1, 0); err != nil {
+			err = fmt.Errorf("%s.%s: %s", luaNameLogdModule, name, err)
+		}
+	default:
+		err = fmt.Errorf("%s.%s must return a table or nil: found %s",
+			luaNameLogdModule, name, lua.TypeNameOf(l.state, -1))
+	}
+	return
+}
+
+// callOnStart calls logd.on_start once the script has been loaded
+func (l *Sandbox) callOnStart() (err error) {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	_, err = l.callHook(luaNameOnStartFn, 0, nil, false)
+	return
+}
+
+// callOnShutdown calls logd.on_shutdown and returns the table returned by it, if any
+func (l *Sandbox) callOnShutdown() (state stateTable, err error) {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	return l.callHook(luaNameOnShutdownFn, 0, nil, true)
+}
+
+// callOnReload calls logd.on_reload with the table returned by logd.on_shutdown
+// before the script was reloaded, or with an empty table if none was returned
+func (l *Sandbox) callOnReload(state stateTable) (err error) {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	if state == nil {
+		state = make(stateTable)
+	}
+	_, err = l.callHook(luaNameOnReloadFn, 1, func() { pushStateValue(l.state, state) }, false)
+	return
+}
+
+// start calls logd.on_reload with the given state if it is defined by the script,
+// or logd.on_start otherwise
+func (l *Sandbox) start(state stateTable) error {
+	l.luaLock.Lock()
+	reload := l.hookDefined(luaNameOnReloadFn)
+	l.luaLock.Unlock()
+	if !reload {
+		return l.callOnStart()
+	}
+	return l.callOnReload(state)
+}
+
+// LoadSandbox loads the script in a new Sandbox of the given pipeline, which sets the given
+// configuration values like NewSandboxConfig, without calling any of its hooks, so that it
+// can replace a running Sandbox with Replace
+func LoadSandbox(pipeline, scriptPath string, config map[string]interface{}) (l *Sandbox, err error) {
+	l = newSandbox(pipeline, config)
+	if err = l.init(scriptPath); err != nil {
+		l.closeOutputs(nil)
+		l = nil
+	}
+	return
+}
+
+// Replace hands over the state of l to next, which must have been loaded with LoadSandbox:
+// logd.on_shutdown is called in l and logd.on_reload, or logd.on_start, in next with the
+// table returned. The http and kafka clients of l are handed over to next too, so the
+// requests and messages queued are not dropped. If an error is returned, l can still be
+// used. Otherwise l must be closed, which delivers the data buffered by its other outputs.
+// Note that if logd.on_reload fails in next, l keeps running after logd.on_shutdown was
+// called in it, so scripts should not release in logd.on_shutdown what logd.on_log needs.
+func (l *Sandbox) Replace(next *Sandbox) (err error) {
+	// logd.on_tick must not change the state of l once it is copied
+	ticking := l.stopTicker()
+	defer func() {
+		if err != nil && ticking {
+			l.runTicker(l.currentTick())
+		}
+	}()
+
+	var state stateTable
+	if state, err = l.callOnShutdown(); err != nil {
+		return
+	}
+	if err = next.start(state); err != nil {
+		return
+	}
+	l.handoverClients(next)
+	return
+}
+
+// poll runs fn in a goroutine until quitpoll is closed
+func (l *Sandbox) poll(fn func(quit <-chan struct{})) {
+	if l.quitpoll == nil {
+		l.quitpoll = make(chan struct{})
+	}
+	quit := l.quitpoll
+	l.pollers.Add(1)
+	go func() {
+		defer l.pollers.Done()
+		fn(quit)
+	}()
+}
+
+// handoverClients moves the http and kafka clients of l to next if next has not
+// created its own. The clients are only handed over if their configuration has not
+// changed, otherwise they are closed with l. Errors and delivery reports are supplied
+// to the hooks of next afterwards.
+func (l *Sandbox) handoverClients(next *Sandbox) {
+	// l must not use the clients anymore
+	l.stopTicker()
+	if l.quitpoll != nil {
+		close(l.quitpoll)
+		l.pollers.Wait()
+		l.quitpoll = nil
+	}
+
+	l.luaLock.Lock()
+	next.luaLock.Lock()
+	if l.http != nil && next.http == nil && *l.httpConfig == *next.httpConfig {
+		next.http, next.httpErrors = l.http, l.httpErrors
+		l.http, l.httpErrors = nil, nil
+		next.pollHTTP()
+	}
+	if l.kafka != nil && next.kafka == nil && reflect.DeepEqual(*l.kafkaConfig, *next.kafkaConfig) {
+		next.kafka = l.kafka
+		l.kafka = nil
+		next.pollKafka()
+	}
+	next.luaLock.Unlock()
+	l.luaLock.Unlock()
+
+	// errors of the clients that were not handed over are still supplied to l until it is closed
+	if l.http != nil {
+		l.pollHTTP()
+	}
+	if l.kafka != nil {
+		l.pollKafka()
+	}
+}
+
+// ScriptFiles returns the path of the script and the paths of the modules required
+// by it that are found in package.path
+func (l *Sandbox) ScriptFiles() []string {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+
+	files := []string{l.scriptPath}
+	if l.state == nil {
+		return files
+	}
+	top := l.state.Top()
+	defer l.state.SetTop(top)
+
+	l.state.Global("package")
+	l.state.Field(-1, "searchpath")
+	searchpath := l.state.Top()
+	l.state.Field(-2, "path")
+	path := l.state.Top()
+	l.state.Field(-3, "loaded")
+	loaded := l.state.Top()
+
+	l.state.PushNil()
+	for l.state.Next(loaded) {
+		if l.state.TypeOf(-2) == lua.TypeString {
+			name, _ := l.state.ToString(-2)
+			l.state.PushValue(searchpath)
+			l.state.PushString(name)
+			l.state.PushValue(path)
+			l.state.Call(2, 1)
+			if l.state.TypeOf(-1) == lua.TypeString {
+				file, _ := l.state.ToString(-1)
+				files = append(files, file)
+			}
+			l.state.Pop(1)
+		}
+		l.state.Pop(1)
+	}
+	return files
+}
@@ -0,0 +1,306 @@
+package lua
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+// testScriptHeader sets a tick long enough for logd.on_tick to never be called by the tests
+const testScriptHeader = `
+local logd = require("logd")
+logd.config_set("tick", 60000)
+function logd.on_tick() end
+`
+
+const testHooksScript = testScriptHeader + `
+events = ""
+function logd.on_start() events = events .. "start," end
+function logd.on_log(logptr) events = events .. "log," end
+function logd.on_shutdown()
+	events = events .. "shutdown,"
+	return {events = events}
+end
+function logd.on_reload(state) events = state.events .. "reload," end
+`
+
+func writeScript(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// globalString returns the value of the global variable of the script as a string
+func globalString(l *Sandbox, name string) string {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	l.state.Global(name)
+	defer l.state.Pop(1)
+	s, _ := l.state.ToString(-1)
+	return s
+}
+
+func TestSandboxHookOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, "hooks.lua", testHooksScript)
+
+	prev, err := NewSandbox(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prev.Close()
+	if err = prev.CallOnLog(logging.NewLog()); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := LoadSandbox("", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if events := globalString(next, "events"); events != "" {
+		t.Errorf("expected no hooks to be called when the script is loaded: found %s", events)
+	}
+	if err = prev.Replace(next); err != nil {
+		t.Fatal(err)
+	}
+	if err = next.CallOnLog(logging.NewLog()); err != nil {
+		t.Fatal(err)
+	}
+	if events := globalString(prev, "events"); events != "start,log,shutdown," {
+		t.Errorf("unexpected hooks called in the previous sandbox: %s", events)
+	}
+	if events := globalString(next, "events"); events != "start,log,shutdown,reload,log," {
+		t.Errorf("unexpected hooks called in the next sandbox: %s", events)
+	}
+
+	// logd.on_start is called instead if logd.on_reload is not defined
+	script = writeScript(t, dir, "start.lua", testScriptHeader+`
+events = ""
+function logd.on_start() events = events .. "start," end
+function logd.on_log(logptr) end
+`)
+	start, err := LoadSandbox("", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer start.Close()
+	if err = next.Replace(start); err != nil {
+		t.Fatal(err)
+	}
+	if events := globalString(start, "events"); events != "start," {
+		t.Errorf("expected logd.on_start to be called: found %s", events)
+	}
+}
+
+func TestSandboxStateCopy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	next := writeScript(t, dir, "next.lua", testScriptHeader+`
+function logd.on_log(logptr) end
+function logd.on_reload(state)
+	if state.depth then
+		local t, depth = state, 0
+		while t.nested do t, depth = t.nested, depth + 1 end
+		copied = t.value .. depth
+		return
+	end
+	copied = state[1] .. state[2.5] .. tostring(state[true]) .. state.nested.deep.value .. tostring(state.nested.zero)
+end
+`)
+
+	tests := []struct {
+		state string
+		// err is the error returned by Replace, if any, and copied the value copied by next otherwise
+		err    string
+		copied string
+	}{
+		{
+			state:  `{[1] = "one", [2.5] = "half", [true] = "yes", nested = {deep = {value = "deep"}, zero = 0}}`,
+			copied: "onehalfyesdeep0",
+		},
+		{
+			state:  `(function() local t = {value = "deep"} for i = 1, 30 do t = {nested = t} end t.depth = true return t end)()`,
+			copied: "deep30",
+		},
+		{
+			state: `(function() local t = {} t.self = t return t end)()`,
+			err:   "tables nested more than 32 levels or with cycles cannot be kept across reloads",
+		},
+		{
+			state: `(function() local t = {} for i = 1, 40 do t = {t} end return t end)()`,
+			err:   "tables nested more than 32 levels or with cycles cannot be kept across reloads",
+		},
+		{
+			state: `{[{}] = "table key"}`,
+			err:   "table keys cannot be kept across reloads",
+		},
+		{
+			state: `{fn = print}`,
+			err:   "values of type function cannot be kept across reloads",
+		},
+		{
+			state: `"not a table"`,
+			err:   "logd.on_shutdown must return a table or nil: found string",
+		},
+	}
+	for _, test := range tests {
+		prevScript := writeScript(t, dir, "prev.lua", testScriptHeader+`
+function logd.on_log(logptr) end
+function logd.on_shutdown() return `+test.state+` end
+`)
+		prev, err := NewSandbox(prevScript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := LoadSandbox("", next, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = prev.Replace(l)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", test.state, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error '%s' found '%v'", test.state, test.err, err)
+		case test.err == "":
+			if copied := globalString(l, "copied"); copied != test.copied {
+				t.Errorf("%s: expected %s to be copied found %s", test.state, test.copied, copied)
+			}
+		}
+		prev.Close()
+		l.Close()
+	}
+}
+
+func TestSandboxReloadRollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, "hooks.lua", testHooksScript)
+	failing := writeScript(t, dir, "failing.lua", testScriptHeader+`
+function logd.on_log(logptr) end
+function logd.on_reload(state) error("failed to reload") end
+`)
+
+	prev, err := NewSandbox(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prev.Close()
+	if err = prev.initHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	client := prev.http
+
+	next, err := LoadSandbox("", failing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = prev.Replace(next); err == nil || !strings.Contains(err.Error(), "failed to reload") {
+		t.Errorf("expected logd.on_reload error found %v", err)
+	}
+	next.Close()
+
+	// the previous sandbox keeps running with its clients and its ticker
+	if prev.http != client {
+		t.Errorf("expected the http client to be kept by the previous sandbox")
+	}
+	if prev.quitticker == nil {
+		t.Errorf("expected the ticker of the previous sandbox to be running")
+	}
+	if err = prev.CallOnLog(logging.NewLog()); err != nil {
+		t.Fatal(err)
+	}
+	if events := globalString(prev, "events"); events != "start,shutdown,log," {
+		t.Errorf("unexpected hooks called in the previous sandbox: %s", events)
+	}
+}
+
+func TestSandboxHandover(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, "hooks.lua", testHooksScript)
+
+	prev, err := NewSandbox(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prev.Close()
+	if err = prev.initHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	client := prev.http
+
+	next, err := LoadSandbox("", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if err = prev.Replace(next); err != nil {
+		t.Fatal(err)
+	}
+	if next.http != client || prev.http != nil {
+		t.Errorf("expected the http client to be handed over to the next sandbox")
+	}
+
+	// clients are not handed over if their configuration changes
+	changed, err := LoadSandbox("", script, map[string]interface{}{"http.timeout": "7s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changed.Close()
+	if err = next.Replace(changed); err != nil {
+		t.Fatal(err)
+	}
+	if changed.http != nil || next.http != client {
+		t.Errorf("expected the http client not to be handed over when its configuration changes")
+	}
+}
+
+func TestSandboxShutdownTimeout(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	script := writeScript(t, dir, "post.lua", testScriptHeader+`
+logd.config_set("http.timeout", "1s")
+function logd.on_log(logptr) logd.http_post("`+server.URL+`", "payload", "text/plain") end
+`)
+	l, err := NewSandbox(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.CallOnLog(logging.NewLog()); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Shutdown(10 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error found %v", err)
+	}
+	// the outputs are not closed concurrently once Shutdown returns
+	if l.http != nil || l.state != nil {
+		t.Errorf("expected the outputs to be released once Shutdown returns")
+	}
+}
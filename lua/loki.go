@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"strings"
+	"time"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const lokiSinkName = "loki"
+
+// luaLokiPush will add the log to the current Loki batch. Batches are pushed
+// asynchronously via the HTTP client when batch size or wait time are reached.
+// lua signature is function loki_push(logptr)
+func luaLokiPush(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameLokiPushFn)
+	sandbox := getStateSandbox(l)
+
+	if sandbox.loki == nil {
+		if err := sandbox.initLoki(); err != nil {
+			lua.Errorf(l, "loki initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+
+	// Avoid resource contention. See luaHTTPPost
+	sandbox.luaLock.Unlock()
+	defer sandbox.luaLock.Lock()
+	if err := sandbox.loki.Push(log); err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+func (l *Sandbox) initLoki() (err error) {
+	if l.http == nil {
+		if err = l.initHTTP(); err != nil {
+			return
+		}
+	}
+	l.loki, err = output.NewLoki(l.lokiConfig, l.http)
+	return
+}
+
+// re-initializes Loki client if it is running so new configuration takes effect
+func (l *Sandbox) reloadLoki() (err error) {
+	if l.loki != nil {
+		err = l.loki.Init(l.lokiConfig, l.http)
+	}
+	return
+}
+
+func (l *Sandbox) setLokiURL(url string) error {
+	l.lokiConfig.URL = url
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiTenantID(tenant string) error {
+	l.lokiConfig.TenantID = tenant
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiLabels(labelsStr string) error {
+	labels := make([]string, 0)
+	for _, label := range strings.Split(labelsStr, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	l.lokiConfig.Labels = labels
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiFormat(format string) error {
+	l.lokiConfig.Format = format
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiBatchSize(size int) error {
+	l.lokiConfig.BatchSize = size
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiBatchWait(waitStr string) (err error) {
+	var wait time.Duration
+	if wait, err = time.ParseDuration(waitStr); err != nil {
+		return
+	}
+	l.lokiConfig.BatchWait = wait
+	return l.reloadLoki()
+}
+
+func (l *Sandbox) setLokiSink(enabled bool) (err error) {
+	if enabled && l.loki == nil {
+		if err = l.initLoki(); err != nil {
+			return
+		}
+	}
+	l.setSink(lokiSinkName, l.loki, enabled)
+	return
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ernestrc/logd/http"
 	"github.com/ernestrc/logd/logging"
+	"github.com/ernestrc/logd/output"
 )
 
 const (
@@ -26,6 +29,9 @@ const (
 	luaNameOnTickFn        = "on_tick"
 	luaNameOnHTTPErrorFn   = "on_http_error"
 	luaNameOnKafkaReportFn = "on_kafka_report"
+	luaNameOnStartFn       = "on_start"
+	luaNameOnReloadFn      = "on_reload"
+	luaNameOnShutdownFn    = "on_shutdown"
 )
 
 var signals = map[int]string{
@@ -65,34 +71,107 @@ var signals = map[int]string{
 // Sandbox represents a lua VM wich exposes a series of builtin functions
 // to perform I/O operations and transformations over logging.Log structures.
 type Sandbox struct {
-	luaLock     sync.Mutex
-	scriptPath  string
-	cfg         sandboxConfig
-	state       *lua.State
-	httpConfig  *http.Config
-	http        *http.AsyncClient
-	kafkaConfig *kafka.ConfigMap
-	kafka       *kafka.Producer
-	quitticker  chan struct{}
-	httpErrors  chan http.Error
-}
-
-func (l *Sandbox) stopTicker() {
-	if l.quitticker == nil {
-		return
+	luaLock      sync.Mutex
+	scriptPath   string
+	cfg          sandboxConfig
+	state        *lua.State
+	httpConfig   *http.Config
+	http         *http.AsyncClient
+	kafkaConfig  *kafka.ConfigMap
+	kafka        *kafka.Producer
+	lokiConfig   *output.LokiConfig
+	loki         *output.Loki
+	splunkConfig *output.SplunkConfig
+	splunk       *output.Splunk
+	gelfConfig   *output.GELFConfig
+	gelf         *output.GELF
+	syslogConfig *output.SyslogConfig
+	syslog       *output.Syslog
+	statsdConfig *output.StatsDConfig
+	statsd       *output.StatsD
+	influxConfig *output.InfluxConfig
+	influx       *output.Influx
+	socketConfig *output.NetConfig
+	sockets      map[string]*output.Net
+	sinks        []namedSink
+	quitticker   chan struct{}
+	httpErrors   chan http.Error
+	// tickerLock serializes the restarts of the ticker, which can be requested concurrently
+	tickerLock sync.Mutex
+	// configValues are the values set with config_set since the script was loaded
+	configValues map[string]interface{}
+	// initConfig are the values set once the script is loaded, see NewSandboxConfig
+	initConfig map[string]interface{}
+	// pipeline is the name of the pipeline that runs the sandbox, which labels its metrics
+	pipeline string
+	metrics  *sandboxMetrics
+	// loading is true while the script is being loaded by init
+	loading     bool
+	errLock     sync.Mutex
+	lastError   error
+	lastErrorAt time.Time
+	// undelivered is the number of messages that failed to be delivered by kafka or http
+	undelivered int64
+	// quitpoll stops the goroutines that poll the http errors and kafka events
+	quitpoll chan struct{}
+	pollers  sync.WaitGroup
+}
+
+// stopTicker stops the ticker, once logd.on_tick returns if it is being called,
+// and returns whether it was running
+func (l *Sandbox) stopTicker() bool {
+	l.tickerLock.Lock()
+	defer l.tickerLock.Unlock()
+	return l.resetTicker(0)
+}
+
+// runTicker starts the ticker with the given tick in milliseconds. The ticker is restarted
+// if it is running.
+func (l *Sandbox) runTicker(tick int) {
+	l.tickerLock.Lock()
+	defer l.tickerLock.Unlock()
+	l.resetTicker(tick)
+}
+
+// resetTicker stops the ticker if it is running and, if tick is not 0, starts it with the
+// given tick. It returns whether the ticker was running. tickerLock must be held.
+func (l *Sandbox) resetTicker(tick int) (running bool) {
+	if l.quitticker != nil {
+		l.quitticker <- struct{}{}
+		close(l.quitticker)
+		l.quitticker = nil // indicating that ticker is not running
+		running = true
 	}
-	l.quitticker <- struct{}{}
-	close(l.quitticker)
-	l.quitticker = nil // indicating that ticker is not running
+	if tick != 0 {
+		l.quitticker = make(chan struct{})
+		go l.tick(tick, l.quitticker)
+	}
+	return
+}
+
+// currentTick returns the tick, which can be set concurrently with config_set
+func (l *Sandbox) currentTick() int {
+	l.luaLock.Lock()
+	defer l.luaLock.Unlock()
+	return l.cfg.tick
 }
 
+// restartTicker restarts the ticker with the current tick, unless it has been stopped
 func (l *Sandbox) restartTicker() {
-	l.stopTicker()
-	l.runTicker()
+	tick := l.currentTick()
+	l.tickerLock.Lock()
+	defer l.tickerLock.Unlock()
+	if l.quitticker != nil {
+		l.resetTicker(tick)
+	}
 }
 
 func (l *Sandbox) setTick(tick int) {
 	l.cfg.tick = tick
+	// the ticker is started once the script is loaded
+	if l.loading {
+		return
+	}
 	// stop/start ticker if running. In case setTick was called
 	// from on_tick hook, run stop/start in a separate goroutine
 	// so we don't deadlock
@@ -135,8 +214,8 @@ func (l *Sandbox) loadUserScript() error {
 	return nil
 }
 
-func (l *Sandbox) tick() {
-	ticker := time.NewTicker(time.Duration(l.cfg.tick * 1000 * 1000))
+func (l *Sandbox) tick(tick int, quit <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(tick * 1000 * 1000))
 	defer ticker.Stop()
 	for {
 		select {
@@ -150,26 +229,34 @@ func (l *Sandbox) tick() {
 			if err := fn(); err != nil {
 				panic(err)
 			}
-		case <-l.quitticker:
+		case <-quit:
 			return
 		}
 	}
 }
 
-func (l *Sandbox) runTicker() {
-	l.quitticker = make(chan struct{})
-	go l.tick()
-}
-
 // NewSandbox allocates storage and initializes a new Sandbox
 func NewSandbox(scriptPath string) (l *Sandbox, err error) {
-	l = new(Sandbox)
+	return NewSandboxConfig("", scriptPath, nil)
+}
+
+// NewSandboxConfig allocates storage and initializes a new Sandbox of the given pipeline that
+// sets the given configuration values once the script is loaded, as if the script called
+// logd.config_set with them, so they override the values set by the script when it is loaded.
+// Values must be strings, booleans or numbers and a *ConfigError is returned if any of them
+// cannot be set. The metrics of the sandbox are labeled with the name of the pipeline.
+func NewSandboxConfig(pipeline, scriptPath string, config map[string]interface{}) (l *Sandbox, err error) {
+	l = newSandbox(pipeline, config)
 	if err = l.Init(scriptPath); err != nil {
 		l = nil
 	}
 	return
 }
 
+func newSandbox(pipeline string, config map[string]interface{}) *Sandbox {
+	return &Sandbox{pipeline: pipeline, metrics: newSandboxMetrics(pipeline), initConfig: config}
+}
+
 // caller must take care of synchronizing concurrent access to state
 // and it's responsible for popping the logd module from the stack
 func (l *Sandbox) pushOnTick() (err error) {
@@ -210,12 +297,27 @@ func (l *Sandbox) callProtected(lg *logging.Log, args, ret int, fnName string) e
 		if _, ok := runtimeErr.(lua.RuntimeError); !ok {
 			return runtimeErr
 		}
+		metricErrors.WithLabelValues(l.pipeline, fnName).Inc()
+		l.recordError(fmt.Errorf("%s: %s", fnName, runtimeErr))
 		l.callOnError(lg, fmt.Errorf("%s : %s", fnName, runtimeErr))
 	}
 
 	return nil
 }
 
+func (l *Sandbox) recordError(err error) {
+	l.errLock.Lock()
+	l.lastError, l.lastErrorAt = err, time.Now()
+	l.errLock.Unlock()
+}
+
+// LastError returns the last runtime error handled by logd.on_error and when it happened
+func (l *Sandbox) LastError() (error, time.Time) {
+	l.errLock.Lock()
+	defer l.errLock.Unlock()
+	return l.lastError, l.lastErrorAt
+}
+
 func (l *Sandbox) callOnTick() (err error) {
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
@@ -280,10 +382,19 @@ func (l *Sandbox) callOnError(lg *logging.Log, err error) {
 }
 
 // CallOnLog will call this lua sandbox's on_log function with the given log pointer
-// or it will return an error if on_log is not defined.
+// or it will return an error if on_log is not defined. The log is then pushed to
+// the configured sinks.
 func (l *Sandbox) CallOnLog(lg *logging.Log) (err error) {
+	if err = l.callOnLog(lg); err != nil {
+		return
+	}
+	return l.writeSinks(lg)
+}
+
+func (l *Sandbox) callOnLog(lg *logging.Log) (err error) {
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
+	defer l.metrics.observeOnLog(time.Now())
 
 	err = l.pushOnLog(lg)
 	defer l.state.Pop(1)
@@ -299,8 +410,16 @@ func (l *Sandbox) CallOnLog(lg *logging.Log) (err error) {
 // ProtectedCallOnLog behaves like CallOnLog except that if there's a lua
 // runtime error, it will look for 'on_error' hook and call it.
 func (l *Sandbox) ProtectedCallOnLog(lg *logging.Log) (err error) {
+	if err = l.protectedCallOnLog(lg); err != nil {
+		return
+	}
+	return l.writeSinks(lg)
+}
+
+func (l *Sandbox) protectedCallOnLog(lg *logging.Log) (err error) {
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
+	defer l.metrics.observeOnLog(time.Now())
 
 	l.state.PushGoFunction(luaGoErrorHandler)
 	err = l.pushOnLog(lg)
@@ -335,7 +454,7 @@ func (l *Sandbox) initHTTP() (err error) {
 	if l.http, err = http.NewClient(l.httpConfig, l.httpErrors); err != nil {
 		return
 	}
-	go l.pollHTTPErrors()
+	l.pollHTTP()
 
 	return
 }
@@ -345,7 +464,7 @@ func (l *Sandbox) initKafka() (err error) {
 	if err != nil {
 		return
 	}
-	go l.pollKafkaEvents()
+	l.pollKafka()
 	return
 }
 
@@ -360,15 +479,25 @@ func (l *Sandbox) setProtected(enabled bool) (err error) {
 }
 
 // Init initializes l by instantiating a fresh lua state and loading the given script
-// along with the standard lua libraries in it. If cfg is nil, a default configuration is used.
+// along with the standard lua libraries in it. logd.on_start is called once the script is loaded.
 func (l *Sandbox) Init(scriptPath string) (err error) {
+	if err = l.init(scriptPath); err != nil {
+		return
+	}
+	return l.callOnStart()
+}
+
+func (l *Sandbox) init(scriptPath string) (err error) {
 	if l.state != nil {
 		l.Close()
 	}
 	l.luaLock.Lock()
 	defer l.luaLock.Unlock()
+	l.loading = true
+	defer func() { l.loading = false }()
 	l.state = lua.NewState()
 	l.scriptPath = scriptPath
+	l.configValues = make(map[string]interface{})
 
 	httpConfig := http.DefaultConfig
 	l.httpConfig = &httpConfig
@@ -377,6 +506,27 @@ func (l *Sandbox) Init(scriptPath string) (err error) {
 	l.kafkaConfig = &kafkaConfig
 	setSaneKafkaDefaults(l.kafkaConfig)
 
+	lokiConfig := output.DefaultLokiConfig
+	l.lokiConfig = &lokiConfig
+
+	splunkConfig := output.DefaultSplunkConfig
+	l.splunkConfig = &splunkConfig
+
+	gelfConfig := output.DefaultGELFConfig
+	l.gelfConfig = &gelfConfig
+
+	syslogConfig := output.DefaultSyslogConfig
+	l.syslogConfig = &syslogConfig
+
+	statsdConfig := output.DefaultStatsDConfig
+	l.statsdConfig = &statsdConfig
+
+	influxConfig := output.DefaultInfluxConfig
+	l.influxConfig = &influxConfig
+
+	socketConfig := output.DefaultNetConfig
+	l.socketConfig = &socketConfig
+
 	lua.OpenLibraries(l.state)
 	l.openLogdLibrary()
 
@@ -395,13 +545,17 @@ func (l *Sandbox) Init(scriptPath string) (err error) {
 		return
 	}
 
+	if err = l.applyConfig(); err != nil {
+		return
+	}
+
 	if l.cfg.protected {
 		if err = l.setProtected(l.cfg.protected); err != nil {
 			return
 		}
 	}
 
-	l.restartTicker()
+	l.runTicker(l.cfg.tick)
 	return
 }
 
@@ -414,34 +568,173 @@ func (l *Sandbox) ProtectedMode() bool {
 	return l.cfg.protected
 }
 
-// Flush will try to flush all pending I/O operations.
-func (l *Sandbox) Flush() {
+// Flush will try to flush all pending I/O operations and returns the first error of the outputs.
+func (l *Sandbox) Flush() (err error) {
+	setErr := func(e error) {
+		if e != nil && err == nil {
+			err = e
+		}
+	}
 	if l.kafka != nil {
-		l.flushKafka()
+		setErr(l.flushKafka())
+	}
+	if l.loki != nil {
+		setErr(l.loki.Flush())
+	}
+	if l.splunk != nil {
+		setErr(l.splunk.Flush())
+	}
+	if l.influx != nil {
+		setErr(l.influx.Flush())
 	}
 	if l.http != nil {
 		l.http.Flush()
 	}
+	if l.statsd != nil {
+		l.statsd.Flush()
+	}
+	return
 }
 
 // Close will shut down all the resources held by this Sandbox and flush all the
 // pending I/O operations. Init must be called again if this instance is to be used.
-func (l *Sandbox) Close() {
+// The first error returned by the outputs is returned.
+func (l *Sandbox) Close() (err error) {
+	if l.kafka != nil {
+		err = l.flushKafka()
+	}
+	if e := l.closeOutputs(nil); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// Shutdown calls logd.on_shutdown if it is defined by the script and closes the Sandbox
+// like Close, waiting until timeout for the pending I/O operations to complete.
+// An error is returned if any data could not be delivered.
+func (l *Sandbox) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	undelivered := atomic.LoadInt64(&l.undelivered)
+
+	var errs []string
+	if _, err := l.callOnShutdown(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	quit := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- l.drain(deadline, quit) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	case <-time.After(time.Until(deadline)):
+		errs = append(errs, fmt.Sprintf("timed out after %s waiting for the outputs to drain", timeout))
+		// the outputs that are not closed yet are abandoned once the one being closed returns
+		close(quit)
+		<-done
+	}
+
+	if n := atomic.LoadInt64(&l.undelivered) - undelivered; n > 0 {
+		errs = append(errs, fmt.Sprintf("failed to deliver %d messages while shutting down", n))
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// drain flushes kafka until the deadline and closes the outputs, returning the first error.
+// Draining stops once quit is closed.
+func (l *Sandbox) drain(deadline time.Time, quit <-chan struct{}) (err error) {
+	if l.kafka != nil {
+		timeout := int(time.Until(deadline) / time.Millisecond)
+		if unflushed := l.kafka.Flush(timeout); unflushed > 0 {
+			err = fmt.Errorf("failed to flush %d kafka messages", unflushed)
+		}
+	}
+	if e := l.closeOutputs(quit); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// closeOutputs shuts down the outputs and returns the first error returned by them.
+// Once quit is closed, the outputs that are not closed yet are abandoned.
+func (l *Sandbox) closeOutputs(quit <-chan struct{}) (err error) {
+	closeOutput := func(close func() error) {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		if e := close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
 	if l.kafka != nil {
-		l.flushKafka()
-		l.kafka.Close()
+		closeOutput(func() error {
+			l.kafka.Close()
+			return nil
+		})
 		l.kafka = nil
 	}
 
 	l.stopTicker()
 
+	if l.loki != nil {
+		closeOutput(l.loki.Close)
+		l.loki = nil
+	}
+
+	if l.splunk != nil {
+		closeOutput(l.splunk.Close)
+		l.splunk = nil
+	}
+
+	if l.influx != nil {
+		closeOutput(l.influx.Close)
+		l.influx = nil
+	}
+
+	if l.gelf != nil {
+		closeOutput(l.gelf.Close)
+		l.gelf = nil
+	}
+
+	if l.syslog != nil {
+		closeOutput(l.syslog.Close)
+		l.syslog = nil
+	}
+
+	if l.statsd != nil {
+		closeOutput(l.statsd.Close)
+		l.statsd = nil
+	}
+
+	closeOutput(func() error {
+		l.closeSockets()
+		return nil
+	})
+	l.sockets = nil
+	l.sinks = nil
+
 	if l.http != nil {
-		l.http.Close()
-		close(l.httpErrors)
+		// the errors channel is only closed with the client, as an abandoned client can still send errors
+		closeOutput(func() error {
+			err := l.http.Close()
+			close(l.httpErrors)
+			return err
+		})
 		l.httpErrors = nil
 		l.http = nil
 	}
 
 	// marks sandbox as uninitialized
+	l.luaLock.Lock()
 	l.state = nil
+	l.luaLock.Unlock()
+	return
 }
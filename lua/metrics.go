@@ -0,0 +1,183 @@
+package lua
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/metrics"
+)
+
+// pipelineLabel is the label set to the name of the pipeline of the sandbox in all its metrics
+const pipelineLabel = "pipeline"
+
+var (
+	metricOnLogCalls = metrics.Default.NewCounterVec("logd_lua_on_log_calls_total",
+		"Calls to logd.on_log", pipelineLabel)
+	metricOnLogDuration = metrics.Default.NewHistogramVec("logd_lua_on_log_duration_seconds",
+		"Duration of the calls to logd.on_log", metrics.ExponentialBuckets(0.000001, 4, 10), pipelineLabel)
+	metricErrors = metrics.Default.NewCounterVec("logd_lua_errors_total",
+		"Lua runtime errors thrown by the script hooks", pipelineLabel, "hook")
+	metricKafkaProduced = metrics.Default.NewCounterVec("logd_kafka_messages_produced_total",
+		"Messages produced to kafka", pipelineLabel)
+	metricKafkaFailed = metrics.Default.NewCounterVec("logd_kafka_messages_failed_total",
+		"Messages that could not be delivered to kafka", pipelineLabel)
+	metricKafkaQueue = metrics.Default.NewGaugeVec("logd_kafka_queue_messages",
+		"Messages produced to kafka waiting for their delivery report", pipelineLabel)
+)
+
+// Metrics is the registry of the metrics defined by the Lua scripts. Metrics are kept
+// when the sandbox is initialized again so counters are not reset by script reloads.
+// A metric is shared by all the pipelines that use its name, which must use the same
+// type and labels; their values are told apart by the pipeline label.
+var Metrics = metrics.NewRegistry()
+
+// reservedMetricPrefix is the prefix of the logd internal metrics
+const reservedMetricPrefix = "logd_"
+
+// sandboxMetrics are the internal metrics of the pipeline of a sandbox
+type sandboxMetrics struct {
+	onLogCalls    *metrics.Counter
+	onLogDuration *metrics.Histogram
+	kafkaProduced *metrics.Counter
+	kafkaFailed   *metrics.Counter
+	kafkaQueue    *metrics.Gauge
+}
+
+func newSandboxMetrics(pipeline string) *sandboxMetrics {
+	return &sandboxMetrics{
+		onLogCalls:    metricOnLogCalls.WithLabelValues(pipeline),
+		onLogDuration: metricOnLogDuration.WithLabelValues(pipeline),
+		kafkaProduced: metricKafkaProduced.WithLabelValues(pipeline),
+		kafkaFailed:   metricKafkaFailed.WithLabelValues(pipeline),
+		kafkaQueue:    metricKafkaQueue.WithLabelValues(pipeline),
+	}
+}
+
+func (m *sandboxMetrics) observeOnLog(start time.Time) {
+	m.onLogCalls.Inc()
+	m.onLogDuration.Observe(time.Since(start).Seconds())
+}
+
+func getArgNumber(l *lua.State, i int, fn string) float64 {
+	arg, ok := l.ToNumber(i)
+	if !ok {
+		panic(fmt.Errorf(
+			"%d argument must be a number in call to builtin '%s' function: found %s",
+			i, fn, l.TypeOf(i)))
+	}
+	return arg
+}
+
+func getArgMetricName(l *lua.State, i int, fn string) string {
+	name := getArgString(l, i, fn)
+	if strings.HasPrefix(name, reservedMetricPrefix) {
+		lua.Errorf(l, "%s: metric names starting with '%s' are reserved: found '%s'", fn, reservedMetricPrefix, name)
+	}
+	return name
+}
+
+// getOptionalArgLabels returns the label names of the table at index i sorted by name, and their values
+func getOptionalArgLabels(l *lua.State, i int, fn string) (names, values []string) {
+	if l.IsNoneOrNil(i) {
+		return
+	}
+	if !l.IsTable(i) {
+		panic(fmt.Errorf(
+			"%d argument must be a table in call to builtin '%s' function: found %s",
+			i, fn, l.TypeOf(i)))
+	}
+	labels := make(map[string]string)
+	l.PushNil()
+	for l.Next(i) {
+		name := getTableKey(l, fn)
+		if name == pipelineLabel {
+			lua.Errorf(l, "%s: the '%s' label is reserved", fn, pipelineLabel)
+		}
+		labels[name] = lua.CheckString(l, -1)
+		names = append(names, name)
+		l.Pop(1)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values = append(values, labels[name])
+	}
+	return
+}
+
+// getMetricLabels returns the label names and values of the table at index i preceded
+// by the pipeline label, which is set to the name of the pipeline of the sandbox
+func getMetricLabels(l *lua.State, i int, fn string) (names, values []string) {
+	names, values = getOptionalArgLabels(l, i, fn)
+	names = append([]string{pipelineLabel}, names...)
+	values = append([]string{getStateSandbox(l).pipeline}, values...)
+	return
+}
+
+// getOptionalArgBuckets returns the bucket upper bounds of the array at index i
+func getOptionalArgBuckets(l *lua.State, i int, fn string) []float64 {
+	if l.IsNoneOrNil(i) {
+		return metrics.DefaultBuckets
+	}
+	if !l.IsTable(i) {
+		panic(fmt.Errorf(
+			"%d argument must be an array of numbers in call to builtin '%s' function: found %s",
+			i, fn, l.TypeOf(i)))
+	}
+	buckets := make([]float64, l.RawLength(i))
+	for j := range buckets {
+		l.RawGetInt(i, j+1)
+		buckets[j] = lua.CheckNumber(l, -1)
+		l.Pop(1)
+	}
+	return buckets
+}
+
+// luaCounterAdd increments the counter with the given name and labels, creating it if it does not exist.
+// lua signature is function counter_add(name, value [, labels])
+func luaCounterAdd(l *lua.State) int {
+	name := getArgMetricName(l, 1, luaNameCounterAddFn)
+	value := getArgNumber(l, 2, luaNameCounterAddFn)
+	names, values := getMetricLabels(l, 3, luaNameCounterAddFn)
+	if value < 0 {
+		lua.Errorf(l, "%s: counters can only increase: found %f", luaNameCounterAddFn, value)
+	}
+	counter, err := Metrics.CounterVec(name, "", names...)
+	if err != nil {
+		lua.Errorf(l, "%s: %s", luaNameCounterAddFn, err)
+	}
+	counter.WithLabelValues(values...).Add(value)
+	return 0
+}
+
+// luaGaugeSet sets the gauge with the given name and labels, creating it if it does not exist.
+// lua signature is function gauge_set(name, value [, labels])
+func luaGaugeSet(l *lua.State) int {
+	name := getArgMetricName(l, 1, luaNameGaugeSetFn)
+	value := getArgNumber(l, 2, luaNameGaugeSetFn)
+	names, values := getMetricLabels(l, 3, luaNameGaugeSetFn)
+	gauge, err := Metrics.GaugeVec(name, "", names...)
+	if err != nil {
+		lua.Errorf(l, "%s: %s", luaNameGaugeSetFn, err)
+	}
+	gauge.WithLabelValues(values...).Set(value)
+	return 0
+}
+
+// luaHistogramObserve adds an observation to the histogram with the given name and labels. If the
+// histogram does not exist, it is created with the given bucket upper bounds or with the default ones.
+// lua signature is function histogram_observe(name, value [, labels [, buckets]])
+func luaHistogramObserve(l *lua.State) int {
+	name := getArgMetricName(l, 1, luaNameHistogramFn)
+	value := getArgNumber(l, 2, luaNameHistogramFn)
+	names, values := getMetricLabels(l, 3, luaNameHistogramFn)
+	buckets := getOptionalArgBuckets(l, 4, luaNameHistogramFn)
+	histogram, err := Metrics.HistogramVec(name, "", buckets, names...)
+	if err != nil {
+		lua.Errorf(l, "%s: %s", luaNameHistogramFn, err)
+	}
+	histogram.WithLabelValues(values...).Observe(value)
+	return 0
+}
@@ -0,0 +1,61 @@
+package lua
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func TestMetricsSharedByPipelines(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, "metrics.lua", testScriptHeader+`
+function logd.on_log(logptr)
+	logd.counter_add("test_shared_total", 1, {level = "info"})
+end
+`)
+	other := writeScript(t, dir, "other.lua", testScriptHeader+`
+function logd.on_log(logptr)
+	local ok, err = pcall(logd.counter_add, "test_shared_total", 1, {source = "app"})
+	failure = err
+end
+`)
+
+	counter, err := Metrics.CounterVec("test_shared_total", "", pipelineLabel, "level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := counter.WithLabelValues("first", "info").Value(), counter.WithLabelValues("second", "info").Value()
+	for pipeline, calls := range map[string]int{"first": 1, "second": 2} {
+		l, err := NewSandboxConfig(pipeline, script, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < calls; i++ {
+			if err = l.CallOnLog(logging.NewLog()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.Close()
+	}
+	// the values of every pipeline are labeled with its name
+	for pipeline, expected := range map[string]float64{"first": first + 1, "second": second + 2} {
+		if value := counter.WithLabelValues(pipeline, "info").Value(); value != expected {
+			t.Errorf("expected %s counter to be %v found %v", pipeline, expected, value)
+		}
+	}
+
+	// a metric with the same name but different labels is not shared
+	l, err := NewSandboxConfig("third", other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = l.CallOnLog(logging.NewLog()); err != nil {
+		t.Fatal(err)
+	}
+	if failure := globalString(l, "failure"); failure == "" {
+		t.Errorf("expected error using the metric with different labels")
+	}
+}
@@ -0,0 +1,45 @@
+package lua
+
+import (
+	"github.com/ernestrc/logd/logging"
+)
+
+// sink is an output that can be configured to receive every log
+// after it has been processed by the on_log hook
+type sink interface {
+	Push(*logging.Log) error
+}
+
+type namedSink struct {
+	name string
+	sink
+}
+
+// setSink adds or removes the sink with the given name.
+// sinks slice is copied so it can be read by writeSinks without holding the lua lock while pushing.
+// caller must take care of synchronizing concurrent access to state
+func (l *Sandbox) setSink(name string, s sink, enabled bool) {
+	sinks := make([]namedSink, 0, len(l.sinks)+1)
+	for _, n := range l.sinks {
+		if n.name != name {
+			sinks = append(sinks, n)
+		}
+	}
+	if enabled {
+		sinks = append(sinks, namedSink{name, s})
+	}
+	l.sinks = sinks
+}
+
+func (l *Sandbox) writeSinks(lg *logging.Log) (err error) {
+	l.luaLock.Lock()
+	sinks := l.sinks
+	l.luaLock.Unlock()
+
+	for _, s := range sinks {
+		if err = s.Push(lg); err != nil {
+			return
+		}
+	}
+	return
+}
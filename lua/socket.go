@@ -0,0 +1,122 @@
+package lua
+
+import (
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const socketSinkName = "socket"
+
+// luaSocketWrite will write the payload to the socket at the given address. If payload is
+// a pointer to a Log structure, it is serialized with the configured format. Messages are buffered
+// and written asynchronously; call blocks only if the buffer is full.
+// lua signature is function socket_write(address, payload)
+func luaSocketWrite(l *lua.State) int {
+	address := getArgString(l, 1, luaNameSocketWriteFn)
+	sandbox := getStateSandbox(l)
+
+	w, err := sandbox.getSocket(address)
+	if err != nil {
+		lua.Errorf(l, "socket initialization error: %s", err)
+		panic("unreachable")
+	}
+
+	if l.IsUserData(2) {
+		log := getArgLogPtr(l, 2, luaNameSocketWriteFn)
+		// Avoid resource contention. See luaHTTPPost
+		sandbox.luaLock.Unlock()
+		defer sandbox.luaLock.Lock()
+		err = w.Push(log)
+	} else {
+		payload := getArgString(l, 2, luaNameSocketWriteFn)
+		sandbox.luaLock.Unlock()
+		defer sandbox.luaLock.Lock()
+		err = w.Write([]byte(payload))
+	}
+	if err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+// getSocket returns the writer for the given address, initializing it if necessary
+func (l *Sandbox) getSocket(address string) (w *output.Net, err error) {
+	if w = l.sockets[address]; w != nil {
+		return
+	}
+	cfg := *l.socketConfig
+	cfg.Address = address
+	if w, err = output.NewNet(&cfg); err != nil {
+		return
+	}
+	if l.sockets == nil {
+		l.sockets = make(map[string]*output.Net)
+	}
+	l.sockets[address] = w
+	return
+}
+
+// re-initializes all the socket writers with the given configuration, which is only kept if it is
+// valid. The configuration of every writer is validated first so none of them is re-initialized if one is not valid.
+func (l *Sandbox) reloadSockets(next output.NetConfig) (err error) {
+	if err = output.ValidateNetOptions(&next); err != nil {
+		return
+	}
+	configs := make(map[string]*output.NetConfig, len(l.sockets))
+	for address := range l.sockets {
+		cfg := next
+		cfg.Address = address
+		if err = output.ValidateNetConfig(&cfg); err != nil {
+			return
+		}
+		configs[address] = &cfg
+	}
+	*l.socketConfig = next
+	for address, w := range l.sockets {
+		if err = w.Init(configs[address]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *Sandbox) closeSockets() {
+	for _, w := range l.sockets {
+		w.Close()
+	}
+	l.sockets = nil
+}
+
+func (l *Sandbox) setSocketFormat(format string) error {
+	cfg := *l.socketConfig
+	cfg.Format = format
+	return l.reloadSockets(cfg)
+}
+
+func (l *Sandbox) setSocketFraming(framing string) error {
+	cfg := *l.socketConfig
+	cfg.Framing = framing
+	return l.reloadSockets(cfg)
+}
+
+func (l *Sandbox) setSocketBuffer(size int) error {
+	cfg := *l.socketConfig
+	cfg.BufferSize = size
+	return l.reloadSockets(cfg)
+}
+
+// setSocketSink forwards every log to the socket at the given address.
+// An empty address disables the sink.
+func (l *Sandbox) setSocketSink(address string) (err error) {
+	if address == "" {
+		l.setSink(socketSinkName, nil, false)
+		return
+	}
+	var w *output.Net
+	if w, err = l.getSocket(address); err != nil {
+		return
+	}
+	l.setSink(socketSinkName, w, true)
+	return
+}
@@ -0,0 +1,33 @@
+package lua
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSocketConfigRejected(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, "socket.lua", testScriptHeader+`
+function logd.on_log(logptr) end
+`)
+	l, err := NewSandbox(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// values are rejected even if no socket has been opened yet
+	for key, value := range map[string]interface{}{
+		"socket.format":  "xml",
+		"socket.framing": "none",
+		"socket.buffer":  0,
+	} {
+		if err = l.SetConfig(key, value); err == nil {
+			t.Errorf("expected %s = %v to be rejected", key, value)
+		}
+	}
+	if cfg := *l.socketConfig; cfg.Format != "text" || cfg.Framing != "newline" || cfg.BufferSize != 1000 {
+		t.Errorf("expected rejected values not to be kept: found %+v", cfg)
+	}
+}
@@ -0,0 +1,118 @@
+package lua
+
+import (
+	"bytes"
+	"time"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const splunkSinkName = "splunk"
+
+// luaLogSplunk will serialize the log and return it as a Splunk HTTP Event Collector event.
+// lua signature is function log_splunk(logptr) str
+func luaLogSplunk(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameLogSplunkFn)
+	sandbox := getStateSandbox(l)
+	var buf bytes.Buffer
+	output.WriteSplunkEvent(&buf, log, sandbox.splunkConfig)
+	l.PushString(buf.String())
+	return 1
+}
+
+// luaSplunkSend will add the log to the current Splunk batch. Batches are sent
+// asynchronously via the HTTP client when batch size or wait time are reached.
+// lua signature is function splunk_send(logptr)
+func luaSplunkSend(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameSplunkSendFn)
+	sandbox := getStateSandbox(l)
+
+	if sandbox.splunk == nil {
+		if err := sandbox.initSplunk(); err != nil {
+			lua.Errorf(l, "splunk initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+
+	// Avoid resource contention. See luaHTTPPost
+	sandbox.luaLock.Unlock()
+	defer sandbox.luaLock.Lock()
+	if err := sandbox.splunk.Push(log); err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+func (l *Sandbox) initSplunk() (err error) {
+	if l.http == nil {
+		if err = l.initHTTP(); err != nil {
+			return
+		}
+	}
+	l.splunk, err = output.NewSplunk(l.splunkConfig, l.http)
+	return
+}
+
+// re-initializes Splunk client if it is running so new configuration takes effect
+func (l *Sandbox) reloadSplunk() (err error) {
+	if l.splunk != nil {
+		err = l.splunk.Init(l.splunkConfig, l.http)
+	}
+	return
+}
+
+func (l *Sandbox) setSplunkURL(url string) error {
+	l.splunkConfig.URL = url
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkToken(token string) error {
+	l.splunkConfig.Token = token
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkHost(host string) error {
+	l.splunkConfig.Host = host
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkSource(source string) error {
+	l.splunkConfig.Source = source
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkSourceType(sourceType string) error {
+	l.splunkConfig.SourceType = sourceType
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkIndex(index string) error {
+	l.splunkConfig.Index = index
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkBatchSize(size int) error {
+	l.splunkConfig.BatchSize = size
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkBatchWait(waitStr string) (err error) {
+	var wait time.Duration
+	if wait, err = time.ParseDuration(waitStr); err != nil {
+		return
+	}
+	l.splunkConfig.BatchWait = wait
+	return l.reloadSplunk()
+}
+
+func (l *Sandbox) setSplunkSink(enabled bool) (err error) {
+	if enabled && l.splunk == nil {
+		if err = l.initSplunk(); err != nil {
+			return
+		}
+	}
+	l.setSink(splunkSinkName, l.splunk, enabled)
+	return
+}
@@ -0,0 +1,163 @@
+package lua
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+// getOptionalArgTags returns the DogStatsD tags of the table at index i sorted. String keys
+// are formatted as "key:value" and array items are used as they are.
+func getOptionalArgTags(l *lua.State, i int, fn string) (tags []string) {
+	if l.IsNoneOrNil(i) {
+		return
+	}
+	if !l.IsTable(i) {
+		panic(fmt.Errorf(
+			"%d argument must be a table in call to builtin '%s' function: found %s",
+			i, fn, l.TypeOf(i)))
+	}
+	l.PushNil()
+	for l.Next(i) {
+		value := lua.CheckString(l, -1)
+		// tags of the array part of the table have no key
+		if l.TypeOf(-2) == lua.TypeNumber {
+			tags = append(tags, value)
+		} else {
+			tags = append(tags, getTableKey(l, fn)+":"+value)
+		}
+		l.Pop(1)
+	}
+	sort.Strings(tags)
+	return
+}
+
+func getOptionalArgRate(l *lua.State, i int, fn string) float64 {
+	if l.IsNoneOrNil(i) {
+		return 1
+	}
+	rate := getArgNumber(l, i, fn)
+	if rate <= 0 || rate > 1 {
+		lua.Errorf(l, "%s: sample rate must be in (0, 1]: found %f", fn, rate)
+	}
+	return rate
+}
+
+func getStatsd(l *lua.State) *output.StatsD {
+	sandbox := getStateSandbox(l)
+	if sandbox.statsd == nil {
+		if err := sandbox.initStatsd(); err != nil {
+			lua.Errorf(l, "statsd initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+	return sandbox.statsd
+}
+
+func checkStatsdError(l *lua.State, fn string, err error) int {
+	if err != nil {
+		lua.Errorf(l, "%s: %s", fn, err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+// luaStatsdCount increments a StatsD counter.
+// lua signature is function statsd_count(name, value [, tags [, rate]])
+func luaStatsdCount(l *lua.State) int {
+	name := getArgString(l, 1, luaNameStatsdCountFn)
+	value := getArgNumber(l, 2, luaNameStatsdCountFn)
+	tags := getOptionalArgTags(l, 3, luaNameStatsdCountFn)
+	rate := getOptionalArgRate(l, 4, luaNameStatsdCountFn)
+	return checkStatsdError(l, luaNameStatsdCountFn, getStatsd(l).Count(name, value, rate, tags))
+}
+
+// luaStatsdGauge sets a StatsD gauge.
+// lua signature is function statsd_gauge(name, value [, tags])
+func luaStatsdGauge(l *lua.State) int {
+	name := getArgString(l, 1, luaNameStatsdGaugeFn)
+	value := getArgNumber(l, 2, luaNameStatsdGaugeFn)
+	tags := getOptionalArgTags(l, 3, luaNameStatsdGaugeFn)
+	return checkStatsdError(l, luaNameStatsdGaugeFn, getStatsd(l).Gauge(name, value, tags))
+}
+
+// luaStatsdTiming records a duration in milliseconds.
+// lua signature is function statsd_timing(name, ms [, tags [, rate]])
+func luaStatsdTiming(l *lua.State) int {
+	name := getArgString(l, 1, luaNameStatsdTimingFn)
+	ms := getArgNumber(l, 2, luaNameStatsdTimingFn)
+	tags := getOptionalArgTags(l, 3, luaNameStatsdTimingFn)
+	rate := getOptionalArgRate(l, 4, luaNameStatsdTimingFn)
+	return checkStatsdError(l, luaNameStatsdTimingFn, getStatsd(l).Timing(name, ms, rate, tags))
+}
+
+// luaStatsdSet counts the unique values of a StatsD set.
+// lua signature is function statsd_set(name, value [, tags])
+func luaStatsdSet(l *lua.State) int {
+	name := getArgString(l, 1, luaNameStatsdSetFn)
+	value := getArgString(l, 2, luaNameStatsdSetFn)
+	tags := getOptionalArgTags(l, 3, luaNameStatsdSetFn)
+	return checkStatsdError(l, luaNameStatsdSetFn, getStatsd(l).Set(name, value, tags))
+}
+
+// luaStatsdHistogram records a value in a DogStatsD histogram.
+// lua signature is function statsd_histogram(name, value [, tags [, rate]])
+func luaStatsdHistogram(l *lua.State) int {
+	name := getArgString(l, 1, luaNameStatsdHistFn)
+	value := getArgNumber(l, 2, luaNameStatsdHistFn)
+	tags := getOptionalArgTags(l, 3, luaNameStatsdHistFn)
+	rate := getOptionalArgRate(l, 4, luaNameStatsdHistFn)
+	return checkStatsdError(l, luaNameStatsdHistFn, getStatsd(l).Histogram(name, value, rate, tags))
+}
+
+func (l *Sandbox) initStatsd() (err error) {
+	l.statsd, err = output.NewStatsD(l.statsdConfig)
+	return
+}
+
+// re-initializes statsd client if it is running so new configuration takes effect
+func (l *Sandbox) reloadStatsd() (err error) {
+	if l.statsd != nil {
+		err = l.statsd.Init(l.statsdConfig)
+	}
+	return
+}
+
+func (l *Sandbox) setStatsdAddress(address string) error {
+	l.statsdConfig.Address = address
+	return l.reloadStatsd()
+}
+
+func (l *Sandbox) setStatsdPrefix(prefix string) error {
+	l.statsdConfig.Prefix = prefix
+	return l.reloadStatsd()
+}
+
+func (l *Sandbox) setStatsdTags(tagsStr string) error {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(tagsStr, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	l.statsdConfig.Tags = tags
+	return l.reloadStatsd()
+}
+
+func (l *Sandbox) setStatsdFlushInterval(intervalStr string) (err error) {
+	var interval time.Duration
+	if interval, err = time.ParseDuration(intervalStr); err != nil {
+		return
+	}
+	l.statsdConfig.FlushInterval = interval
+	return l.reloadStatsd()
+}
+
+func (l *Sandbox) setStatsdMaxPacketSize(size int) error {
+	l.statsdConfig.MaxPacketSize = size
+	return l.reloadStatsd()
+}
@@ -0,0 +1,160 @@
+package lua
+
+import (
+	"fmt"
+
+	lua "github.com/Shopify/go-lua"
+	"github.com/ernestrc/logd/output"
+)
+
+const syslogSinkName = "syslog"
+
+// optional table fields of syslog_send that override the configured message header
+const (
+	luaSyslogOptFacility = "facility"
+	luaSyslogOptSeverity = "severity"
+	luaSyslogOptHostname = "hostname"
+	luaSyslogOptAppName  = "app_name"
+	luaSyslogOptMsgID    = "msgid"
+)
+
+func getOptionalArgSyslogHeader(l *lua.State, i int, h *output.SyslogHeader, fn string) {
+	if l.IsNoneOrNil(i) {
+		return
+	}
+	if !l.IsTable(i) {
+		panic(fmt.Errorf(
+			"%d argument must be a table in call to builtin '%s' function: found %s",
+			i, fn, l.TypeOf(i)))
+	}
+	l.PushNil()
+	for l.Next(i) {
+		key := getTableKey(l, fn)
+		switch key {
+		case luaSyslogOptFacility:
+			facility, err := output.SyslogFacility(lua.CheckString(l, -1))
+			if err != nil {
+				lua.Errorf(l, "%s: %s", fn, err)
+			}
+			h.Facility = facility
+		case luaSyslogOptSeverity:
+			h.Severity = lua.CheckInteger(l, -1)
+		case luaSyslogOptHostname:
+			h.Hostname = lua.CheckString(l, -1)
+		case luaSyslogOptAppName:
+			h.AppName = lua.CheckString(l, -1)
+		case luaSyslogOptMsgID:
+			h.MsgID = lua.CheckString(l, -1)
+		default:
+			lua.Errorf(l, "%s: unknown option '%s'", fn, key)
+		}
+		l.Pop(1)
+	}
+}
+
+// luaSyslogSend will forward the log to the configured syslog receiver. Messages are buffered
+// and written asynchronously; call blocks only if the buffer is full.
+// lua signature is function syslog_send(logptr [, opts])
+func luaSyslogSend(l *lua.State) int {
+	log := getArgLogPtr(l, 1, luaNameSyslogSendFn)
+	sandbox := getStateSandbox(l)
+
+	if sandbox.syslog == nil {
+		if err := sandbox.initSyslog(); err != nil {
+			lua.Errorf(l, "syslog initialization error: %s", err)
+			panic("unreachable")
+		}
+	}
+
+	h := sandbox.syslog.Header()
+	getOptionalArgSyslogHeader(l, 2, &h, luaNameSyslogSendFn)
+
+	// Avoid resource contention. See luaHTTPPost
+	sandbox.luaLock.Unlock()
+	defer sandbox.luaLock.Lock()
+	if err := sandbox.syslog.Send(log, &h); err != nil {
+		lua.Errorf(l, "%s", err)
+		panic("unreachable")
+	}
+	return 0
+}
+
+func (l *Sandbox) initSyslog() (err error) {
+	l.syslog, err = output.NewSyslog(l.syslogConfig)
+	return
+}
+
+// re-initializes syslog client if it is running so new configuration takes effect
+func (l *Sandbox) reloadSyslog() (err error) {
+	if l.syslog != nil {
+		err = l.syslog.Init(l.syslogConfig)
+	}
+	return
+}
+
+func (l *Sandbox) setSyslogAddress(address string) error {
+	l.syslogConfig.Address = address
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogFormat(format string) error {
+	l.syslogConfig.Format = format
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogFacility(name string) (err error) {
+	if l.syslogConfig.Facility, err = output.SyslogFacility(name); err != nil {
+		return
+	}
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogHostname(hostname string) error {
+	l.syslogConfig.Hostname = hostname
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogAppName(appName string) error {
+	l.syslogConfig.AppName = appName
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogSDID(id string) error {
+	l.syslogConfig.SDID = id
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogBuffer(size int) error {
+	l.syslogConfig.BufferSize = size
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogTLSCA(path string) error {
+	l.syslogConfig.TLSCA = path
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogTLSCert(path string) error {
+	l.syslogConfig.TLSCert = path
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogTLSKey(path string) error {
+	l.syslogConfig.TLSKey = path
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogTLSInsecure(insecure bool) error {
+	l.syslogConfig.TLSInsecureSkipVerify = insecure
+	return l.reloadSyslog()
+}
+
+func (l *Sandbox) setSyslogSink(enabled bool) (err error) {
+	if enabled && l.syslog == nil {
+		if err = l.initSyslog(); err != nil {
+			return
+		}
+	}
+	l.setSink(syslogSinkName, l.syslog, enabled)
+	return
+}
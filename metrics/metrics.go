@@ -0,0 +1,471 @@
+// Package metrics implements counters, gauges and histograms that can be exposed
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelSep separates label values in the keys of the series of a vector
+const labelSep = "\xff"
+
+// value is a float64 that can be updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter is a value that can only increase
+type Counter struct {
+	v value
+}
+
+// Inc increments the counter by 1
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increments the counter by the given value. Negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+// Gauge is a value that can increase and decrease
+type Gauge struct {
+	v value
+}
+
+// Set sets the gauge to the given value
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+// Add adds the given value, which can be negative, to the gauge
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	// 64-bit atomic values first so they are aligned in 32-bit platforms
+	count uint64
+	sum   value
+	// upper bounds of the buckets in increasing order. +Inf bucket is implicit.
+	buckets []float64
+	counts  []uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(f float64) {
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(f)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns the sum of all the observations
+func (h *Histogram) Sum() float64 {
+	return h.sum.get()
+}
+
+// ExponentialBuckets returns count buckets where the first upper bound is start
+// and every other upper bound is the previous one multiplied by factor
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// DefaultBuckets are buckets suitable for request latencies in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family is a metric with its help, type and series
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	// fn returns the value of gauges whose value is computed when collected
+	fn      func() float64
+	buckets []float64
+	lock    sync.RWMutex
+	series  map[string]interface{}
+}
+
+// get returns the series with the given label values, creating it if it does not exist
+func (f *family) get(values []string) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Errorf("metric %s has %d labels: found %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, labelSep)
+	f.lock.RLock()
+	s, ok := f.series[key]
+	f.lock.RUnlock()
+	if ok {
+		return s
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	switch f.typ {
+	case TypeCounter:
+		s = new(Counter)
+	case TypeGauge:
+		s = new(Gauge)
+	case TypeHistogram:
+		s = newHistogram(f.buckets)
+	}
+	f.series[key] = s
+	return s
+}
+
+// CounterVec is a set of counters with the same name and different label values
+type CounterVec struct {
+	f *family
+}
+
+// WithLabelValues returns the counter with the given label values
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.f.get(values).(*Counter)
+}
+
+// GaugeVec is a set of gauges with the same name and different label values
+type GaugeVec struct {
+	f *family
+}
+
+// WithLabelValues returns the gauge with the given label values
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.f.get(values).(*Gauge)
+}
+
+// HistogramVec is a set of histograms with the same name and different label values
+type HistogramVec struct {
+	f *family
+}
+
+// WithLabelValues returns the histogram with the given label values
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.f.get(values).(*Histogram)
+}
+
+// Registry holds a set of metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	lock     sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default is the registry of the logd internal metrics
+var Default = NewRegistry()
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// register registers the metric. If reuse is set and a metric with the same name, type
+// and labels is already registered, the registered metric is returned instead.
+func (r *Registry) register(f *family, reuse bool) (*family, error) {
+	if !validName(f.name) {
+		return nil, fmt.Errorf("invalid metric name '%s'", f.name)
+	}
+	for _, label := range f.labels {
+		if !validName(label) || strings.Contains(label, ":") || label == "le" {
+			return nil, fmt.Errorf("invalid label name '%s' of metric %s", label, f.name)
+		}
+	}
+	if f.typ == TypeHistogram && !sort.Float64sAreSorted(f.buckets) {
+		return nil, fmt.Errorf("buckets of histogram %s are not sorted", f.name)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if registered, ok := r.families[f.name]; ok {
+		if !reuse || registered.typ != f.typ || registered.fn != nil || !sameLabels(registered.labels, f.labels) {
+			return nil, fmt.Errorf("metric %s already registered as %s with labels %v", f.name, registered.typ, registered.labels)
+		}
+		return registered, nil
+	}
+	f.series = make(map[string]interface{})
+	r.families[f.name] = f
+	return f, nil
+}
+
+func (r *Registry) mustRegister(f *family) *family {
+	f, err := r.register(f, false)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// NewCounter registers a counter. It panics if the name is invalid or already registered.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// NewCounterVec registers a counter with the given labels.
+// It panics if the name is invalid or already registered.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.mustRegister(&family{name: name, help: help, typ: TypeCounter, labels: labels})}
+}
+
+// NewGauge registers a gauge. It panics if the name is invalid or already registered.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// NewGaugeVec registers a gauge with the given labels.
+// It panics if the name is invalid or already registered.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.mustRegister(&family{name: name, help: help, typ: TypeGauge, labels: labels})}
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn when metrics are written.
+// It panics if the name is invalid or already registered.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.mustRegister(&family{name: name, help: help, typ: TypeGauge, fn: fn})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds.
+// It panics if the name is invalid or already registered.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).WithLabelValues()
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds and labels.
+// It panics if the name is invalid or already registered.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.mustRegister(&family{name: name, help: help, typ: TypeHistogram, labels: labels, buckets: buckets})}
+}
+
+// CounterVec returns the counter with the given name and labels, registering it if it does not exist.
+// It returns an error if a metric with the same name but a different type or labels is registered.
+func (r *Registry) CounterVec(name, help string, labels ...string) (*CounterVec, error) {
+	f, err := r.register(&family{name: name, help: help, typ: TypeCounter, labels: labels}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterVec{f}, nil
+}
+
+// GaugeVec returns the gauge with the given name and labels, registering it if it does not exist.
+// It returns an error if a metric with the same name but a different type or labels is registered.
+func (r *Registry) GaugeVec(name, help string, labels ...string) (*GaugeVec, error) {
+	f, err := r.register(&family{name: name, help: help, typ: TypeGauge, labels: labels}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &GaugeVec{f}, nil
+}
+
+// HistogramVec returns the histogram with the given name and labels, registering it with the given
+// buckets if it does not exist. It returns an error if a metric with the same name but a different
+// type or labels is registered.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) (*HistogramVec, error) {
+	f, err := r.register(&family{name: name, help: help, typ: TypeHistogram, labels: labels, buckets: buckets}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &HistogramVec{f}, nil
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// formatLabels formats the labels of the series with the given key. If le is not empty,
+// it is added as the upper bound label of a histogram bucket.
+func formatLabels(names []string, key string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	if len(names) > 0 {
+		values := strings.Split(key, labelSep)
+		for i, name := range names {
+			pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (f *family) write(w *bufio.Writer) {
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	if f.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	f.lock.RLock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	f.lock.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f.lock.RLock()
+		s := f.series[key]
+		f.lock.RUnlock()
+		switch m := s.(type) {
+		case *Counter:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, key, ""), formatFloat(m.Value()))
+		case *Gauge:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, key, ""), formatFloat(m.Value()))
+		case *Histogram:
+			var cumulative uint64
+			for i, le := range m.buckets {
+				cumulative += atomic.LoadUint64(&m.counts[i])
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, key, formatFloat(le)), cumulative)
+			}
+			count := m.Count()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, key, "+Inf"), count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, key, ""), formatFloat(m.Sum()))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, key, ""), count)
+		}
+	}
+}
+
+// WritePrometheus writes all the metrics sorted by name in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(out io.Writer) error {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.lock.RUnlock()
+	sort.Strings(names)
+
+	w := bufio.NewWriter(out)
+	for _, name := range names {
+		r.lock.RLock()
+		f := r.families[name]
+		r.lock.RUnlock()
+		f.write(w)
+	}
+	return w.Flush()
+}
+
+// ServeHTTP writes all the metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	Handler(r).ServeHTTP(w, req)
+}
+
+// Handler returns a handler that writes the metrics of all the given registries
+// in the Prometheus text exposition format
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		for _, r := range registries {
+			if err := r.WritePrometheus(w); err != nil {
+				return
+			}
+		}
+	})
+}
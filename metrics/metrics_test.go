@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testWrite(t *testing.T, r *Registry, expected string) {
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, buf.String())
+	}
+}
+
+func TestCounterGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter")
+	c.Inc()
+	c.Add(2.5)
+	c.Add(-1)
+	g := r.NewGaugeVec("test_gauge", "Test\ngauge", "a", "b")
+	g.WithLabelValues("1", `quoted "value"`).Set(3)
+	g.WithLabelValues("1", `quoted "value"`).Add(-4)
+	g.WithLabelValues("0", "").Set(math.Inf(1))
+	r.NewGaugeFunc("test_func", "Test func", func() float64 { return 42 })
+
+	testWrite(t, r, `# HELP test_func Test func
+# TYPE test_func gauge
+test_func 42
+# HELP test_gauge Test\ngauge
+# TYPE test_gauge gauge
+test_gauge{a="0",b=""} +Inf
+test_gauge{a="1",b="quoted \"value\""} -1
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total 3.5
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Test histogram", []float64{0.1, 1}, "host")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.WithLabelValues("a").Observe(v)
+	}
+	testWrite(t, r, `# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{host="a",le="0.1"} 2
+test_seconds_bucket{host="a",le="1"} 3
+test_seconds_bucket{host="a",le="+Inf"} 4
+test_seconds_sum{host="a"} 2.65
+test_seconds_count{host="a"} 4
+`)
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "")
+	for _, f := range []*family{
+		{name: "test_total", typ: TypeCounter},
+		{name: "0invalid", typ: TypeCounter},
+		{name: "test_gauge", typ: TypeGauge, labels: []string{"le"}},
+		{name: "test_histogram", typ: TypeHistogram, buckets: []float64{1, 0.1}},
+	} {
+		if _, err := r.register(f, false); err == nil {
+			t.Errorf("expected error registering %s", f.name)
+		}
+	}
+}
+
+func TestGetOrRegister(t *testing.T) {
+	r := NewRegistry()
+	c1, err := r.CounterVec("test_total", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := r.CounterVec("test_total", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.WithLabelValues("x").Inc()
+	if v := c2.WithLabelValues("x").Value(); v != 1 {
+		t.Errorf("expected same counter with value 1 found %v", v)
+	}
+	if _, err := r.CounterVec("test_total", "", "b"); err == nil {
+		t.Errorf("expected error registering counter with different labels")
+	}
+	if _, err := r.GaugeVec("test_total", "", "a"); err == nil {
+		t.Errorf("expected error registering gauge with the name of a counter")
+	}
+	if _, err := r.HistogramVec("test_seconds", "", DefaultBuckets); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test counter").Inc()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %s found %s", ContentType, ct)
+	}
+	if !strings.Contains(w.Body.String(), "test_total 1\n") {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	buckets := ExponentialBuckets(1, 10, 3)
+	if len(buckets) != 3 || buckets[0] != 1 || buckets[1] != 10 || buckets[2] != 100 {
+		t.Errorf("unexpected buckets %v", buckets)
+	}
+}
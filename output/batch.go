@@ -0,0 +1,85 @@
+package output
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// batcher accumulates serialized logs and hands them over to the flush function
+// once the batch reaches its max size or once the max wait time has elapsed.
+type batcher struct {
+	lock     sync.Mutex
+	buf      bytes.Buffer
+	size     int
+	sep      byte
+	flush    func(payload string) error
+	quitchan chan struct{}
+}
+
+func newBatcher(size int, wait time.Duration, sep byte, flush func(string) error) *batcher {
+	b := &batcher{size: size, sep: sep, flush: flush, quitchan: make(chan struct{})}
+	go b.flusher(wait)
+	return b
+}
+
+// Write appends the serialized log to the batch. Fn is called with the batch buffer
+// so logs can be serialized without intermediate allocations.
+func (b *batcher) Write(fn func(*bytes.Buffer)) error {
+	b.lock.Lock()
+	if b.buf.Len() > 0 && b.sep != 0 {
+		b.buf.WriteByte(b.sep)
+	}
+	fn(&b.buf)
+	if b.buf.Len() < b.size {
+		b.lock.Unlock()
+		return nil
+	}
+	payload := b.take()
+	b.lock.Unlock()
+	return b.flush(payload)
+}
+
+// caller must hold lock
+func (b *batcher) take() string {
+	payload := b.buf.String()
+	b.buf.Reset()
+	return payload
+}
+
+// Flush hands over the current batch to the flush function if it is not empty
+func (b *batcher) Flush() error {
+	b.lock.Lock()
+	payload := b.take()
+	b.lock.Unlock()
+	if payload == "" {
+		return nil
+	}
+	return b.flush(payload)
+}
+
+func (b *batcher) flusher(wait time.Duration) {
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.WithFields(log.Fields{
+					"tag":   "BatchFlushFailure",
+					"error": err,
+				}).Error()
+			}
+		case <-b.quitchan:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flushing and flushes the current batch
+func (b *batcher) Close() error {
+	close(b.quitchan)
+	return b.Flush()
+}
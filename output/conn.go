@@ -0,0 +1,148 @@
+package output
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDialTimeout = 5 * time.Second
+	initialBackoff     = 100 * time.Millisecond
+	maxBackoff         = 30 * time.Second
+)
+
+// conn is a network connection that is dialed lazily and re-dialed
+// on the next write after a write error.
+type conn struct {
+	lock    sync.Mutex
+	network string
+	address string
+	dial    func(network, address string) (net.Conn, error)
+	c       net.Conn
+}
+
+func newConn(network, address string) *conn {
+	return &conn{network: network, address: address, dial: dialTimeout}
+}
+
+func dialTimeout(network, address string) (net.Conn, error) {
+	return net.DialTimeout(network, address, defaultDialTimeout)
+}
+
+// Write writes b to the connection, dialing it first if it is not connected.
+// If the write fails, the connection is closed so it is re-dialed by the next write.
+func (c *conn) Write(b []byte) (n int, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.c == nil {
+		if c.c, err = c.dial(c.network, c.address); err != nil {
+			return
+		}
+	}
+	if n, err = c.c.Write(b); err != nil {
+		c.c.Close()
+		c.c = nil
+	}
+	return
+}
+
+// Close closes the underlying connection if it is connected
+func (c *conn) Close() (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.c != nil {
+		err = c.c.Close()
+		c.c = nil
+	}
+	return
+}
+
+var errWriterClosed = errors.New("writer is closed")
+
+// bufferedWriter writes frames to a conn from a background goroutine.
+// Failed writes are retried with exponential backoff so frames are buffered
+// while the peer is unreachable. Write blocks when the buffer is full.
+type bufferedWriter struct {
+	conn *conn
+	// lock guards framechan, which is nil once the writer is closed
+	lock      sync.RWMutex
+	framechan chan []byte
+	closing   chan struct{}
+	closeOnce sync.Once
+	quitchan  chan struct{}
+}
+
+func newBufferedWriter(c *conn, size int) *bufferedWriter {
+	w := &bufferedWriter{
+		conn:      c,
+		framechan: make(chan []byte, size),
+		closing:   make(chan struct{}),
+		quitchan:  make(chan struct{}),
+	}
+	go w.writer()
+	return w
+}
+
+// Write enqueues the frame. It blocks if the buffer is full and
+// returns an error if the writer is closed.
+func (w *bufferedWriter) Write(frame []byte) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.framechan == nil {
+		return errWriterClosed
+	}
+	w.framechan <- frame
+	return nil
+}
+
+func (w *bufferedWriter) writer() {
+	for frame := range w.framechan {
+		backoff := initialBackoff
+		for {
+			_, err := w.conn.Write(frame)
+			if err == nil {
+				break
+			}
+			log.WithFields(log.Fields{
+				"tag":     "ConnWriteFailure",
+				"network": w.conn.network,
+				"address": w.conn.address,
+				"error":   err,
+			}).Error()
+
+			select {
+			case <-w.closing:
+				// do not retry if writer is being closed
+			case <-time.After(backoff):
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			break
+		}
+	}
+	w.conn.Close()
+	close(w.quitchan)
+}
+
+// Close will block until all the buffered frames have been written or, if the peer
+// is unreachable, discarded.
+func (w *bufferedWriter) Close() {
+	// failed frames are discarded from now on so writes blocked on a full buffer return
+	w.closeOnce.Do(func() { close(w.closing) })
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.framechan == nil {
+		return
+	}
+	close(w.framechan)
+	<-w.quitchan
+	w.framechan = nil
+}
@@ -0,0 +1,45 @@
+package output
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBufferedWriterClose(t *testing.T) {
+	c := newConn("tcp", "unreachable")
+	c.dial = func(network, address string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}
+	w := newBufferedWriter(c, 1)
+
+	// the writer retries the first frame while the second fills the buffer
+	if err := w.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error)
+	go func() { blocked <- w.Write([]byte("third")) }()
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to discard the frames of an unreachable peer")
+	}
+	// the blocked write either made it into the buffer or found the writer closed
+	if err := <-blocked; err != nil && err != errWriterClosed {
+		t.Errorf("unexpected error %s", err)
+	}
+	if err := w.Write([]byte("closed")); err != errWriterClosed {
+		t.Errorf("expected writes after Close to fail: found %v", err)
+	}
+	w.Close()
+}
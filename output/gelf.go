@@ -0,0 +1,248 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ernestrc/logd/http"
+	"github.com/ernestrc/logd/logging"
+)
+
+const (
+	gelfVersion        = "1.1"
+	gelfChunkHeaderLen = 12
+	gelfMaxChunks      = 128
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GELFConfig is a Graylog Extended Log Format client configuration
+type GELFConfig struct {
+	// Address of the GELF input: udp://host:12201, tcp://host:12201 or http://host:12201/gelf
+	Address string
+	// Host is the GELF host field. It defaults to the machine hostname.
+	Host string
+	// Compress enables gzip compression of UDP messages
+	Compress bool
+	// ChunkSize is the max size of an UDP datagram. Bigger messages are chunked.
+	ChunkSize int
+}
+
+// DefaultGELFConfig is a GELF client config with sane defaults
+var DefaultGELFConfig = GELFConfig{
+	Compress:  true,
+	ChunkSize: 1420,
+}
+
+func validateGELFConfiguration(cfg *GELFConfig) (u *url.URL, err error) {
+	if cfg.Address == "" {
+		err = fmt.Errorf("config error: gelf address is not set")
+		return
+	}
+	if u, err = url.Parse(cfg.Address); err != nil {
+		err = fmt.Errorf("config error: invalid gelf address: %s", err)
+		return
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "http", "https":
+	default:
+		err = fmt.Errorf("config error: gelf address scheme must be one of udp, tcp, http or https: found '%s'", u.Scheme)
+		return
+	}
+	if cfg.ChunkSize <= gelfChunkHeaderLen {
+		err = fmt.Errorf("config error: min gelf chunk size is %d", gelfChunkHeaderLen+1)
+		return
+	}
+	return
+}
+
+// gelfFieldName returns the additional field name for the given key.
+// Field names must match ^[\w\.\-]*$ and "_id" is reserved.
+func gelfFieldName(key string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('_')
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			buf.WriteRune(r)
+		default:
+			buf.WriteByte('_')
+		}
+	}
+	if buf.String() == "_id" {
+		return "__id"
+	}
+	return buf.String()
+}
+
+// WriteGELF serializes the log into a GELF message. Thread, class and properties are
+// written as additional fields. If log has no message, the log string is used as short_message.
+func WriteGELF(buf *bytes.Buffer, lg *logging.Log, cfg *GELFConfig) {
+	host := cfg.Host
+	if host == "" {
+		host = defaultHost
+	}
+	short := lg.Message
+	if short == "" {
+		short = lg.String()
+	}
+
+	buf.WriteString(`{"version":"` + gelfVersion + `"`)
+	writeJSONField(buf, false, "host", host)
+	writeJSONField(buf, false, "short_message", short)
+	buf.WriteString(`,"timestamp":`)
+	writeEpoch(buf, lg)
+	buf.WriteString(`,"level":`)
+	buf.WriteString(strconv.Itoa(Severity(lg.Level)))
+	if lg.Thread != "" {
+		writeJSONField(buf, false, gelfFieldName(logging.KeyThread), lg.Thread)
+	}
+	if lg.Class != "" {
+		writeJSONField(buf, false, gelfFieldName(logging.KeyClass), lg.Class)
+	}
+	for _, p := range lg.Props() {
+		writeJSONField(buf, false, gelfFieldName(p.Key()), p.Value())
+	}
+	buf.WriteByte('}')
+}
+
+// GELF is a client that sends logs to Graylog as GELF messages over UDP, TCP or HTTP.
+type GELF struct {
+	cfg    GELFConfig
+	url    *url.URL
+	conn   *conn
+	client *http.AsyncClient
+	msgID  uint64
+}
+
+// NewGELF allocates enough space to store a GELF client and initializes it.
+// If configuration is nil a default one will be used. Client is only used if address scheme is http or https.
+func NewGELF(cfg *GELFConfig, client *http.AsyncClient) (g *GELF, err error) {
+	g = new(GELF)
+	if err = g.Init(cfg, client); err != nil {
+		g = nil
+		return
+	}
+	return
+}
+
+// Init initializes this GELF client so it is ready for use.
+// Calling Init after it is initialized will call Close first and re-initialize it.
+// The current connection is only closed once cfg is validated.
+func (g *GELF) Init(cfg *GELFConfig, client *http.AsyncClient) (err error) {
+	next := DefaultGELFConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	u, err := validateGELFConfiguration(&next)
+	if err != nil {
+		return
+	}
+	// random message id prefix so ids do not collide between processes
+	var seed [8]byte
+	if _, err = rand.Read(seed[:]); err != nil {
+		return
+	}
+	// a failure to close the previous connection is returned once the client is re-initialized
+	err = g.Close()
+	g.cfg = next
+	g.url = u
+	g.client = client
+	switch g.url.Scheme {
+	case "udp", "tcp":
+		g.conn = newConn(g.url.Scheme, g.url.Host)
+	}
+	g.msgID = binary.BigEndian.Uint64(seed[:])
+	return
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfChunks splits the message in chunks of at most size bytes including the chunk header
+func gelfChunks(msg []byte, id uint64, size int) ([][]byte, error) {
+	if len(msg) <= size {
+		return [][]byte{msg}, nil
+	}
+	data := size - gelfChunkHeaderLen
+	count := (len(msg) + data - 1) / data
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("gelf message of %d bytes exceeds max number of chunks (%d)", len(msg), gelfMaxChunks)
+	}
+	chunks := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * data
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk := make([]byte, gelfChunkHeaderLen, gelfChunkHeaderLen+end-i*data)
+		copy(chunk, gelfChunkMagic)
+		binary.BigEndian.PutUint64(chunk[2:], id)
+		chunk[10] = byte(i)
+		chunk[11] = byte(count)
+		chunks[i] = append(chunk, msg[i*data:end]...)
+	}
+	return chunks, nil
+}
+
+func (g *GELF) sendUDP(msg []byte) (err error) {
+	if g.cfg.Compress {
+		if msg, err = gzipBytes(msg); err != nil {
+			return
+		}
+	}
+	var chunks [][]byte
+	if chunks, err = gelfChunks(msg, atomic.AddUint64(&g.msgID, 1), g.cfg.ChunkSize); err != nil {
+		return
+	}
+	for _, chunk := range chunks {
+		if _, err = g.conn.Write(chunk); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Push serializes the log and sends it. UDP and TCP messages are written synchronously
+// while HTTP messages are submitted to the HTTP client.
+func (g *GELF) Push(lg *logging.Log) (err error) {
+	var buf bytes.Buffer
+	WriteGELF(&buf, lg, &g.cfg)
+
+	switch g.url.Scheme {
+	case "udp":
+		err = g.sendUDP(buf.Bytes())
+	case "tcp":
+		// TCP messages are delimited by a null byte
+		buf.WriteByte(0)
+		_, err = g.conn.Write(buf.Bytes())
+	default:
+		err = g.client.Post(g.cfg.Address, buf.String(), "application/json", -1)
+	}
+	return
+}
+
+// Close closes the network connection if there is one.
+// In order to use again this client instance Init must be used to initialize its resources
+func (g *GELF) Close() (err error) {
+	if g.conn != nil {
+		err = g.conn.Close()
+		g.conn = nil
+	}
+	return
+}
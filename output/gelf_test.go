@@ -0,0 +1,55 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func TestWriteGELF(t *testing.T) {
+	lg := logging.Parse("2017-09-07 14:54:39,474	ERROR	[main]	core.Main	id: 1, done\n")[0]
+	lg.Set("user name", "x")
+	ts, _ := lg.Time()
+
+	var buf bytes.Buffer
+	WriteGELF(&buf, &lg, &GELFConfig{Host: "myhost"})
+
+	expected := `{"version":"1.1","host":"myhost","short_message":"done","timestamp":` + formatEpoch(ts.UnixNano()) +
+		`,"level":3,"_thread":"main","_class":"core.Main","__id":"1","_user_name":"x"}`
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestGELFChunks(t *testing.T) {
+	msg := bytes.Repeat([]byte("a"), 25)
+
+	chunks, err := gelfChunks(msg, 7, 25)
+	if err != nil || len(chunks) != 1 || !bytes.Equal(chunks[0], msg) {
+		t.Errorf("expected message not to be chunked: %v %q", err, chunks)
+	}
+
+	chunks, err = gelfChunks(msg, 7, 22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks found %d", len(chunks))
+	}
+	var joined []byte
+	for i, chunk := range chunks {
+		header := []byte{0x1e, 0x0f, 0, 0, 0, 0, 0, 0, 0, 7, byte(i), 3}
+		if !bytes.Equal(chunk[:gelfChunkHeaderLen], header) {
+			t.Errorf("expected chunk header %x found %x", header, chunk[:gelfChunkHeaderLen])
+		}
+		joined = append(joined, chunk[gelfChunkHeaderLen:]...)
+	}
+	if !bytes.Equal(joined, msg) {
+		t.Errorf("expected chunks to contain '%s' found '%s'", msg, joined)
+	}
+
+	if _, err = gelfChunks(bytes.Repeat(msg, 200), 7, 13); err == nil {
+		t.Errorf("expected error when exceeding max number of chunks")
+	}
+}
@@ -0,0 +1,301 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	stdHttp "net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ernestrc/logd/http"
+	"github.com/ernestrc/logd/logging"
+)
+
+// Influx timestamp precisions
+const (
+	InfluxPrecisionNanoseconds  = "ns"
+	InfluxPrecisionMicroseconds = "us"
+	InfluxPrecisionMilliseconds = "ms"
+	InfluxPrecisionSeconds      = "s"
+)
+
+// influxV1Precision maps precisions to the values of the precision parameter of the v1 write endpoint
+var influxV1Precision = map[string]string{
+	InfluxPrecisionNanoseconds:  "n",
+	InfluxPrecisionMicroseconds: "u",
+	InfluxPrecisionMilliseconds: "ms",
+	InfluxPrecisionSeconds:      "s",
+}
+
+// InfluxConfig is an InfluxDB client configuration
+type InfluxConfig struct {
+	// URL of the InfluxDB server. i.e. http://localhost:8086
+	URL string
+	// Token is sent in the Authorization header if not empty. InfluxDB 1.8 accepts "username:password" tokens.
+	Token string
+	// Database and RetentionPolicy select the v1 write endpoint: /write
+	Database        string
+	RetentionPolicy string
+	// Org and Bucket select the v2 write endpoint: /api/v2/write. Bucket takes precedence over Database.
+	Org    string
+	Bucket string
+	// Measurement is the measurement of the logs that do not have a MeasurementKey property
+	Measurement string
+	// MeasurementKey is the log key whose value is used as measurement
+	MeasurementKey string
+	// Tags are the log keys written as tags
+	Tags []string
+	// Fields are the log keys written as fields. If empty, all the keys that are not tags are written as fields.
+	Fields []string
+	// Precision of the timestamps: ns, us, ms or s
+	Precision string
+	// BatchSize is the maximum number of bytes of lines buffered before sending a batch
+	BatchSize int
+	// BatchWait is the maximum amount of time a line is buffered before sending a batch
+	BatchWait time.Duration
+}
+
+// DefaultInfluxConfig is an InfluxDB client config with sane defaults
+var DefaultInfluxConfig = InfluxConfig{
+	Measurement: "logd",
+	Tags:        []string{logging.KeyLevel},
+	Precision:   InfluxPrecisionNanoseconds,
+	BatchSize:   1024 * 1024,
+	BatchWait:   time.Second,
+}
+
+func validateInfluxConfiguration(cfg *InfluxConfig) (err error) {
+	if cfg.URL == "" {
+		err = fmt.Errorf("config error: influx url is not set")
+		return
+	}
+	if cfg.Bucket == "" && cfg.Database == "" {
+		err = fmt.Errorf("config error: influx bucket or database must be set")
+		return
+	}
+	if cfg.Measurement == "" {
+		err = fmt.Errorf("config error: influx measurement is not set")
+		return
+	}
+	if _, ok := influxV1Precision[cfg.Precision]; !ok {
+		err = fmt.Errorf("config error: influx precision must be one of 'ns', 'us', 'ms' or 's': found '%s'", cfg.Precision)
+		return
+	}
+	if cfg.BatchSize < 1 {
+		err = fmt.Errorf("config error: min influx batch size is 1")
+		return
+	}
+	if cfg.BatchWait <= 0 {
+		err = fmt.Errorf("config error: influx batch wait must be positive")
+		return
+	}
+	return
+}
+
+// influxEscaper escapes measurements, tag keys, tag values and field keys
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// influxStringEscaper escapes string field values
+var influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`)
+
+func writeInfluxField(buf *bytes.Buffer, first bool, key, value string) {
+	if !first {
+		buf.WriteByte(',')
+	}
+	influxEscaper.WriteString(buf, key)
+	buf.WriteByte('=')
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		buf.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+		return
+	}
+	buf.WriteByte('"')
+	influxStringEscaper.WriteString(buf, value)
+	buf.WriteByte('"')
+}
+
+func influxTimestamp(t time.Time, precision string) int64 {
+	ns := t.UnixNano()
+	switch precision {
+	case InfluxPrecisionMicroseconds:
+		return ns / int64(time.Microsecond)
+	case InfluxPrecisionMilliseconds:
+		return ns / int64(time.Millisecond)
+	case InfluxPrecisionSeconds:
+		return ns / int64(time.Second)
+	default:
+		return ns
+	}
+}
+
+// WriteInfluxLine serializes the log as a line of the InfluxDB line protocol. Values that
+// are numbers are written as float fields and the rest as string fields. An error is
+// returned if the log has none of the configured fields as a line requires at least one.
+func WriteInfluxLine(buf *bytes.Buffer, lg *logging.Log, cfg *InfluxConfig) error {
+	measurement := cfg.Measurement
+	if cfg.MeasurementKey != "" {
+		if value, ok := lg.Get(cfg.MeasurementKey); ok && value != "" {
+			measurement = value
+		}
+	}
+	start := buf.Len()
+	influxEscaper.WriteString(buf, measurement)
+
+	// tags sorted by key as recommended by InfluxDB
+	tags := make([]string, len(cfg.Tags))
+	copy(tags, cfg.Tags)
+	sort.Strings(tags)
+	for _, key := range tags {
+		if value, ok := lg.Get(key); ok && value != "" {
+			buf.WriteByte(',')
+			influxEscaper.WriteString(buf, key)
+			buf.WriteByte('=')
+			influxEscaper.WriteString(buf, value)
+		}
+	}
+
+	buf.WriteByte(' ')
+	empty := true
+	if len(cfg.Fields) > 0 {
+		for _, key := range cfg.Fields {
+			if value, ok := lg.Get(key); ok {
+				writeInfluxField(buf, empty, key, value)
+				empty = false
+			}
+		}
+	} else {
+		skip := make(map[string]bool, len(cfg.Tags)+1)
+		for _, key := range cfg.Tags {
+			skip[key] = true
+		}
+		skip[cfg.MeasurementKey] = true
+		for _, key := range []string{logging.KeyLevel, logging.KeyThread, logging.KeyClass} {
+			if value, ok := lg.Get(key); ok && !skip[key] {
+				writeInfluxField(buf, empty, key, value)
+				empty = false
+			}
+		}
+		for _, p := range lg.Props() {
+			if !skip[p.Key()] {
+				writeInfluxField(buf, empty, p.Key(), p.Value())
+				empty = false
+			}
+		}
+		if lg.Message != "" && !skip[logging.KeyMessage] {
+			writeInfluxField(buf, empty, logging.KeyMessage, lg.Message)
+			empty = false
+		}
+	}
+	if empty {
+		buf.Truncate(start)
+		return fmt.Errorf("influx: log has no fields")
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(influxTimestamp(logTime(lg), cfg.Precision), 10))
+	return nil
+}
+
+// influxWriteURL returns the URL of the v2 write endpoint if a bucket is configured or the v1 one otherwise
+func influxWriteURL(cfg *InfluxConfig) string {
+	params := url.Values{}
+	endpoint := "/write"
+	if cfg.Bucket != "" {
+		endpoint = "/api/v2/write"
+		params.Set("bucket", cfg.Bucket)
+		if cfg.Org != "" {
+			params.Set("org", cfg.Org)
+		}
+		params.Set("precision", cfg.Precision)
+	} else {
+		params.Set("db", cfg.Database)
+		if cfg.RetentionPolicy != "" {
+			params.Set("rp", cfg.RetentionPolicy)
+		}
+		params.Set("precision", influxV1Precision[cfg.Precision])
+	}
+	return strings.TrimSuffix(cfg.URL, "/") + endpoint + "?" + params.Encode()
+}
+
+// Influx is a client that batches logs as lines of the InfluxDB line protocol and
+// writes them to InfluxDB via an http.AsyncClient.
+type Influx struct {
+	cfg    InfluxConfig
+	url    string
+	client *http.AsyncClient
+	batch  *batcher
+}
+
+// NewInflux allocates enough space to store an Influx client and initializes it.
+// If configuration is nil a default one will be used.
+func NewInflux(cfg *InfluxConfig, client *http.AsyncClient) (i *Influx, err error) {
+	i = new(Influx)
+	if err = i.Init(cfg, client); err != nil {
+		i = nil
+		return
+	}
+	return
+}
+
+// Init initializes this Influx client so it is ready for use.
+// Calling Init after it is initialized will call Close first, sending all the pending lines, and re-initialize it.
+// An invalid cfg leaves the current batch and its pending lines untouched.
+func (i *Influx) Init(cfg *InfluxConfig, client *http.AsyncClient) (err error) {
+	next := DefaultInfluxConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	if err = validateInfluxConfiguration(&next); err != nil {
+		return
+	}
+	// a failure to submit the pending batch is returned once the client is re-initialized
+	if i.batch != nil {
+		err = i.Close()
+	}
+	i.cfg = next
+	i.url = influxWriteURL(&i.cfg)
+	i.client = client
+	i.batch = newBatcher(i.cfg.BatchSize, i.cfg.BatchWait, '\n', i.send)
+	return
+}
+
+func (i *Influx) send(payload string) error {
+	header := make(stdHttp.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.cfg.Token != "" {
+		header.Set("Authorization", "Token "+i.cfg.Token)
+	}
+	return i.client.PostWithHeader(i.url, payload, header, -1)
+}
+
+// Push adds the log to the current batch.
+// If batch size is reached, the batch is submitted to the HTTP client.
+func (i *Influx) Push(lg *logging.Log) error {
+	var line bytes.Buffer
+	if err := WriteInfluxLine(&line, lg, &i.cfg); err != nil {
+		return err
+	}
+	return i.batch.Write(func(buf *bytes.Buffer) {
+		buf.Write(line.Bytes())
+	})
+}
+
+// Flush submits the current batch to the HTTP client
+func (i *Influx) Flush() error {
+	return i.batch.Flush()
+}
+
+// Close stops the periodic flushing of batches and submits the current batch
+// to the HTTP client. In order to use again this client instance Init must be used to initialize its resources.
+// Calling Close more than once has no effect.
+func (i *Influx) Close() (err error) {
+	if i.batch == nil {
+		return
+	}
+	err = i.batch.Close()
+	i.batch = nil
+	return
+}
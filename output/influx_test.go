@@ -0,0 +1,67 @@
+package output
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func TestWriteInfluxLine(t *testing.T) {
+	lg := logging.Parse("2017-09-07 14:54:39,474	WARN	[main]	core.Main	flow: Publish, latency: 12.5, svc: my api, err: \"a\\b\"\n")[0]
+	ts, _ := lg.Time()
+	cfg := InfluxConfig{
+		Measurement:    "logs",
+		MeasurementKey: "flow",
+		Tags:           []string{"svc", logging.KeyLevel},
+		Precision:      InfluxPrecisionMilliseconds,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteInfluxLine(&buf, &lg, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Publish,level=WARN,svc=my\ api thread="main",class="core.Main",latency=12.5,err="\"a\\b\"" ` +
+		strconv.FormatInt(ts.UnixNano()/1000000, 10)
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestWriteInfluxLineFields(t *testing.T) {
+	lg := logging.NewLog()
+	lg.Set(logging.KeyTimestamp, "2017-09-07 14:54:39,004")
+	lg.Set("a=b", "1")
+	lg.Message = "my message"
+	ts, _ := lg.Time()
+	cfg := InfluxConfig{Measurement: "my,logs", Fields: []string{"a=b", logging.KeyMessage}, Precision: InfluxPrecisionSeconds}
+
+	var buf bytes.Buffer
+	if err := WriteInfluxLine(&buf, lg, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `my\,logs a\=b=1,msg="my message" ` + strconv.FormatInt(ts.Unix(), 10)
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+
+	buf.Reset()
+	cfg.Fields = []string{"missing"}
+	if err := WriteInfluxLine(&buf, lg, &cfg); err == nil || buf.Len() != 0 {
+		t.Errorf("expected error writing log without fields: found '%s'", buf.String())
+	}
+}
+
+func TestInfluxWriteURL(t *testing.T) {
+	cfg := InfluxConfig{URL: "http://localhost:8086/", Database: "logs", Precision: InfluxPrecisionNanoseconds}
+	if u := influxWriteURL(&cfg); u != "http://localhost:8086/write?db=logs&precision=n" {
+		t.Errorf("unexpected v1 url: %s", u)
+	}
+	cfg.Org, cfg.Bucket = "my org", "logs"
+	if u := influxWriteURL(&cfg); u != "http://localhost:8086/api/v2/write?bucket=logs&org=my+org&precision=ns" {
+		t.Errorf("unexpected v2 url: %s", u)
+	}
+}
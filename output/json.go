@@ -0,0 +1,91 @@
+package output
+
+import (
+	"bytes"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+const hex = "0123456789abcdef"
+
+// writeJSONString writes s as a quoted JSON string
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				if c < 0x20 {
+					buf.WriteString(`\u00`)
+					buf.WriteByte(hex[c>>4])
+					buf.WriteByte(hex[c&0xf])
+				} else {
+					buf.WriteByte(c)
+				}
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(`\ufffd`)
+		} else {
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.WriteByte('"')
+}
+
+func writeJSONField(buf *bytes.Buffer, first bool, key, value string) {
+	if !first {
+		buf.WriteByte(',')
+	}
+	writeJSONString(buf, key)
+	buf.WriteByte(':')
+	writeJSONString(buf, value)
+}
+
+// writeJSONLog writes the non empty header fields and properties of the log
+// as the members of a JSON object, without the enclosing braces.
+// It returns true if no member was written.
+func writeJSONLog(buf *bytes.Buffer, lg *logging.Log) (empty bool) {
+	empty = true
+	for _, key := range []string{logging.KeyLevel, logging.KeyThread, logging.KeyClass} {
+		if value, ok := lg.Get(key); ok {
+			writeJSONField(buf, empty, key, value)
+			empty = false
+		}
+	}
+	for _, p := range lg.Props() {
+		writeJSONField(buf, empty, p.Key(), p.Value())
+		empty = false
+	}
+	return
+}
+
+// formatEpoch formats the time as seconds since epoch with millisecond precision
+func formatEpoch(ns int64) string {
+	ms := ns / 1000000
+	frac := strconv.FormatInt(ms%1000, 10)
+	for len(frac) < 3 {
+		frac = "0" + frac
+	}
+	return strconv.FormatInt(ms/1000, 10) + "." + frac
+}
+
+func writeEpoch(buf *bytes.Buffer, lg *logging.Log) {
+	buf.WriteString(formatEpoch(logTime(lg).UnixNano()))
+}
@@ -0,0 +1,41 @@
+package output
+
+import (
+	"strings"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+// syslog severities as defined by RFC 5424
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// Severity maps a log level to its syslog severity. Unknown levels are mapped to SeverityInfo.
+func Severity(level string) int {
+	switch strings.ToUpper(level) {
+	case "FATAL", "PANIC", "EMERG", "EMERGENCY":
+		return SeverityEmergency
+	case "ALERT":
+		return SeverityAlert
+	case "CRIT", "CRITICAL":
+		return SeverityCritical
+	case logging.Error, "ERR":
+		return SeverityError
+	case logging.Warn, "WARNING":
+		return SeverityWarning
+	case "NOTICE":
+		return SeverityNotice
+	case logging.Debug, logging.Trace:
+		return SeverityDebug
+	default:
+		return SeverityInfo
+	}
+}
@@ -0,0 +1,331 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stdHttp "net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ernestrc/logd/http"
+	"github.com/ernestrc/logd/logging"
+	"github.com/golang/snappy"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// LokiFormatJSON pushes batches using the JSON push API
+	LokiFormatJSON = "json"
+	// LokiFormatProtobuf pushes batches using the snappy compressed protobuf push API
+	LokiFormatProtobuf = "protobuf"
+)
+
+const lokiTenantHeader = "X-Scope-OrgID"
+
+// LokiConfig is a Loki client configuration
+type LokiConfig struct {
+	// URL of the push API endpoint. i.e. http://localhost:3100/loki/api/v1/push
+	URL string
+	// TenantID is sent in the X-Scope-OrgID header if not empty
+	TenantID string
+	// Labels are the log keys used to group logs into streams
+	Labels []string
+	// Format is either LokiFormatJSON or LokiFormatProtobuf
+	Format string
+	// BatchSize is the maximum number of bytes of log lines buffered before pushing a batch
+	BatchSize int
+	// BatchWait is the maximum amount of time a log is buffered before pushing a batch
+	BatchWait time.Duration
+}
+
+// DefaultLokiConfig is a Loki client config with sane defaults
+var DefaultLokiConfig = LokiConfig{
+	Labels:    []string{logging.KeyLevel, logging.KeyClass},
+	Format:    LokiFormatJSON,
+	BatchSize: 1024 * 1024,
+	BatchWait: time.Second,
+}
+
+func validateLokiConfiguration(cfg *LokiConfig) (err error) {
+	if cfg.URL == "" {
+		err = fmt.Errorf("config error: loki url is not set")
+		return
+	}
+	if cfg.Format != LokiFormatJSON && cfg.Format != LokiFormatProtobuf {
+		err = fmt.Errorf("config error: loki format must be one of '%s' or '%s': found '%s'",
+			LokiFormatJSON, LokiFormatProtobuf, cfg.Format)
+		return
+	}
+	if cfg.BatchSize < 1 {
+		err = fmt.Errorf("config error: min loki batch size is 1")
+		return
+	}
+	if cfg.BatchWait <= 0 {
+		err = fmt.Errorf("config error: loki batch wait must be positive")
+		return
+	}
+	return
+}
+
+type lokiEntry struct {
+	time.Time
+	line string
+}
+
+type lokiStream struct {
+	labels   []lokiLabel
+	selector string
+	entries  []lokiEntry
+}
+
+// Loki is a client that groups logs into streams, batches them and
+// pushes them to Loki via an http.AsyncClient.
+type Loki struct {
+	cfg      LokiConfig
+	client   *http.AsyncClient
+	lock     sync.Mutex
+	streams  map[string]*lokiStream
+	size     int
+	quitchan chan struct{}
+}
+
+// NewLoki allocates enough space to store a Loki client and initializes it.
+// If configuration is nil a default one will be used.
+func NewLoki(cfg *LokiConfig, client *http.AsyncClient) (k *Loki, err error) {
+	k = new(Loki)
+	if err = k.Init(cfg, client); err != nil {
+		k = nil
+		return
+	}
+	return
+}
+
+// Init initializes this Loki client so it is ready for use.
+// Calling Init after it is initialized will call Close first, pushing all the pending logs, and re-initialize it.
+// The pending logs and the running flusher are left untouched if cfg is not valid.
+func (k *Loki) Init(cfg *LokiConfig, client *http.AsyncClient) (err error) {
+	next := DefaultLokiConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	if err = validateLokiConfiguration(&next); err != nil {
+		return
+	}
+	// a failure to submit the pending batch is returned once the client is re-initialized
+	err = k.Close()
+
+	k.lock.Lock()
+	k.cfg = next
+	k.client = client
+	k.streams = make(map[string]*lokiStream)
+	k.size = 0
+	k.quitchan = make(chan struct{})
+	go k.flusher(k.cfg.BatchWait, k.quitchan)
+	k.lock.Unlock()
+	return
+}
+
+func lokiLabelName(key string) string {
+	var buf bytes.Buffer
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			buf.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			buf.WriteRune(r)
+		default:
+			buf.WriteByte('_')
+		}
+	}
+	return buf.String()
+}
+
+type lokiLabel struct {
+	name  string
+	value string
+}
+
+// lokiLabels returns the labels of the stream the log belongs to sorted by name
+func lokiLabels(keys []string, lg *logging.Log) []lokiLabel {
+	labels := make([]lokiLabel, 0, len(keys))
+	for _, key := range keys {
+		value, ok := lg.Get(key)
+		if !ok || value == "" {
+			continue
+		}
+		labels = append(labels, lokiLabel{lokiLabelName(key), value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+// lokiSelector returns the stream selector of the labels, i.e. {class="Main", level="INFO"}
+func lokiSelector(labels []lokiLabel) string {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(l.name)
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Quote(l.value))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// Push adds the log to the current batch.
+// If batch size is reached, the batch is submitted to the HTTP client.
+func (k *Loki) Push(lg *logging.Log) error {
+	entry := lokiEntry{logTime(lg), lg.String()}
+
+	k.lock.Lock()
+	labels := lokiLabels(k.cfg.Labels, lg)
+	selector := lokiSelector(labels)
+	s, ok := k.streams[selector]
+	if !ok {
+		s = &lokiStream{labels: labels, selector: selector}
+		k.streams[selector] = s
+	}
+	s.entries = append(s.entries, entry)
+	k.size += len(entry.line)
+	if k.size < k.cfg.BatchSize {
+		k.lock.Unlock()
+		return nil
+	}
+	batch := k.takeBatch()
+	k.lock.Unlock()
+
+	return batch.push()
+}
+
+// lokiBatch are the streams pushed in a single request with the
+// configuration and the HTTP client of the Loki client when it was taken
+type lokiBatch struct {
+	streams []*lokiStream
+	cfg     LokiConfig
+	client  *http.AsyncClient
+}
+
+// caller must hold lock
+func (k *Loki) takeBatch() *lokiBatch {
+	batch := &lokiBatch{make([]*lokiStream, 0, len(k.streams)), k.cfg, k.client}
+	for _, s := range k.streams {
+		batch.streams = append(batch.streams, s)
+	}
+	k.streams = make(map[string]*lokiStream)
+	k.size = 0
+	return batch
+}
+
+func encodeLokiJSON(batch []*lokiStream) (string, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make([]jsonStream, len(batch))
+	for i, s := range batch {
+		streams[i].Stream = make(map[string]string, len(s.labels))
+		for _, l := range s.labels {
+			streams[i].Stream[l.name] = l.value
+		}
+		streams[i].Values = make([][2]string, len(s.entries))
+		for j, e := range s.entries {
+			streams[i].Values[j] = [2]string{strconv.FormatInt(e.UnixNano(), 10), e.line}
+		}
+	}
+	b, err := json.Marshal(struct {
+		Streams []jsonStream `json:"streams"`
+	}{streams})
+	return string(b), err
+}
+
+// encodeLokiProtobuf encodes the batch as a snappy compressed logproto.PushRequest
+func encodeLokiProtobuf(batch []*lokiStream) string {
+	var req, stream, entry, ts []byte
+	for _, s := range batch {
+		stream = protoAppendString(stream[:0], 1, s.selector)
+		for _, e := range s.entries {
+			ts = protoAppendInt(ts[:0], 1, e.Unix())
+			ts = protoAppendInt(ts, 2, int64(e.Nanosecond()))
+			entry = protoAppendMessage(entry[:0], 1, ts)
+			entry = protoAppendString(entry, 2, e.line)
+			stream = protoAppendMessage(stream, 2, entry)
+		}
+		req = protoAppendMessage(req, 1, stream)
+	}
+	return string(snappy.Encode(nil, req))
+}
+
+func (b *lokiBatch) push() (err error) {
+	if len(b.streams) == 0 {
+		return
+	}
+	// entries of a stream are rejected by Loki if they are out of order
+	for _, s := range b.streams {
+		entries := s.entries
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Before(entries[j].Time) })
+	}
+
+	header := make(stdHttp.Header)
+	if b.cfg.TenantID != "" {
+		header.Set(lokiTenantHeader, b.cfg.TenantID)
+	}
+
+	var payload string
+	switch b.cfg.Format {
+	case LokiFormatProtobuf:
+		header.Set("Content-Type", "application/x-protobuf")
+		payload = encodeLokiProtobuf(b.streams)
+	default:
+		header.Set("Content-Type", "application/json")
+		if payload, err = encodeLokiJSON(b.streams); err != nil {
+			return
+		}
+	}
+
+	return b.client.PostWithHeader(b.cfg.URL, payload, header, -1)
+}
+
+func (k *Loki) flusher(wait time.Duration, quitchan chan struct{}) {
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := k.Flush(); err != nil {
+				log.WithFields(log.Fields{
+					"tag":   "LokiFlushFailure",
+					"error": err,
+				}).Error()
+			}
+		case <-quitchan:
+			return
+		}
+	}
+}
+
+// Flush submits the current batch to the HTTP client
+func (k *Loki) Flush() error {
+	k.lock.Lock()
+	batch := k.takeBatch()
+	k.lock.Unlock()
+	return batch.push()
+}
+
+// Close stops the periodic flushing of batches and submits the current batch
+// to the HTTP client. In order to use again this client instance Init must be used to initialize its resources.
+// Calling Close more than once only flushes the current batch.
+func (k *Loki) Close() error {
+	k.lock.Lock()
+	if k.quitchan != nil {
+		close(k.quitchan)
+		k.quitchan = nil
+	}
+	k.lock.Unlock()
+	return k.Flush()
+}
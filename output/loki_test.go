@@ -0,0 +1,205 @@
+package output
+
+import (
+	"fmt"
+	"io/ioutil"
+	stdHttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ernestrc/logd/http"
+	"github.com/ernestrc/logd/logging"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields returns the fields of the protocol buffers message by number
+func protoFields(t *testing.T, msg []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(msg)
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(msg)
+			value = []byte(strconv.FormatUint(v, 10))
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		fields[num] = append(fields[num], value)
+	}
+	return fields
+}
+
+func TestLokiLabels(t *testing.T) {
+	lg := logging.NewLog()
+	lg.Level = "INFO"
+	lg.Class = "core.Main"
+	lg.Set("service.name", "api")
+	lg.Set("1host", "x\"y")
+
+	labels := lokiLabels([]string{"level", "class", "service.name", "1host", "missing"}, lg)
+	expected := `{_host="x\"y", class="core.Main", level="INFO", service_name="api"}`
+	if selector := lokiSelector(labels); selector != expected {
+		t.Errorf("expected '%s' found '%s'", expected, selector)
+	}
+}
+
+func TestLokiEncodeJSON(t *testing.T) {
+	ts := time.Unix(1512515349, 858000000)
+	batch := []*lokiStream{{
+		labels:   []lokiLabel{{"level", "INFO"}},
+		selector: `{level="INFO"}`,
+		entries:  []lokiEntry{{ts, "my \"line\""}},
+	}}
+
+	payload, err := encodeLokiJSON(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"streams":[{"stream":{"level":"INFO"},"values":[["1512515349858000000","my \"line\""]]}]}`
+	if payload != expected {
+		t.Errorf("expected '%s' found '%s'", expected, payload)
+	}
+}
+
+func TestLokiEncodeProtobuf(t *testing.T) {
+	batch := []*lokiStream{
+		{selector: `{a="b"}`, entries: []lokiEntry{{time.Unix(1, 2), "l"}, {time.Unix(3, 0), "m"}}},
+		{selector: `{a="c"}`, entries: []lokiEntry{{time.Unix(4, 5), strings.Repeat("long line ", 1000)}}},
+	}
+
+	req, err := snappy.Decode(nil, []byte(encodeLokiProtobuf(batch)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// logproto.PushRequest: repeated StreamAdapter streams = 1
+	streams := protoFields(t, req)[1]
+	if len(streams) != len(batch) {
+		t.Fatalf("expected %d streams found %d", len(batch), len(streams))
+	}
+	for i, s := range streams {
+		// StreamAdapter: string labels = 1, repeated EntryAdapter entries = 2
+		stream := protoFields(t, s)
+		if labels := string(stream[1][0]); labels != batch[i].selector {
+			t.Errorf("expected labels %s found %s", batch[i].selector, labels)
+		}
+		if len(stream[2]) != len(batch[i].entries) {
+			t.Fatalf("expected %d entries found %d", len(batch[i].entries), len(stream[2]))
+		}
+		for j, e := range stream[2] {
+			// EntryAdapter: Timestamp timestamp = 1, string line = 2
+			// Timestamp: int64 seconds = 1, int32 nanos = 2, omitted if zero
+			entry := protoFields(t, e)
+			ts := protoFields(t, entry[1][0])
+			found := fmt.Sprintf("%s.%s %s", ts[1], ts[2], entry[2][0])
+			expected := batch[i].entries[j]
+			nanos := "[]"
+			if expected.Nanosecond() != 0 {
+				nanos = fmt.Sprintf("[%d]", expected.Nanosecond())
+			}
+			if s := fmt.Sprintf("[%d].%s %s", expected.Unix(), nanos, expected.line); found != s {
+				t.Errorf("expected entry %.40s found %.40s", s, found)
+			}
+		}
+	}
+}
+
+// newTestLoki returns a Loki client that pushes to a server which sends the bodies to the returned channel
+func newTestLoki(t *testing.T, cfg LokiConfig) (*Loki, chan string, func()) {
+	bodies := make(chan string, 100)
+	server := httptest.NewServer(stdHttp.HandlerFunc(func(w stdHttp.ResponseWriter, r *stdHttp.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	client, err := http.NewClient(&http.DefaultConfig, make(chan http.Error, 100))
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	cfg.URL = server.URL
+	k, err := NewLoki(&cfg, client)
+	if err != nil {
+		client.Close()
+		server.Close()
+		t.Fatal(err)
+	}
+	return k, bodies, func() {
+		k.Close()
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestLokiSortEntries(t *testing.T) {
+	cfg := DefaultLokiConfig
+	cfg.Labels = nil
+	k, bodies, closeLoki := newTestLoki(t, cfg)
+	defer closeLoki()
+
+	for _, ts := range []string{"2017-12-05 23:09:09,000", "2017-12-05 23:09:07,000", "2017-12-05 23:09:08,000"} {
+		lg := logging.NewLog()
+		lg.Set(logging.KeyTimestamp, ts)
+		lg.Message = ts
+		if err := k.Push(lg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	body := <-bodies
+	first := strings.Index(body, "23:09:07")
+	second := strings.Index(body, "23:09:08")
+	third := strings.Index(body, "23:09:09")
+	if first < 0 || first > second || second > third {
+		t.Errorf("expected entries sorted by timestamp found %s", body)
+	}
+}
+
+func TestLokiInitPush(t *testing.T) {
+	cfg := DefaultLokiConfig
+	cfg.BatchSize = 100
+	cfg.BatchWait = time.Millisecond
+	k, bodies, closeLoki := newTestLoki(t, cfg)
+	defer closeLoki()
+	go func() {
+		for range bodies {
+		}
+	}()
+
+	// logs pushed and flushed while the client is re-initialized must not race with it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			lg := logging.NewLog()
+			lg.Message = "message"
+			k.Push(lg)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		cfg := k.cfg
+		if i%2 == 0 {
+			cfg.Labels = []string{logging.KeyLevel}
+		}
+		if err := k.Init(&cfg, k.client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,193 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+const (
+	// NetFormatText serializes logs with the same format used by the parser
+	NetFormatText = "text"
+	// NetFormatJSON serializes logs in JSON format
+	NetFormatJSON = "json"
+	// NetFramingNewline terminates every message with a newline
+	NetFramingNewline = "newline"
+	// NetFramingLength prefixes every message with its length as a 4 byte big endian integer
+	NetFramingLength = "length"
+)
+
+// NetConfig is a network writer configuration
+type NetConfig struct {
+	// Address of the peer: tcp://host:port, udp://host:port, unix:///path/to/socket or unixgram:///path/to/socket
+	Address string
+	// Format of the logs written with Push: NetFormatText or NetFormatJSON
+	Format string
+	// Framing of the messages written to stream sockets: NetFramingNewline or NetFramingLength.
+	// Messages written to datagram sockets are not framed.
+	Framing string
+	// BufferSize is the number of messages buffered while the peer is unreachable
+	// before the writer applies back-pressure
+	BufferSize int
+}
+
+// DefaultNetConfig is a network writer config with sane defaults
+var DefaultNetConfig = NetConfig{
+	Format:     NetFormatText,
+	Framing:    NetFramingNewline,
+	BufferSize: 1000,
+}
+
+func validateNetConfiguration(cfg *NetConfig) (network, address string, err error) {
+	if cfg.Address == "" {
+		err = fmt.Errorf("config error: socket address is not set")
+		return
+	}
+	var u *url.URL
+	if u, err = url.Parse(cfg.Address); err != nil {
+		err = fmt.Errorf("config error: invalid socket address: %s", err)
+		return
+	}
+	switch u.Scheme {
+	case "tcp", "udp":
+		network, address = u.Scheme, u.Host
+	case "unix", "unixgram":
+		network, address = u.Scheme, u.Host+u.Path
+	default:
+		err = fmt.Errorf("config error: socket address scheme must be one of tcp, udp, unix or unixgram: found '%s'", u.Scheme)
+		return
+	}
+	err = ValidateNetOptions(cfg)
+	return
+}
+
+// ValidateNetOptions returns an error if the format, framing or buffer size
+// of the Net writer configuration are not valid. The address is not checked.
+func ValidateNetOptions(cfg *NetConfig) (err error) {
+	if cfg.Format != NetFormatText && cfg.Format != NetFormatJSON {
+		err = fmt.Errorf("config error: socket format must be one of '%s' or '%s': found '%s'",
+			NetFormatText, NetFormatJSON, cfg.Format)
+		return
+	}
+	if cfg.Framing != NetFramingNewline && cfg.Framing != NetFramingLength {
+		err = fmt.Errorf("config error: socket framing must be one of '%s' or '%s': found '%s'",
+			NetFramingNewline, NetFramingLength, cfg.Framing)
+		return
+	}
+	if cfg.BufferSize < 1 {
+		err = fmt.Errorf("config error: min socket buffer size is 1")
+		return
+	}
+	return
+}
+
+// ValidateNetConfig returns an error if the Net writer configuration is not valid
+func ValidateNetConfig(cfg *NetConfig) (err error) {
+	_, _, err = validateNetConfiguration(cfg)
+	return
+}
+
+// Net is a writer that sends messages to a TCP, UDP or Unix socket. Messages are buffered
+// and written by a background goroutine which reconnects with exponential backoff
+// if the peer is unreachable.
+type Net struct {
+	// lock is held to write so the writer is only closed once the writes in progress return
+	lock     sync.RWMutex
+	cfg      NetConfig
+	datagram bool
+	w        *bufferedWriter
+}
+
+// NewNet allocates enough space to store a Net writer and initializes it.
+// If configuration is nil a default one will be used.
+func NewNet(cfg *NetConfig) (n *Net, err error) {
+	n = new(Net)
+	if err = n.Init(cfg); err != nil {
+		n = nil
+		return
+	}
+	return
+}
+
+// Init initializes this Net writer so it is ready for use.
+// Calling Init after it is initialized will call Close first, writing all the buffered messages, and re-initialize it.
+// The buffered messages are only written once cfg is validated, so an invalid one keeps the running writer.
+func (n *Net) Init(cfg *NetConfig) (err error) {
+	next := DefaultNetConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	network, address, err := validateNetConfiguration(&next)
+	if err != nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.close()
+	n.cfg = next
+	n.datagram = network == "udp" || network == "unixgram"
+	n.w = newBufferedWriter(newConn(network, address), n.cfg.BufferSize)
+	return
+}
+
+func (n *Net) frame(msg []byte) []byte {
+	switch {
+	case n.datagram:
+		return msg
+	case n.cfg.Framing == NetFramingLength:
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(msg)))
+		return append(hdr[:], msg...)
+	default:
+		return append(msg, '\n')
+	}
+}
+
+// Write enqueues the message. It blocks if the buffer is full.
+func (n *Net) Write(msg []byte) error {
+	// frame is appended so msg must be copied
+	frame := make([]byte, len(msg), len(msg)+4)
+	copy(frame, msg)
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if n.w == nil {
+		return errWriterClosed
+	}
+	return n.w.Write(n.frame(frame))
+}
+
+// Push serializes the log with the configured format and enqueues it.
+func (n *Net) Push(lg *logging.Log) error {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	var buf bytes.Buffer
+	if n.cfg.Format == NetFormatJSON {
+		lg.WriteJSONTo(&buf)
+	} else {
+		lg.WriteTo(&buf)
+	}
+	if n.w == nil {
+		return errWriterClosed
+	}
+	return n.w.Write(n.frame(buf.Bytes()))
+}
+
+// Close will block until all the buffered messages have been written or, if the peer
+// is unreachable, discarded. In order to use again this writer instance Init must be used to initialize its resources
+func (n *Net) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.close()
+	return nil
+}
+
+func (n *Net) close() {
+	if n.w != nil {
+		n.w.Close()
+		n.w = nil
+	}
+}
@@ -0,0 +1,141 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func listenUnix(t *testing.T) (net.Listener, string) {
+	dir, err := ioutil.TempDir("", "logd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := path.Join(dir, "out.sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return ln, addr
+}
+
+func TestNetNewline(t *testing.T) {
+	ln, addr := listenUnix(t)
+	defer os.RemoveAll(path.Dir(addr))
+	defer ln.Close()
+
+	lg := logging.NewLog()
+	lg.Level = logging.Info
+	lg.Set("a", "b")
+
+	n, err := NewNet(&NetConfig{Address: "unix://" + addr, Format: NetFormatJSON, Framing: NetFramingNewline, BufferSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Write([]byte("raw message"))
+	n.Push(lg)
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	for _, expected := range []string{"raw message\n", lg.JSON() + "\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != expected {
+			t.Errorf("expected '%s' found '%s': %v", expected, line, err)
+		}
+	}
+	n.Close()
+}
+
+func TestNetLengthPrefix(t *testing.T) {
+	ln, addr := listenUnix(t)
+	defer os.RemoveAll(path.Dir(addr))
+	defer ln.Close()
+
+	n, err := NewNet(&NetConfig{Address: "unix://" + addr, Format: NetFormatText, Framing: NetFramingLength, BufferSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Write([]byte("hello"))
+	n.Close()
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(c, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length := binary.BigEndian.Uint32(b[:4]); length != 5 || string(b[4:]) != "hello" {
+		t.Errorf("expected 5/'hello' found %d/'%s'", length, b[4:])
+	}
+}
+
+func TestNetConfigValidation(t *testing.T) {
+	cfg := DefaultNetConfig
+	for _, addr := range []string{"", "http://localhost", "://"} {
+		cfg.Address = addr
+		if _, err := NewNet(&cfg); err == nil {
+			t.Errorf("expected error for address '%s'", addr)
+		}
+	}
+}
+
+func TestNetWriteInit(t *testing.T) {
+	ln, addr := listenUnix(t)
+	defer os.RemoveAll(path.Dir(addr))
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	cfg := NetConfig{Address: "unix://" + addr, Format: NetFormatText, Framing: NetFramingNewline, BufferSize: 10}
+	n, err := NewNet(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writes in progress must not race with the writer being replaced
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if err := n.Write([]byte("message")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err = n.Init(&cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	n.Close()
+	if err = n.Write([]byte("closed")); err == nil {
+		t.Errorf("expected writes after Close to fail")
+	}
+}
@@ -0,0 +1,30 @@
+// Package output provides serializers and clients to ship logging.Log
+// structures to log aggregation and monitoring systems.
+package output
+
+import (
+	"os"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+// defaultHost is the host reported by outputs when none is configured
+var defaultHost = hostname()
+
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return host
+}
+
+// logTime returns the log timestamp or the current time if log has no valid timestamp
+func logTime(lg *logging.Log) time.Time {
+	t, err := lg.Time()
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
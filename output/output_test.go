@@ -0,0 +1,128 @@
+package output
+
+import (
+	"io"
+	"testing"
+)
+
+func TestInitInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// open creates the client and returns a function that initializes it again with an invalid configuration
+		open func() (c io.Closer, reinit func() error, err error)
+		// kept returns whether the client is still running with the previous configuration
+		kept func(c io.Closer) bool
+	}{
+		{
+			name: "loki",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultLokiConfig
+				cfg.URL = "http://localhost:3100/loki/api/v1/push"
+				k, err := NewLoki(&cfg, nil)
+				return k, func() error { cfg.Format = "xml"; return k.Init(&cfg, nil) }, err
+			},
+			kept: func(c io.Closer) bool {
+				k := c.(*Loki)
+				return k.cfg.Format == DefaultLokiConfig.Format && k.quitchan != nil
+			},
+		},
+		{
+			name: "splunk",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultSplunkConfig
+				cfg.URL = "http://localhost:8088/services/collector/event"
+				cfg.Token = "token"
+				s, err := NewSplunk(&cfg, nil)
+				return s, func() error { cfg.Token = ""; return s.Init(&cfg, nil) }, err
+			},
+			kept: func(c io.Closer) bool {
+				s := c.(*Splunk)
+				return s.cfg.Token == "token" && s.batch != nil
+			},
+		},
+		{
+			name: "gelf",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultGELFConfig
+				cfg.Address = "udp://localhost:12201"
+				g, err := NewGELF(&cfg, nil)
+				return g, func() error { cfg.Address = "ftp://localhost:12201"; return g.Init(&cfg, nil) }, err
+			},
+			kept: func(c io.Closer) bool {
+				g := c.(*GELF)
+				return g.url != nil && g.url.Scheme == "udp" && g.conn != nil
+			},
+		},
+		{
+			name: "syslog",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultSyslogConfig
+				cfg.Address = "tcp://127.0.0.1:514"
+				s, err := NewSyslog(&cfg)
+				return s, func() error { cfg.Address = ""; return s.Init(&cfg) }, err
+			},
+			kept: func(c io.Closer) bool {
+				s := c.(*Syslog)
+				return s.cfg.Address == "tcp://127.0.0.1:514" && s.w != nil
+			},
+		},
+		{
+			name: "net",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultNetConfig
+				cfg.Address = "udp://127.0.0.1:9999"
+				n, err := NewNet(&cfg)
+				return n, func() error { cfg.Framing = "xml"; return n.Init(&cfg) }, err
+			},
+			kept: func(c io.Closer) bool {
+				n := c.(*Net)
+				return n.cfg.Framing == DefaultNetConfig.Framing && n.w != nil
+			},
+		},
+		{
+			name: "statsd",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultStatsDConfig
+				cfg.Address = "127.0.0.1:8125"
+				s, err := NewStatsD(&cfg)
+				return s, func() error { cfg.MaxPacketSize = 0; return s.Init(&cfg) }, err
+			},
+			kept: func(c io.Closer) bool {
+				s := c.(*StatsD)
+				return s.cfg.MaxPacketSize == DefaultStatsDConfig.MaxPacketSize && s.conn != nil
+			},
+		},
+		{
+			name: "influx",
+			open: func() (io.Closer, func() error, error) {
+				cfg := DefaultInfluxConfig
+				cfg.URL = "http://localhost:8086"
+				cfg.Bucket = "logs"
+				i, err := NewInflux(&cfg, nil)
+				return i, func() error { cfg.Precision = "m"; return i.Init(&cfg, nil) }, err
+			},
+			kept: func(c io.Closer) bool {
+				i := c.(*Influx)
+				return i.cfg.Precision == DefaultInfluxConfig.Precision && i.batch != nil
+			},
+		},
+	}
+	for _, test := range tests {
+		c, reinit, err := test.open()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if err = reinit(); err == nil {
+			t.Errorf("%s: expected error initializing the client with an invalid config", test.name)
+		}
+		if !test.kept(c) {
+			t.Errorf("%s: expected client to keep running with the previous config", test.name)
+		}
+		// the client can be closed more than once
+		for i := 0; i < 2; i++ {
+			if err = c.Close(); err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+		}
+	}
+}
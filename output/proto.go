@@ -0,0 +1,26 @@
+package output
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// minimal protocol buffers encoding helpers on top of the wire format
+
+func protoAppendInt(b []byte, field protowire.Number, v int64) []byte {
+	// zero is the default value, which is not encoded
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func protoAppendString(b []byte, field protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func protoAppendMessage(b []byte, field protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
@@ -0,0 +1,153 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	stdHttp "net/http"
+	"time"
+
+	"github.com/ernestrc/logd/http"
+	"github.com/ernestrc/logd/logging"
+)
+
+// SplunkConfig is a Splunk HTTP Event Collector client configuration
+type SplunkConfig struct {
+	// URL of the event endpoint. i.e. https://localhost:8088/services/collector/event
+	URL string
+	// Token is the HEC token sent in the Authorization header
+	Token string
+	// Host, Source, SourceType and Index are the event metadata.
+	// Empty values are not sent, except for Host which defaults to the machine hostname.
+	Host       string
+	Source     string
+	SourceType string
+	Index      string
+	// BatchSize is the maximum number of bytes of events buffered before sending a batch
+	BatchSize int
+	// BatchWait is the maximum amount of time an event is buffered before sending a batch
+	BatchWait time.Duration
+}
+
+// DefaultSplunkConfig is a Splunk client config with sane defaults
+var DefaultSplunkConfig = SplunkConfig{
+	BatchSize: 1024 * 1024,
+	BatchWait: time.Second,
+}
+
+func validateSplunkConfiguration(cfg *SplunkConfig) (err error) {
+	if cfg.URL == "" {
+		err = fmt.Errorf("config error: splunk url is not set")
+		return
+	}
+	if cfg.Token == "" {
+		err = fmt.Errorf("config error: splunk token is not set")
+		return
+	}
+	if cfg.BatchSize < 1 {
+		err = fmt.Errorf("config error: min splunk batch size is 1")
+		return
+	}
+	if cfg.BatchWait <= 0 {
+		err = fmt.Errorf("config error: splunk batch wait must be positive")
+		return
+	}
+	return
+}
+
+// WriteSplunkEvent serializes the log into a HTTP Event Collector event envelope
+func WriteSplunkEvent(buf *bytes.Buffer, lg *logging.Log, cfg *SplunkConfig) {
+	buf.WriteString(`{"time":`)
+	writeEpoch(buf, lg)
+	host := cfg.Host
+	if host == "" {
+		host = defaultHost
+	}
+	for _, f := range [][2]string{
+		{"host", host},
+		{"source", cfg.Source},
+		{"sourcetype", cfg.SourceType},
+		{"index", cfg.Index},
+	} {
+		if f[1] != "" {
+			writeJSONField(buf, false, f[0], f[1])
+		}
+	}
+	buf.WriteString(`,"event":{`)
+	empty := writeJSONLog(buf, lg)
+	if lg.Message != "" {
+		writeJSONField(buf, empty, logging.KeyMessage, lg.Message)
+	}
+	buf.WriteString(`}}`)
+}
+
+// Splunk is a client that batches logs as HTTP Event Collector events and
+// sends them to Splunk via an http.AsyncClient.
+type Splunk struct {
+	cfg    SplunkConfig
+	client *http.AsyncClient
+	batch  *batcher
+}
+
+// NewSplunk allocates enough space to store a Splunk client and initializes it.
+// If configuration is nil a default one will be used.
+func NewSplunk(cfg *SplunkConfig, client *http.AsyncClient) (s *Splunk, err error) {
+	s = new(Splunk)
+	if err = s.Init(cfg, client); err != nil {
+		s = nil
+		return
+	}
+	return
+}
+
+// Init initializes this Splunk client so it is ready for use.
+// Calling Init after it is initialized will call Close first, sending all the pending events, and re-initialize it.
+// If cfg is not valid, an error is returned and the current batch keeps its events.
+func (s *Splunk) Init(cfg *SplunkConfig, client *http.AsyncClient) (err error) {
+	next := DefaultSplunkConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	if err = validateSplunkConfiguration(&next); err != nil {
+		return
+	}
+	// a failure to submit the pending batch is returned once the client is re-initialized
+	if s.batch != nil {
+		err = s.Close()
+	}
+	s.cfg = next
+	s.client = client
+	s.batch = newBatcher(s.cfg.BatchSize, s.cfg.BatchWait, '\n', s.send)
+	return
+}
+
+func (s *Splunk) send(payload string) error {
+	header := make(stdHttp.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Splunk "+s.cfg.Token)
+	return s.client.PostWithHeader(s.cfg.URL, payload, header, -1)
+}
+
+// Push adds the log to the current batch.
+// If batch size is reached, the batch is submitted to the HTTP client.
+func (s *Splunk) Push(lg *logging.Log) error {
+	return s.batch.Write(func(buf *bytes.Buffer) {
+		WriteSplunkEvent(buf, lg, &s.cfg)
+	})
+}
+
+// Flush submits the current batch to the HTTP client
+func (s *Splunk) Flush() error {
+	return s.batch.Flush()
+}
+
+// Close stops the periodic flushing of batches and submits the current batch
+// to the HTTP client. In order to use again this client instance Init must be used to initialize its resources.
+// Calling Close more than once has no effect.
+func (s *Splunk) Close() (err error) {
+	if s.batch == nil {
+		return
+	}
+	err = s.batch.Close()
+	s.batch = nil
+	return
+}
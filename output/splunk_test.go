@@ -0,0 +1,38 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+func TestWriteSplunkEvent(t *testing.T) {
+	lg := logging.Parse("2017-09-07 14:54:39,474	WARN	[main]	core.Main	flow: Publish, step: \"Attempt\"\n")[0]
+	ts, _ := lg.Time()
+	cfg := SplunkConfig{Host: "myhost", SourceType: "logd"}
+
+	var buf bytes.Buffer
+	WriteSplunkEvent(&buf, &lg, &cfg)
+
+	expected := `{"time":` + formatEpoch(ts.UnixNano()) + `,"host":"myhost","sourcetype":"logd","event":` +
+		`{"level":"WARN","thread":"main","class":"core.Main","flow":"Publish","step":"\"Attempt\""}}`
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestWriteSplunkEventMessage(t *testing.T) {
+	lg := logging.NewLog()
+	lg.Set(logging.KeyTimestamp, "2017-09-07 14:54:39,004")
+	lg.Message = "my\tmessage"
+	ts, _ := lg.Time()
+
+	var buf bytes.Buffer
+	WriteSplunkEvent(&buf, lg, &SplunkConfig{Host: "h"})
+
+	expected := `{"time":` + formatEpoch(ts.UnixNano()) + `,"host":"h","event":{"msg":"my\tmessage"}}`
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
@@ -0,0 +1,271 @@
+package output
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StatsD metric types
+const (
+	StatsDCounter   = "c"
+	StatsDGauge     = "g"
+	StatsDTiming    = "ms"
+	StatsDSet       = "s"
+	StatsDHistogram = "h"
+)
+
+// StatsDConfig is a StatsD client configuration
+type StatsDConfig struct {
+	// Address of the StatsD server: host:port
+	Address string
+	// Prefix is prepended to the name of every metric
+	Prefix string
+	// Tags are DogStatsD tags sent with every metric: "key:value" or "key"
+	Tags []string
+	// FlushInterval is the max time a metric is buffered before it is sent
+	FlushInterval time.Duration
+	// MaxPacketSize is the max size of the UDP packets. Metrics are sent as soon as the
+	// buffered metrics do not fit in a packet.
+	MaxPacketSize int
+}
+
+// DefaultStatsDConfig is a StatsD client config with sane defaults.
+// Max packet size fits in the MTU of most networks.
+var DefaultStatsDConfig = StatsDConfig{
+	Address:       "127.0.0.1:8125",
+	FlushInterval: 100 * time.Millisecond,
+	MaxPacketSize: 1432,
+}
+
+func validateStatsDConfiguration(cfg *StatsDConfig) error {
+	if cfg.Address == "" {
+		return fmt.Errorf("config error: statsd address is not set")
+	}
+	if cfg.FlushInterval <= 0 {
+		return fmt.Errorf("config error: statsd flush interval must be positive")
+	}
+	if cfg.MaxPacketSize < 1 {
+		return fmt.Errorf("config error: min statsd max packet size is 1")
+	}
+	return nil
+}
+
+// StatsD is a buffered UDP StatsD client that supports DogStatsD tags. Metrics are
+// sent in packets of up to MaxPacketSize bytes every FlushInterval.
+type StatsD struct {
+	cfg      StatsDConfig
+	lock     sync.Mutex
+	conn     net.Conn
+	buf      []byte
+	tags     string
+	quitchan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewStatsD allocates enough space to store a StatsD client and initializes it.
+// If configuration is nil a default one will be used.
+func NewStatsD(cfg *StatsDConfig) (s *StatsD, err error) {
+	s = new(StatsD)
+	if err = s.Init(cfg); err != nil {
+		s = nil
+		return
+	}
+	return
+}
+
+// Init initializes this StatsD client so it is ready for use.
+// Calling Init after it is initialized will call Close first, sending all the buffered metrics, and re-initialize it.
+// The connection is only replaced once cfg is validated and the new address is dialed.
+func (s *StatsD) Init(cfg *StatsDConfig) (err error) {
+	next := DefaultStatsDConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	if err = validateStatsDConfiguration(&next); err != nil {
+		return
+	}
+	conn, err := net.Dial("udp", next.Address)
+	if err != nil {
+		return
+	}
+	// a failure to close the previous connection is returned once the client is re-initialized
+	err = s.Close()
+	s.cfg = next
+	s.conn = conn
+	s.tags = strings.Join(s.cfg.Tags, ",")
+	s.buf = make([]byte, 0, s.cfg.MaxPacketSize)
+	s.quitchan = make(chan struct{})
+	s.wg.Add(1)
+	go s.flusher()
+	return
+}
+
+func (s *StatsD) flusher() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.quitchan:
+			return
+		}
+	}
+}
+
+// flush sends the buffered metrics. Caller must hold the lock.
+func (s *StatsD) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.buf); err != nil {
+		log.WithFields(log.Fields{
+			"tag":     "StatsDWriteFailure",
+			"address": s.cfg.Address,
+			"error":   err,
+		}).Debug()
+	}
+	s.buf = s.buf[:0]
+}
+
+// Flush sends the buffered metrics
+func (s *StatsD) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flush()
+}
+
+func validateStatsDName(name string) error {
+	if name == "" {
+		return fmt.Errorf("statsd metric name is empty")
+	}
+	if strings.ContainsAny(name, ":|@\n") {
+		return fmt.Errorf("invalid statsd metric name '%s'", name)
+	}
+	return nil
+}
+
+// format formats a metric in the DogStatsD line format: <name>:<value>|<type>[|@<rate>][|#<tags>]
+func (s *StatsD) format(name, value, typ string, rate float64, tags []string) []byte {
+	b := make([]byte, 0, len(s.cfg.Prefix)+len(name)+len(value)+len(typ)+len(s.tags)+16)
+	b = append(b, s.cfg.Prefix...)
+	b = append(b, name...)
+	b = append(b, ':')
+	b = append(b, value...)
+	b = append(b, '|')
+	b = append(b, typ...)
+	if rate < 1 {
+		b = append(b, "|@"...)
+		b = strconv.AppendFloat(b, rate, 'f', -1, 64)
+	}
+	if s.tags != "" || len(tags) > 0 {
+		b = append(b, "|#"...)
+		b = append(b, s.tags...)
+		for i, tag := range tags {
+			if i > 0 || s.tags != "" {
+				b = append(b, ',')
+			}
+			b = append(b, tag...)
+		}
+	}
+	return b
+}
+
+// Send buffers a metric of the given type. If rate is less than 1, the metric is only sent
+// with the given probability and the rate is sent so the server can scale it.
+func (s *StatsD) Send(name, value, typ string, rate float64, tags []string) error {
+	if err := validateStatsDName(name); err != nil {
+		return err
+	}
+	if rate < 1 && rand.Float64() >= rate {
+		return nil
+	}
+	return s.write(s.format(name, value, typ, rate, tags))
+}
+
+// write buffers the formatted metrics
+func (s *StatsD) write(metric []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		return fmt.Errorf("statsd client is not initialized or was closed")
+	}
+	if len(s.buf) > 0 && len(s.buf)+1+len(metric) > s.cfg.MaxPacketSize {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, metric...)
+	if len(s.buf) >= s.cfg.MaxPacketSize {
+		s.flush()
+	}
+	return nil
+}
+
+func formatStatsDValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Count sends a counter increment
+func (s *StatsD) Count(name string, value float64, rate float64, tags []string) error {
+	return s.Send(name, formatStatsDValue(value), StatsDCounter, rate, tags)
+}
+
+// Gauge sends a gauge value. StatsD reads signed gauge values as a change of the
+// current value, so negative values are sent after setting the gauge to 0.
+func (s *StatsD) Gauge(name string, value float64, tags []string) error {
+	if value >= 0 {
+		return s.Send(name, formatStatsDValue(value), StatsDGauge, 1, tags)
+	}
+	if err := validateStatsDName(name); err != nil {
+		return err
+	}
+	// both lines are buffered together so they are sent in the same packet
+	metric := s.format(name, "0", StatsDGauge, 1, tags)
+	metric = append(metric, '\n')
+	metric = append(metric, s.format(name, formatStatsDValue(value), StatsDGauge, 1, tags)...)
+	return s.write(metric)
+}
+
+// Timing sends a duration in milliseconds
+func (s *StatsD) Timing(name string, ms float64, rate float64, tags []string) error {
+	return s.Send(name, formatStatsDValue(ms), StatsDTiming, rate, tags)
+}
+
+// Set sends a value whose unique occurrences are counted by the server
+func (s *StatsD) Set(name string, value string, tags []string) error {
+	if strings.ContainsAny(value, "|\n") {
+		return fmt.Errorf("invalid statsd set value '%s'", value)
+	}
+	return s.Send(name, value, StatsDSet, 1, tags)
+}
+
+// Histogram sends a DogStatsD histogram value
+func (s *StatsD) Histogram(name string, value float64, rate float64, tags []string) error {
+	return s.Send(name, formatStatsDValue(value), StatsDHistogram, rate, tags)
+}
+
+// Close sends the buffered metrics and closes the connection.
+// In order to use again this client instance Init must be used to initialize its resources
+func (s *StatsD) Close() (err error) {
+	if s.conn == nil {
+		return
+	}
+	close(s.quitchan)
+	s.wg.Wait()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flush()
+	err = s.conn.Close()
+	s.conn = nil
+	return
+}
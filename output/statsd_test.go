@@ -0,0 +1,125 @@
+package output
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listenUDP(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsD(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	s, err := NewStatsD(&StatsDConfig{
+		Address:       conn.LocalAddr().String(),
+		Prefix:        "app.",
+		Tags:          []string{"env:test"},
+		FlushInterval: time.Hour,
+		MaxPacketSize: 1432,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Count("requests", 1, 1, []string{"code:200"})
+	s.Gauge("queue", 2.5, nil)
+	s.Timing("latency", 12, 0.99999999, nil)
+	s.Set("users", "alice", nil)
+	s.Histogram("size", 512, 1, []string{"a", "b:c"})
+	if err = s.Count("invalid:name", 1, 1, nil); err == nil {
+		t.Errorf("expected error sending metric with invalid name")
+	}
+	s.Flush()
+
+	packet := readPacket(t, conn)
+	lines := strings.Split(packet, "\n")
+	// timing may have been sampled out
+	if lines[2] == "app.latency:12|ms|@0.99999999|#env:test" {
+		lines = append(lines[:2], lines[3:]...)
+	}
+	expected := []string{
+		"app.requests:1|c|#env:test,code:200",
+		"app.queue:2.5|g|#env:test",
+		"app.users:alice|s|#env:test",
+		"app.size:512|h|#env:test,a,b:c",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected packet:\n%s", packet)
+	}
+	s.Close()
+}
+
+func TestStatsDMaxPacketSize(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	s, err := NewStatsD(&StatsDConfig{
+		Address:       conn.LocalAddr().String(),
+		FlushInterval: time.Hour,
+		MaxPacketSize: 15,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Count("a", 1, 1, nil)
+	s.Count("b", 1, 1, nil)
+	s.Count("c", 1, 1, nil)
+	s.Close()
+
+	for _, expected := range []string{"a:1|c\nb:1|c", "c:1|c"} {
+		if packet := readPacket(t, conn); packet != expected {
+			t.Errorf("expected '%s' found '%s'", expected, packet)
+		}
+	}
+}
+
+func TestStatsDConfigValidation(t *testing.T) {
+	for _, cfg := range []StatsDConfig{
+		{FlushInterval: time.Second, MaxPacketSize: 1},
+		{Address: "127.0.0.1:8125", MaxPacketSize: 1},
+		{Address: "127.0.0.1:8125", FlushInterval: time.Second},
+	} {
+		if _, err := NewStatsD(&cfg); err == nil {
+			t.Errorf("expected config error for %+v", cfg)
+		}
+	}
+}
+
+func TestStatsDNegativeGauge(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	s, err := NewStatsD(&StatsDConfig{
+		Address:       conn.LocalAddr().String(),
+		FlushInterval: time.Hour,
+		MaxPacketSize: 1432,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Gauge("temp", -3, []string{"room:a"})
+	s.Close()
+
+	expected := "temp:0|g|#room:a\ntemp:-3|g|#room:a"
+	if packet := readPacket(t, conn); packet != expected {
+		t.Errorf("expected '%s' found '%s'", expected, packet)
+	}
+}
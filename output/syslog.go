@@ -0,0 +1,382 @@
+package output
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+const (
+	// SyslogFormatRFC5424 is the syslog protocol format. Properties are sent as structured data.
+	SyslogFormatRFC5424 = "rfc5424"
+	// SyslogFormatRFC3164 is the legacy BSD syslog format. The log string is sent as message.
+	SyslogFormatRFC3164 = "rfc3164"
+)
+
+const (
+	syslogNilValue    = "-"
+	syslogMaxHostname = 255
+	syslogMaxAppName  = 48
+	syslogMaxMsgID    = 32
+	syslogMaxSDName   = 32
+	syslogMaxTag      = 32
+	syslogTimeRFC5424 = "2006-01-02T15:04:05.000000Z07:00"
+	syslogTimeRFC3164 = time.Stamp
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacility returns the facility code of the given facility name or number
+func SyslogFacility(name string) (int, error) {
+	if f, ok := syslogFacilities[strings.ToLower(name)]; ok {
+		return f, nil
+	}
+	if f, err := strconv.Atoi(name); err == nil && f >= 0 && f <= 23 {
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid syslog facility: '%s'", name)
+}
+
+// SyslogConfig is a syslog client configuration
+type SyslogConfig struct {
+	// Address of the syslog receiver: udp://host:514, tcp://host:601 or tls://host:6514
+	Address string
+	// Format is either SyslogFormatRFC5424 or SyslogFormatRFC3164.
+	// If empty, RFC 3164 is used over UDP and RFC 5424 over TCP and TLS.
+	Format   string
+	Facility int
+	// Hostname defaults to the machine hostname
+	Hostname string
+	AppName  string
+	// SDID is the structured data element id where properties are sent
+	SDID string
+	// BufferSize is the number of messages buffered while the receiver is unreachable
+	// before the client applies back-pressure
+	BufferSize int
+	// TLS options
+	TLSCA                 string
+	TLSCert               string
+	TLSKey                string
+	TLSInsecureSkipVerify bool
+}
+
+// DefaultSyslogConfig is a syslog client config with sane defaults
+var DefaultSyslogConfig = SyslogConfig{
+	Facility:   1,
+	AppName:    "logd",
+	SDID:       "logd@32473",
+	BufferSize: 1000,
+}
+
+// SyslogHeader holds the fields of a syslog message that are not taken from the log.
+// Severity is taken from the log level if it is negative.
+type SyslogHeader struct {
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	MsgID    string
+	SDID     string
+}
+
+// Header returns the default message header of this configuration
+func (cfg *SyslogConfig) Header() SyslogHeader {
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname = defaultHost
+	}
+	return SyslogHeader{
+		Facility: cfg.Facility,
+		Severity: -1,
+		Hostname: hostname,
+		AppName:  cfg.AppName,
+		SDID:     cfg.SDID,
+	}
+}
+
+func validateSyslogConfiguration(cfg *SyslogConfig) (u *url.URL, err error) {
+	if cfg.Address == "" {
+		err = fmt.Errorf("config error: syslog address is not set")
+		return
+	}
+	if u, err = url.Parse(cfg.Address); err != nil {
+		err = fmt.Errorf("config error: invalid syslog address: %s", err)
+		return
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		err = fmt.Errorf("config error: syslog address scheme must be one of udp, tcp or tls: found '%s'", u.Scheme)
+		return
+	}
+	switch cfg.Format {
+	case "", SyslogFormatRFC5424, SyslogFormatRFC3164:
+	default:
+		err = fmt.Errorf("config error: syslog format must be one of '%s' or '%s': found '%s'",
+			SyslogFormatRFC5424, SyslogFormatRFC3164, cfg.Format)
+		return
+	}
+	if cfg.Facility < 0 || cfg.Facility > 23 {
+		err = fmt.Errorf("config error: syslog facility must be between 0 and 23")
+		return
+	}
+	if cfg.BufferSize < 1 {
+		err = fmt.Errorf("config error: min syslog buffer size is 1")
+		return
+	}
+	return
+}
+
+// writeSyslogName writes s replacing characters that are not printable US-ASCII
+// or that are in the excluded set, truncating it to max bytes.
+func writeSyslogName(buf *bytes.Buffer, s string, max int, exclude string) {
+	if s == "" {
+		buf.WriteString(syslogNilValue)
+		return
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 || strings.IndexByte(exclude, c) >= 0 {
+			c = '_'
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func writeSyslogPri(buf *bytes.Buffer, lg *logging.Log, h *SyslogHeader) {
+	severity := h.Severity
+	if severity < 0 {
+		severity = Severity(lg.Level)
+	}
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(h.Facility*8 + severity))
+	buf.WriteByte('>')
+}
+
+func writeSyslogParam(buf *bytes.Buffer, key, value string) {
+	buf.WriteByte(' ')
+	writeSyslogName(buf, key, syslogMaxSDName, `= ]"`)
+	buf.WriteString(`="`)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// WriteRFC5424 serializes the log into a RFC 5424 syslog message.
+// Thread, class and properties are sent as parameters of the h.SDID structured data element.
+func WriteRFC5424(buf *bytes.Buffer, lg *logging.Log, h *SyslogHeader) {
+	writeSyslogPri(buf, lg, h)
+	buf.WriteString("1 ")
+	buf.WriteString(logTime(lg).Format(syslogTimeRFC5424))
+	buf.WriteByte(' ')
+	writeSyslogName(buf, h.Hostname, syslogMaxHostname, "")
+	buf.WriteByte(' ')
+	writeSyslogName(buf, h.AppName, syslogMaxAppName, "")
+	buf.WriteByte(' ')
+	buf.WriteString(syslogNilValue) // PROCID
+	buf.WriteByte(' ')
+	writeSyslogName(buf, h.MsgID, syslogMaxMsgID, "")
+	buf.WriteByte(' ')
+
+	props := lg.Props()
+	if lg.Thread == "" && lg.Class == "" && len(props) == 0 {
+		buf.WriteString(syslogNilValue)
+	} else {
+		buf.WriteByte('[')
+		writeSyslogName(buf, h.SDID, syslogMaxSDName, `= ]"`)
+		if lg.Thread != "" {
+			writeSyslogParam(buf, logging.KeyThread, lg.Thread)
+		}
+		if lg.Class != "" {
+			writeSyslogParam(buf, logging.KeyClass, lg.Class)
+		}
+		for _, p := range props {
+			writeSyslogParam(buf, p.Key(), p.Value())
+		}
+		buf.WriteByte(']')
+	}
+
+	if lg.Message != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(lg.Message)
+	}
+}
+
+// WriteRFC3164 serializes the log into a RFC 3164 syslog message. The log string is sent as message content.
+func WriteRFC3164(buf *bytes.Buffer, lg *logging.Log, h *SyslogHeader) {
+	writeSyslogPri(buf, lg, h)
+	buf.WriteString(logTime(lg).Format(syslogTimeRFC3164))
+	buf.WriteByte(' ')
+	writeSyslogName(buf, h.Hostname, syslogMaxHostname, "")
+	buf.WriteByte(' ')
+	writeSyslogName(buf, h.AppName, syslogMaxTag, ":[")
+	buf.WriteString(": ")
+	lg.WriteTo(buf)
+}
+
+// Syslog is a client that forwards logs to a remote syslog receiver.
+// Messages are buffered and written by a background goroutine which reconnects
+// with exponential backoff if the receiver is unreachable.
+type Syslog struct {
+	cfg      SyslogConfig
+	format   string
+	datagram bool
+	octets   bool
+	w        *bufferedWriter
+}
+
+// NewSyslog allocates enough space to store a Syslog client and initializes it.
+// If configuration is nil a default one will be used.
+func NewSyslog(cfg *SyslogConfig) (s *Syslog, err error) {
+	s = new(Syslog)
+	if err = s.Init(cfg); err != nil {
+		s = nil
+		return
+	}
+	return
+}
+
+func loadTLSConfig(host, ca, cert, key string, insecure bool) (cfg *tls.Config, err error) {
+	cfg = &tls.Config{ServerName: host, InsecureSkipVerify: insecure}
+	if ca != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(ca); err != nil {
+			return
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificates found in %s", ca)
+			return
+		}
+	}
+	if cert != "" || key != "" {
+		var pair tls.Certificate
+		if pair, err = tls.LoadX509KeyPair(cert, key); err != nil {
+			return
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return
+}
+
+// Init initializes this Syslog client so it is ready for use.
+// Calling Init after it is initialized will call Close first, writing all the buffered messages, and re-initialize it.
+// An invalid address or TLS configuration leaves the current connection open.
+func (s *Syslog) Init(cfg *SyslogConfig) (err error) {
+	next := DefaultSyslogConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	u, err := validateSyslogConfiguration(&next)
+	if err != nil {
+		return
+	}
+
+	format := next.Format
+	if format == "" {
+		if u.Scheme == "udp" {
+			format = SyslogFormatRFC3164
+		} else {
+			format = SyslogFormatRFC5424
+		}
+	}
+
+	var c *conn
+	var datagram, octets bool
+	switch u.Scheme {
+	case "udp":
+		c = newConn("udp", u.Host)
+		datagram = true
+	case "tcp":
+		c = newConn("tcp", u.Host)
+		octets = format == SyslogFormatRFC5424
+	case "tls":
+		var tlsConfig *tls.Config
+		if tlsConfig, err = loadTLSConfig(u.Hostname(), next.TLSCA, next.TLSCert,
+			next.TLSKey, next.TLSInsecureSkipVerify); err != nil {
+			return
+		}
+		c = newConn("tcp", u.Host)
+		c.dial = func(network, address string) (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: defaultDialTimeout}, network, address, tlsConfig)
+		}
+		octets = format == SyslogFormatRFC5424
+	}
+
+	if err = s.Close(); err != nil {
+		return
+	}
+	s.cfg = next
+	s.format = format
+	s.datagram = datagram
+	s.octets = octets
+	s.w = newBufferedWriter(c, s.cfg.BufferSize)
+	return
+}
+
+// Header returns the default message header
+func (s *Syslog) Header() SyslogHeader {
+	return s.cfg.Header()
+}
+
+// Push forwards the log using the default message header
+func (s *Syslog) Push(lg *logging.Log) error {
+	h := s.cfg.Header()
+	return s.Send(lg, &h)
+}
+
+// Send serializes the log with the given header and enqueues it.
+// It blocks if the buffer is full.
+func (s *Syslog) Send(lg *logging.Log, h *SyslogHeader) error {
+	var buf bytes.Buffer
+	if s.format == SyslogFormatRFC3164 {
+		WriteRFC3164(&buf, lg, h)
+	} else {
+		WriteRFC5424(&buf, lg, h)
+	}
+	return s.w.Write(s.frame(buf.Bytes()))
+}
+
+func (s *Syslog) frame(msg []byte) []byte {
+	switch {
+	case s.datagram:
+		return msg
+	case s.octets:
+		return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	default:
+		return append(msg, '\n')
+	}
+}
+
+// Close will block until all the buffered messages have been written or, if the receiver
+// is unreachable, discarded. In order to use again this client instance Init must be used to initialize its resources
+func (s *Syslog) Close() error {
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ernestrc/logd/logging"
+)
+
+var syslogHeader = SyslogHeader{
+	Facility: 16,
+	Severity: -1,
+	Hostname: "myhost",
+	AppName:  "my app",
+	SDID:     "logd@32473",
+}
+
+func TestWriteRFC5424(t *testing.T) {
+	lg := logging.Parse("2017-09-07 14:54:39,474	WARN	[main]	core.Main	flow: Publish, err: a]\"b, done\n")[0]
+	ts, _ := lg.Time()
+
+	var buf bytes.Buffer
+	WriteRFC5424(&buf, &lg, &syslogHeader)
+
+	expected := "<132>1 " + ts.Format(syslogTimeRFC5424) + " myhost my_app - - " +
+		`[logd@32473 thread="main" class="core.Main" flow="Publish" err="a\]\"b"] done`
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestWriteRFC5424NoData(t *testing.T) {
+	lg := logging.NewLog()
+	lg.Set(logging.KeyTimestamp, "2017-09-07 14:54:39,474")
+	lg.Level = "ERROR"
+	ts, _ := lg.Time()
+
+	h := syslogHeader
+	h.Severity = 7
+	h.MsgID = "ID47"
+
+	var buf bytes.Buffer
+	WriteRFC5424(&buf, lg, &h)
+
+	expected := "<135>1 " + ts.Format(syslogTimeRFC5424) + " myhost my_app - ID47 -"
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestWriteRFC3164(t *testing.T) {
+	line := "2017-09-07 14:54:39,474	ERROR	[main]	core.Main	flow: Publish, step: Attempt"
+	lg := logging.Parse(line + "\n")[0]
+	ts, _ := lg.Time()
+
+	var buf bytes.Buffer
+	WriteRFC3164(&buf, &lg, &syslogHeader)
+
+	expected := "<131>" + ts.Format(syslogTimeRFC3164) + " myhost my_app: " + line
+	if buf.String() != expected {
+		t.Errorf("expected '%s' found '%s'", expected, buf.String())
+	}
+}
+
+func TestSyslogFacility(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected int
+		err      bool
+	}{
+		{"local0", 16, false},
+		{"USER", 1, false},
+		{"3", 3, false},
+		{"24", 0, true},
+		{"unknown", 0, true},
+	}
+
+	for _, tcase := range testCases {
+		f, err := SyslogFacility(tcase.name)
+		if f != tcase.expected || (err != nil) != tcase.err {
+			t.Errorf("expected %d/%t for '%s' found %d/%v", tcase.expected, tcase.err, tcase.name, f, err)
+		}
+	}
+}
+
+func TestSyslogReinitFraming(t *testing.T) {
+	cfg := DefaultSyslogConfig
+	cfg.Address = "udp://127.0.0.1:514"
+	s, err := NewSyslog(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.datagram || s.octets {
+		t.Errorf("expected datagram framing with udp")
+	}
+
+	cfg.Address = "tcp://127.0.0.1:514"
+	if err = s.Init(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if s.datagram || !s.octets {
+		t.Errorf("expected octet counting framing with tcp and rfc5424")
+	}
+
+	cfg.Format = SyslogFormatRFC3164
+	if err = s.Init(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if s.datagram || s.octets {
+		t.Errorf("expected newline framing with tcp and rfc3164")
+	}
+	if frame := s.frame([]byte("msg")); string(frame) != "msg\n" {
+		t.Errorf("expected 'msg\\n' found '%s'", frame)
+	}
+}